@@ -15,11 +15,11 @@ func main() {
 	defer store.Close()
 
 	// Set some values
-	line1, err := store.Set([]byte("Hello, Line Store! 👋"))
+	line1, err := store.SetString("Hello, Line Store! 👋")
 	if err != nil {
 		log.Fatal(err)
 	}
-	_, err = store.Set([]byte("Goodbye!"))
+	_, err = store.SetString("Goodbye!")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -56,9 +56,9 @@ func main() {
 	fmt.Println("\nLast line number:", lastLine)
 
 	// Retrieve a value
-	value, err := store.Get(line1)
+	value, err := store.GetString(line1)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("\nGet line", line1, ":", string(value))
+	fmt.Println("\nGet line", line1, ":", value)
 }
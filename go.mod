@@ -1,3 +1,5 @@
 module github.com/cryptrunner49/linestore
 
 go 1.23.7
+
+require github.com/klauspost/compress v1.18.0
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// autoBackupSuffix ends the data file name of every backup taken by WithAutoBackup, so
+// they can be told apart from the index and segment files next to them.
+const autoBackupSuffix = ".backup"
+
+// autoBackupLayout formats the time a backup was taken in its name.
+const autoBackupLayout = "20060102T150405.000000000Z"
+
+// autoBackup takes hot backups in the background, for WithAutoBackup.
+type autoBackup struct {
+	once sync.Once
+	stop chan struct{} // Closed to take a final backup and stop the goroutine
+	done chan struct{} // Closed once the goroutine has returned
+}
+
+// startAutoBackup starts a goroutine that takes a backup every interval, and once more
+// when stopAutoBackup is called. Failures are reported to the logger.
+func (s *Store) startAutoBackup(interval time.Duration) {
+	a := &autoBackup{stop: make(chan struct{}), done: make(chan struct{})}
+	s.backups = a
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stop:
+				s.autoBackupOnce()
+				return
+			case <-ticker.C:
+				s.autoBackupOnce()
+			}
+		}
+	}()
+}
+
+// stopAutoBackup has the backup goroutine, if any, take its final backup and waits for
+// it to return. It must be called without holding the lock.
+func (s *Store) stopAutoBackup() {
+	a := s.backups
+	if a == nil {
+		return
+	}
+	a.once.Do(func() {
+		close(a.stop)
+		<-a.done
+	})
+}
+
+// LastBackup returns when the last backup taken by WithAutoBackup finished, or the
+// zero time if none has yet.
+func (s *Store) LastBackup() time.Time {
+	nanos := s.lastBackup.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// autoBackupOnce takes a backup into the backup directory and removes the ones beyond
+// the number to keep, logging any failure.
+func (s *Store) autoBackupOnce() {
+	err := s.takeAutoBackup()
+	if err != nil {
+		s.opts.logger.Printf("linestore: auto backup failed store=%q err=%q", s.name(), err)
+	}
+}
+
+// takeAutoBackup does the work of autoBackupOnce. A backup that conflicts with a
+// compaction is retried a few times.
+func (s *Store) takeAutoBackup() error {
+	dir := s.opts.backupDir
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	now := time.Now()
+	path := filepath.Join(dir, filepath.Base(s.path)+"-"+now.UTC().Format(autoBackupLayout)+autoBackupSuffix)
+	for attempt := 0; ; attempt++ {
+		err = s.HotBackup(path)
+		if err == nil || !errors.Is(err, ErrConflict) || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.lastBackup.Store(time.Now().UnixNano())
+	return s.pruneAutoBackups()
+}
+
+// pruneAutoBackups removes the oldest automatic backups of the store beyond the number
+// to keep.
+func (s *Store) pruneAutoBackups() error {
+	keep := s.opts.backupKeep
+	if keep < 1 {
+		return nil
+	}
+	dir := s.opts.backupDir
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backup directory: %v", err)
+	}
+	prefix := filepath.Base(s.path) + "-"
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, autoBackupSuffix)
+		if !ok {
+			continue
+		}
+		// Backups of other stores whose names start with this one's have no timestamp here
+		_, err = time.Parse(autoBackupLayout, stamp)
+		if err == nil {
+			names = append(names, name)
+		}
+	}
+	// The timestamps in the names sort by age
+	slices.Sort(names)
+	for len(names) > keep {
+		removeClone(filepath.Join(dir, names[0]))
+		names = names[1:]
+	}
+	return nil
+}
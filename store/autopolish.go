@@ -0,0 +1,49 @@
+package store
+
+import "context"
+
+// recordSize returns the size in bytes of the record starting at offset.
+func (s *Store) recordSize(offset int64, line uint64) (int64, error) {
+	_, prefixLen, valLen, err := s.readPrefix(offset, line)
+	if err != nil {
+		return 0, err
+	}
+	return prefixLen + int64(valLen) + s.format.trailerLen(), nil
+}
+
+// addDead counts n more bytes of dead records for WithAutoPolish and starts a
+// background compaction once the dead bytes make up more than the configured share of
+// the data file. The caller must hold the write lock.
+func (s *Store) addDead(n int64) {
+	if s.opts.autoPolish <= 0 {
+		return
+	}
+	s.deadBytes += n
+	if s.autoPolishing {
+		return
+	}
+	size, err := s.dataSize()
+	if err != nil || size == 0 || float64(s.deadBytes)/float64(size) <= s.opts.autoPolish {
+		return
+	}
+	s.autoPolishing = true
+	go s.autoPolish()
+}
+
+// autoPolish compacts the store in the background for WithAutoPolish, keeping line
+// numbers. It waits for the write lock like any writer, and gives up if the store was
+// closed in the meantime.
+func (s *Store) autoPolish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.autoPolishing = false
+	if s.closed {
+		return
+	}
+	err := s.polishLocked(context.Background(), PolishOptions{SkipBackup: true, KeepLineNumbers: true})
+	s.observe(opPolish, 1, err)
+	if err != nil {
+		s.opts.logger.Printf("linestore: auto polish failed store=%q err=%q", s.name(), err)
+	}
+}
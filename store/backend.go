@@ -0,0 +1,151 @@
+package store
+
+import (
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// backend is the storage behind a store's data or index file.
+type backend interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+	Size() (int64, error)
+	Close() error
+}
+
+// fileBackend stores data in an os.File.
+type fileBackend struct {
+	*os.File
+	locked       bool        // Holds the advisory lock taken by lockFile
+	preallocated bool        // Space may be reserved past the end by preallocate
+	retry        *writeRetry // Retries transient write and sync errors, nil for none
+}
+
+// WriteAt writes p at off, retrying transient errors from where the write stopped.
+func (f *fileBackend) WriteAt(p []byte, off int64) (int, error) {
+	written := 0
+	err := f.retry.do(func() error {
+		n, err := f.File.WriteAt(p[written:], off+int64(written))
+		written += n
+		return err
+	})
+	return written, err
+}
+
+// Sync fsyncs the file, retrying transient errors.
+func (f *fileBackend) Sync() error {
+	return f.retry.do(f.File.Sync)
+}
+
+// Size returns the current size of the file.
+func (f *fileBackend) Size() (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// preallocate reserves disk space for n more bytes past the end of the file.
+func (f *fileBackend) preallocate(n int64) error {
+	size, err := f.Size()
+	if err != nil {
+		return err
+	}
+	f.preallocated = true
+	return preallocateFile(f.File, size+n)
+}
+
+// Close releases any unused preallocated space and the file's lock, if any, and closes it.
+func (f *fileBackend) Close() error {
+	if f.preallocated {
+		// Truncating to the current size frees the blocks reserved past it
+		size, err := f.Size()
+		if err == nil {
+			f.File.Truncate(size)
+		}
+	}
+	if f.locked {
+		unlockFile(f.File) // Closing releases the lock as well; this just makes it explicit
+	}
+	return f.File.Close()
+}
+
+// memBackend stores data in a growable byte slice.
+type memBackend struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// ReadAt implements io.ReaderAt.
+func (m *memBackend) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt, growing the slice as needed.
+func (m *memBackend) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	end := off + int64(len(p))
+	if end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+// preallocate grows the slice's capacity by n bytes.
+func (m *memBackend) preallocate(n int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data = slices.Grow(m.data, int(n))
+	return nil
+}
+
+// Truncate changes the size of the data, zero-filling when it grows.
+func (m *memBackend) Truncate(size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if size <= int64(len(m.data)) {
+		m.data = m.data[:size]
+		return nil
+	}
+	m.data = append(m.data, make([]byte, size-int64(len(m.data)))...)
+	return nil
+}
+
+// Sync is a no-op; memory has nothing to flush.
+func (m *memBackend) Sync() error {
+	return nil
+}
+
+// Size returns the length of the data.
+func (m *memBackend) Size() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return int64(len(m.data)), nil
+}
+
+// Close releases the data.
+func (m *memBackend) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = nil
+	return nil
+}
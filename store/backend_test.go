@@ -0,0 +1,326 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(0, []byte("updated0"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	items, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(items) != 2 || string(items[0][1].([]byte)) != "updated0" || string(items[1][1].([]byte)) != "value2" {
+		t.Errorf("unexpected items after polish: %v", items)
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("expected a healthy store, got %+v", report)
+	}
+
+	// A memory store can still be backed up to disk
+	path := filepath.Join(dir, "test.db")
+	err = store.Backup(path, false)
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	restored, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer restored.Close()
+	if restored.Count() != 2 {
+		t.Errorf("expected 2 lines in backup, got %d", restored.Count())
+	}
+}
+
+// failingBackend behaves like a disk that fills up at failAt: writes reaching past it
+// store the bytes before it and fail with ENOSPC.
+type failingBackend struct {
+	backend
+	failAt int64
+}
+
+func (f *failingBackend) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > f.failAt {
+		n := 0
+		if off < f.failAt {
+			n, _ = f.backend.WriteAt(p[:f.failAt-off], off)
+		}
+		return n, syscall.ENOSPC
+	}
+	return f.backend.WriteAt(p, off)
+}
+
+func TestImportAllRollback(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.Set([]byte("keep"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	before, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+
+	// The first chunk of records is written before the second one fails
+	values := make([][]byte, 40)
+	for i := range values {
+		values[i] = bytes.Repeat([]byte{byte(i)}, 64<<10)
+	}
+	store.file = &failingBackend{backend: store.file, failAt: batchChunkSize * 3 / 2}
+	_, err = store.ImportAll(values)
+	if err == nil {
+		t.Fatal("expected the import to fail")
+	}
+
+	after, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if after != before {
+		t.Errorf("expected the store to be rolled back to %+v, got %+v", before, after)
+	}
+	value, err := store.Get(0)
+	if err != nil || string(value) != "keep" {
+		t.Errorf("expected keep at line 0, got %s, %v", value, err)
+	}
+
+	store.file = store.file.(*failingBackend).backend
+	lines, err := store.ImportAll(values[:2])
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if len(lines) != 2 || lines[0] != 1 {
+		t.Errorf("expected lines [1 2], got %v", lines)
+	}
+}
+
+func TestDiskFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	size, err := store.file.Size()
+	if err != nil {
+		t.Fatalf("size failed: %v", err)
+	}
+
+	// The disk fills up partway through the next record
+	store.file = &failingBackend{backend: store.file, failAt: size + 5}
+	_, err = store.Set([]byte("value1"))
+	if !errors.Is(err, ErrDiskFull) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ErrDiskFull wrapping ENOSPC, got %v", err)
+	}
+	after, err := store.file.Size()
+	if err != nil {
+		t.Fatalf("size failed: %v", err)
+	}
+	if after != size {
+		t.Errorf("expected the data file rolled back to %d bytes, got %d", size, after)
+	}
+
+	// Once space is freed the store keeps working and opens without recovery
+	store.file = store.file.(*failingBackend).backend
+	line, err := store.Set([]byte("value1"))
+	if err != nil || line != 1 {
+		t.Fatalf("expected value1 at line 1, got %d, %v", line, err)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	value, err := store.Get(1)
+	if err != nil || string(value) != "value1" {
+		t.Errorf("expected value1, got %s, %v", value, err)
+	}
+}
+
+func TestWriteRetry(t *testing.T) {
+	dir := t.TempDir()
+	r := newWriteRetry(options{retryAttempts: 3, retryBackoff: time.Millisecond})
+	calls := 0
+	err := r.do(func() error {
+		calls++
+		if calls < 3 {
+			return syscall.EINTR
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected success on the third attempt, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = r.do(func() error {
+		calls++
+		return syscall.EAGAIN
+	})
+	if !errors.Is(err, syscall.EAGAIN) || calls != 3 {
+		t.Errorf("expected EAGAIN after 3 attempts, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = r.do(func() error {
+		calls++
+		return syscall.ENOSPC
+	})
+	if !errors.Is(err, syscall.ENOSPC) || calls != 1 {
+		t.Errorf("expected ENOSPC to fail fast, got %v after %d calls", err, calls)
+	}
+
+	custom := newWriteRetry(options{retryAttempts: 2, retryable: func(err error) bool { return errors.Is(err, syscall.ENOSPC) }})
+	calls = 0
+	custom.do(func() error {
+		calls++
+		return syscall.ENOSPC
+	})
+	if calls != 2 {
+		t.Errorf("expected the custom predicate to retry ENOSPC, got %d calls", calls)
+	}
+
+	// A store with retries reads and writes as usual
+	path := filepath.Join(dir, "test.db")
+	store, err := NewStore(path, WithWriteRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	line, err := store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	value, err := store.Get(line)
+	if err != nil || string(value) != "value0" {
+		t.Errorf("expected value0, got %q, %v", value, err)
+	}
+}
+
+// syncFailingBackend fails every fsync with EIO.
+type syncFailingBackend struct {
+	backend
+}
+
+func (f *syncFailingBackend) Sync() error {
+	return syscall.EIO
+}
+
+func TestSyncErrorHandler(t *testing.T) {
+	var reported []error
+	store, err := NewMemoryStore(WithSyncErrorHandler(func(err error) {
+		reported = append(reported, err)
+	}), WithReadOnlyOnSyncError())
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	store.file = &syncFailingBackend{backend: store.file}
+	_, err = store.Set([]byte("value1"))
+	if err == nil {
+		t.Fatal("expected the set to fail")
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], syscall.EIO) {
+		t.Errorf("expected the handler to see the fsync error, got %v", reported)
+	}
+
+	// Writes stay rejected once the disk recovers, reads keep working
+	store.file = store.file.(*syncFailingBackend).backend
+	_, err = store.Set([]byte("value1"))
+	if !errors.Is(err, ErrReadOnly) || !errors.Is(err, syscall.EIO) {
+		t.Errorf("expected ErrReadOnly wrapping the fsync error, got %v", err)
+	}
+	value, err := store.Get(0)
+	if err != nil || string(value) != "value0" {
+		t.Errorf("expected value0 at line 0, got %s, %v", value, err)
+	}
+}
+
+// corruptingBackend silently flips the last bit of every write, like a disk that
+// corrupts data without reporting an error.
+type corruptingBackend struct {
+	backend
+}
+
+func (c *corruptingBackend) WriteAt(p []byte, off int64) (int, error) {
+	corrupted := bytes.Clone(p)
+	corrupted[len(corrupted)-1] ^= 1
+	return c.backend.WriteAt(corrupted, off)
+}
+
+func TestWriteVerify(t *testing.T) {
+	store, err := NewMemoryStore(WithWriteVerify())
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.Set([]byte("intact"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	store.file = &corruptingBackend{backend: store.file}
+	line, err := store.Set([]byte("corrupted"))
+	if !errors.Is(err, ErrWriteVerify) {
+		t.Fatalf("expected ErrWriteVerify, got %v", err)
+	}
+	if line != 1 || store.Count() != 2 {
+		t.Errorf("expected the corrupted value at line 1 of 2, got line %d of %d", line, store.Count())
+	}
+
+	store.file = store.file.(*corruptingBackend).backend
+	_, err = store.SetWithFlags([]byte("intact"), 1)
+	if err != nil {
+		t.Errorf("set with flags failed: %v", err)
+	}
+}
@@ -0,0 +1,646 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// A backup archive starts with a fixed-size header:
+//
+//	[0:4]   magic "LNSB"
+//	[4]     archive version
+//	[5]     archive kind
+//	[6:8]   reserved
+//	[8:16]  full: data file length; incremental: first line, little endian
+//	[16:24] full: index file length; incremental: record count, little endian
+//
+// A full archive written by BackupTo continues with the data file and then the index
+// file, byte for byte. An incremental archive written by BackupIncremental continues
+// with the store's file header, then each record as
+//
+//	type byte | user flags (1, if the header's format version has them) | write time (8, if it has timestamps) | value length (4, 8 with flagLargeValues) | value
+//
+// with the value as stored, so it can only be applied to a store with the same codec
+// and encryption.
+
+const (
+	archiveMagic      = "LNSB"
+	archiveHeaderSize = 24
+	archiveVersion    = 1
+)
+
+// Archive kinds stored in the archive header.
+const (
+	archiveFull        byte = 0
+	archiveIncremental byte = 1
+)
+
+// ErrBadArchive is returned by RestoreFrom and ApplyIncremental when the stream is not a
+// valid backup archive of the expected kind.
+var ErrBadArchive = errors.New("invalid backup archive")
+
+// BackupTo writes a backup archive of the store to w, so a backup can be streamed to
+// remote storage or over the network without a local temp file. Writers are blocked
+// while the archive is written. Use RestoreFrom to turn the archive back into a store.
+func (s *Store) BackupTo(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.file.(*segmentedBackend); ok {
+		return fmt.Errorf("BackupTo does not support segmented stores; use Backup")
+	}
+	dataSize, err := s.file.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat data file: %v", err)
+	}
+	indexSize, err := s.indexFile.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat index file: %v", err)
+	}
+
+	header := make([]byte, archiveHeaderSize)
+	copy(header[0:4], archiveMagic)
+	header[4] = archiveVersion
+	header[5] = archiveFull
+	binary.LittleEndian.PutUint64(header[8:16], uint64(dataSize))
+	binary.LittleEndian.PutUint64(header[16:24], uint64(indexSize))
+	_, err = w.Write(header)
+	if err != nil {
+		return fmt.Errorf("failed to write archive header: %v", err)
+	}
+
+	// Section readers use ReadAt, so concurrent readers are not disturbed
+	_, err = io.Copy(w, io.NewSectionReader(s.file, 0, dataSize))
+	if err != nil {
+		return fmt.Errorf("failed to copy data file: %v", err)
+	}
+	_, err = io.Copy(w, io.NewSectionReader(s.indexFile, 0, indexSize))
+	if err != nil {
+		return fmt.Errorf("failed to copy index file: %v", err)
+	}
+	return nil
+}
+
+// HotBackup writes a backup of the store to path, like Backup without polishing, while
+// writers carry on. Only the index and the file header are copied under the read lock,
+// which also fixes the line count and the size of the data file; the records up to
+// that size are copied after the lock is released. Records are only ever appended, so
+// lines written after HotBackup started are simply left out of the backup. A line
+// deleted meanwhile may show up as deleted or not.
+//
+// Polish, Clear, TruncateTo, and RestoreFromPath rewrite the files instead of
+// appending to them, as does compaction with WithMaxRecords. If any of them runs during
+// the copy, HotBackup removes the partial backup and returns an error wrapping
+// ErrConflict; it can simply be retried.
+func (s *Store) HotBackup(path string) error {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrClosed
+	}
+	data, rewrites := s.file, s.rewrites
+	if m, ok := data.(*mmapBackend); ok {
+		data = m.fileBackend // Writes may drop the mapping during the copy
+	}
+	s.copies.start()
+	defer s.copies.done()
+	ranges, err := s.dataRanges()
+	if err != nil {
+		s.mu.RUnlock()
+		return fmt.Errorf("failed to stat data file: %v", err)
+	}
+	header := make([]byte, s.format.headerLen())
+	_, err = s.file.ReadAt(header, 0)
+	if err != nil {
+		s.mu.RUnlock()
+		return fmt.Errorf("failed to read header: %v", err)
+	}
+	// Updates rewrite index entries in place, so the index can't be copied later
+	err = s.backupIndex(path + ".idx")
+	s.mu.RUnlock()
+	if err != nil {
+		removeClone(path)
+		return err
+	}
+
+	for id, r := range ranges {
+		var segmentHeader []byte
+		if id == 0 {
+			segmentHeader = header
+		}
+		err = s.backupSegment(data, segmentPath(path, id), segmentOffset(id, 0), r[1], segmentHeader)
+		if err != nil {
+			break
+		}
+	}
+
+	// A rewrite during the copy can also make it fail, so the check comes first
+	s.mu.RLock()
+	if s.rewrites != rewrites {
+		err = fmt.Errorf("store was rewritten during the backup: %w", ErrConflict)
+	} else if err != nil && s.closed {
+		err = ErrClosed
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		removeClone(path)
+		return err
+	}
+	return nil
+}
+
+// hotCopies keeps the data files that Polish and RestoreFromPath replace open while a
+// HotBackup is still copying from them outside the lock.
+type hotCopies struct {
+	mu      sync.Mutex
+	active  int       // HotBackup calls copying
+	retired []backend // Replaced data files, closed once no copy is active
+}
+
+// start registers a copy. The caller must hold the store's read lock.
+func (c *hotCopies) start() {
+	c.mu.Lock()
+	c.active++
+	c.mu.Unlock()
+}
+
+// done ends a copy, closing the replaced data files once it was the last one.
+func (c *hotCopies) done() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	if c.active == 0 {
+		for _, file := range c.retired {
+			file.Close()
+		}
+		c.retired = nil
+	}
+}
+
+// close closes a data file that is being replaced, or keeps it open until the active
+// copies are done. The caller must hold the store's write lock.
+func (c *hotCopies) close(file backend) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active > 0 {
+		c.retired = append(c.retired, file)
+		return nil
+	}
+	return file.Close()
+}
+
+// RestoreFrom reads an archive written by BackupTo and opens the restored store at path.
+// It refuses to overwrite an existing store. opts are passed to NewStore, so an
+// encrypted store needs its key here as well.
+func RestoreFrom(r io.Reader, path string, opts ...Option) (*Store, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	header, err := readArchiveHeader(r, archiveFull)
+	if err != nil {
+		return nil, err
+	}
+	dataSize := int64(binary.LittleEndian.Uint64(header[8:16]))
+	indexSize := int64(binary.LittleEndian.Uint64(header[16:24]))
+
+	err = restoreFile(r, path, dataSize, o.fileMode)
+	if err != nil {
+		return nil, err
+	}
+	err = restoreFile(r, indexPathFor(path, o), indexSize, o.fileMode)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return NewStore(path, opts...)
+}
+
+// CloneOptions adjusts how CloneWithOptions copies the store.
+type CloneOptions struct {
+	// Compact polishes the clone before it is returned, dropping deleted records and
+	// superseded values. As with Polish, the clone's lines are then renumbered from 0
+	// unless the store keeps its line numbers.
+	Compact bool
+}
+
+// CloneTo copies the store to a new store at path and returns the copy open, for
+// example to experiment on a fork of live data. The copy is taken under the read lock,
+// so it is consistent while writers wait, and the clone is opened with the same
+// options as s. It refuses to overwrite an existing store.
+func (s *Store) CloneTo(path string) (*Store, error) {
+	return s.CloneWithOptions(path, CloneOptions{})
+}
+
+// CloneWithOptions is like CloneTo, with compaction of the clone controlled by opts.
+func (s *Store) CloneWithOptions(path string, opts CloneOptions) (*Store, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil, fmt.Errorf("failed to clone to %s: %w", path, os.ErrExist)
+	}
+
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	o := s.opts
+	o.indexPath = "" // The clone's index goes next to its data file
+	o.backupInterval = 0
+	err = s.backupTo(path, false)
+	s.mu.RUnlock()
+	if err != nil {
+		removeClone(path)
+		return nil, fmt.Errorf("failed to copy store: %v", err)
+	}
+
+	clone, err := openStore(path, os.O_RDWR, []Option{func(co *options) { *co = o }})
+	if err != nil {
+		removeClone(path)
+		return nil, err
+	}
+	if opts.Compact {
+		err = clone.PolishWithOptions(PolishOptions{SkipBackup: true})
+		if err != nil {
+			clone.Close()
+			removeClone(path)
+			return nil, err
+		}
+	}
+	return clone, nil
+}
+
+// removeClone removes the files of a clone at path that could not be completed.
+func removeClone(path string) {
+	os.Remove(path + ".idx")
+	for id := 0; ; id++ {
+		if os.Remove(segmentPath(path, id)) != nil {
+			break
+		}
+	}
+}
+
+// RestoreFromPath replaces the contents of the open store with a backup written by
+// Backup to path, for rolling back to an earlier point without closing the store. The
+// backup is copied to temporary files that are renamed over the store's files, as in
+// Polish, so a failed restore leaves the store as it was. The backup must use the
+// store's format version, compression, checksums, and encryption, and it must not be
+// segmented. Line numbers, the line count, and evictions are all taken from the backup.
+func (s *Store) RestoreFromPath(path string) error {
+	o := s.opts
+	o.recovery, o.readLock, o.memoryIndex, o.dedup, o.mmap = false, false, false, false, false
+	o.indexPath = "" // Backups keep their index next to their data file
+	o.backupInterval = 0
+	backup, err := openStore(path, os.O_RDONLY, []Option{func(bo *options) { *bo = o }})
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %v", err)
+	}
+	defer backup.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.checkWritable()
+	if err != nil {
+		return err
+	}
+	const formatFlags = flagChecksum | flagEncrypted
+	if backup.format.version != s.format.version || backup.format.codec != s.format.codec ||
+		backup.format.flags&formatFlags != s.format.flags&formatFlags {
+		return fmt.Errorf("backup format does not match the store (version %d, %s compression, flags %#x)",
+			backup.format.version, backup.format.codec, backup.format.flags)
+	}
+	if backup.format.flags&flagSegmented != 0 {
+		return fmt.Errorf("segmented backups are not supported")
+	}
+	if backup.format.flags&flagSparse != 0 {
+		err = s.checkSparse()
+		if err != nil {
+			return err
+		}
+	}
+
+	dataSize, err := backup.file.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat backup data file: %v", err)
+	}
+	indexSize, err := backup.indexFile.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat backup index file: %v", err)
+	}
+
+	// Memory stores take the backup into fresh buffers that simply replace the old ones
+	var tempData, tempIndex backend = &memBackend{}, &memBackend{}
+	tempPath, tempIndexPath := s.path+".tmp", indexPathFor(s.path, s.opts)+".tmp"
+	if s.path != "" {
+		tempFile, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.opts.fileMode)
+		if err != nil {
+			return fmt.Errorf("failed to create temp data file: %v", err)
+		}
+		defer os.Remove(tempPath) // No-op once renamed into place
+		defer tempFile.Close()
+
+		tempData = &fileBackend{File: tempFile, retry: s.retry}
+		if !s.opts.singleFile {
+			tempIndexFile, err := os.OpenFile(tempIndexPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.opts.fileMode)
+			if err != nil {
+				return fmt.Errorf("failed to create temp index file: %v", err)
+			}
+			defer os.Remove(tempIndexPath)
+			defer tempIndexFile.Close()
+			tempIndex = &fileBackend{File: tempIndexFile, retry: s.retry}
+		}
+	}
+	_, err = io.Copy(io.NewOffsetWriter(tempData, 0), io.NewSectionReader(backup.file, 0, dataSize))
+	if err != nil {
+		return fmt.Errorf("failed to copy backup data file: %v", err)
+	}
+	_, err = io.Copy(io.NewOffsetWriter(tempIndex, 0), io.NewSectionReader(backup.indexFile, 0, indexSize))
+	if err != nil {
+		return fmt.Errorf("failed to copy backup index file: %v", err)
+	}
+	err = tempData.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync temp data file: %v", err)
+	}
+	err = tempIndex.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync temp index file: %v", err)
+	}
+
+	err = s.copies.close(s.file)
+	if err != nil {
+		return fmt.Errorf("failed to close original data file: %v", err)
+	}
+	err = s.indexFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close original index file: %v", err)
+	}
+	if s.path == "" {
+		s.file, s.indexFile = tempData, tempIndex
+	} else {
+		err = s.replaceFiles(tempPath, tempIndexPath, tempIndex)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.format = backup.format
+	s.lineCount, s.baseLine, s.evictLine = backup.lineCount, backup.baseLine, backup.evictLine
+	s.offsets = nil
+	s.sparseUpdates = backup.sparseUpdates // The data file is copied as is, so the offsets still hold
+	s.sparseHint.Store(nil)
+	s.deadBytes = 0
+	if s.opts.memoryIndex {
+		err = s.loadOffsets()
+		if err != nil {
+			return err
+		}
+	}
+	if s.hashes != nil {
+		if s.format.flags&flagShared == 0 {
+			s.format.flags |= flagShared
+			_, err = s.file.WriteAt(s.header(), 0)
+			if err != nil {
+				return fmt.Errorf("failed to update header: %v", err)
+			}
+		}
+		err = s.loadHashes()
+		if err != nil {
+			return err
+		}
+	}
+	s.updateGauges()
+	return nil
+}
+
+// restoreFile creates path and fills it with the next size bytes of r.
+func restoreFile(r io.Reader, path string, size int64, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, io.LimitReader(r, size))
+	if err == nil && n < size {
+		err = fmt.Errorf("archive ends after %d of %d bytes: %w", n, size, ErrBadArchive)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	return nil
+}
+
+// readArchiveHeader reads and checks an archive header of the given kind.
+func readArchiveHeader(r io.Reader, kind byte) ([]byte, error) {
+	header := make([]byte, archiveHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive header: %v", err)
+	}
+	if string(header[0:4]) != archiveMagic {
+		return nil, ErrBadArchive
+	}
+	if header[4] != archiveVersion {
+		return nil, fmt.Errorf("archive version %d: %w", header[4], ErrUnsupportedVersion)
+	}
+	if header[5] != kind {
+		return nil, fmt.Errorf("archive kind %d, expected %d: %w", header[5], kind, ErrBadArchive)
+	}
+	return header, nil
+}
+
+// BackupIncremental writes an archive of the lines from sinceLine onwards to w, for
+// stitching onto a backup that already holds the earlier lines with ApplyIncremental.
+// Deleted lines are included as tombstones so line numbers stay aligned. Updates and
+// deletes of lines before sinceLine are not captured; take a full backup after those.
+func (s *Store) BackupIncremental(w io.Writer, sinceLine uint64) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Evicted lines can't be backed up, so the archive starts at the first kept line
+	sinceLine = max(sinceLine, s.evictLine)
+	count := uint64(0)
+	if sinceLine < s.lineCount {
+		count = s.lineCount - sinceLine
+	}
+
+	header := make([]byte, archiveHeaderSize)
+	copy(header[0:4], archiveMagic)
+	header[4] = archiveVersion
+	header[5] = archiveIncremental
+	binary.LittleEndian.PutUint64(header[8:16], sinceLine)
+	binary.LittleEndian.PutUint64(header[16:24], count)
+	_, err := w.Write(append(header, s.format.encodeHeader()...))
+	if err != nil {
+		return fmt.Errorf("failed to write archive header: %v", err)
+	}
+
+	for line := sinceLine; line < sinceLine+count; line++ {
+		dataOffset, err := s.readIndexOffset(line)
+		if err != nil {
+			return err
+		}
+		typeByte, payload, err := s.readPayload(int64(dataOffset), line, false)
+		if err != nil {
+			return err
+		}
+		written, err := s.readWriteTime(int64(dataOffset), line)
+		if err != nil {
+			return err
+		}
+		userFlags, err := s.readFlags(int64(dataOffset), line)
+		if err != nil {
+			return err
+		}
+		record := make([]byte, s.format.prefixLen(recordActive), s.format.prefixLen(recordActive)+int64(len(payload)))
+		s.format.putPrefix(record, typeByte&recordDeleted, 0, userFlags, written, uint64(len(payload)))
+		_, err = w.Write(append(record, payload...))
+		if err != nil {
+			return fmt.Errorf("failed to write record for line %d: %v", line, err)
+		}
+	}
+	return nil
+}
+
+// ApplyIncremental appends the lines of an archive written by BackupIncremental.
+// The archive must start at or before the store's next line; lines the store already
+// has are skipped, so applying the same increment twice is harmless. Both stores must
+// use the same format, compression, and encryption key. If a record cannot be applied,
+// the store is left as it was.
+func (s *Store) ApplyIncremental(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return err
+	}
+
+	header, err := readArchiveHeader(r, archiveIncremental)
+	if err != nil {
+		return err
+	}
+	firstLine := binary.LittleEndian.Uint64(header[8:16])
+	count := binary.LittleEndian.Uint64(header[16:24])
+	if firstLine > s.lineCount {
+		return fmt.Errorf("archive starts at line %d but the store ends at line %d", firstLine, s.lineCount)
+	}
+
+	fileHeader := make([]byte, headerSize)
+	_, err = io.ReadFull(r, fileHeader)
+	if err != nil {
+		return fmt.Errorf("failed to read archive header: %v", err)
+	}
+	if string(fileHeader[:len(headerMagic)]) != headerMagic {
+		return fmt.Errorf("archive holds no store header: %w", ErrBadArchive)
+	}
+	// Segmentation and line numbering don't affect how records are stored
+	archiveFormat, err := decodeHeader(fileHeader)
+	if err != nil {
+		return fmt.Errorf("failed to decode archive header: %v", err)
+	}
+	const recordFlags = flagChecksum | flagEncrypted
+	if archiveFormat.codec != s.format.codec || archiveFormat.flags&recordFlags != s.format.flags&recordFlags {
+		return fmt.Errorf("archive format does not match the store: %w", ErrBadArchive)
+	}
+
+	quota, err := s.quotaLeft()
+	if err != nil {
+		return err
+	}
+	dataStart, err := s.appendOffset()
+	if err != nil {
+		return err
+	}
+	indexStart := s.indexPos(s.lineCount)
+
+	var index []byte
+	var offsets []uint64
+	dataOffset := dataStart
+	prefix := make([]byte, archiveFormat.prefixLen(recordActive))
+	for line := firstLine; line < firstLine+count; line++ {
+		_, err = io.ReadFull(r, prefix)
+		if err != nil {
+			s.rollback(dataStart, indexStart)
+			return fmt.Errorf("failed to read record for line %d: %v", line, err)
+		}
+		if prefix[0]&^recordDeleted != 0 {
+			s.rollback(dataStart, indexStart)
+			return fmt.Errorf("invalid record for line %d: %w", line, ErrBadArchive)
+		}
+		written := int64(0)
+		if archiveFormat.timestamps() {
+			written = archiveFormat.writeTime(prefix)
+		}
+		userFlags := byte(0)
+		if archiveFormat.userFlags() {
+			userFlags = prefix[archiveFormat.flagsPos(recordActive)]
+		}
+		valLen := archiveFormat.valueLen(prefix)
+		if valLen < 0 || uint64(valLen) > s.payloadLimit() {
+			s.rollback(dataStart, indexStart)
+			return fmt.Errorf("invalid record for line %d: %w", line, ErrBadArchive)
+		}
+		payload := make([]byte, valLen)
+		_, err = io.ReadFull(r, payload)
+		if err != nil {
+			s.rollback(dataStart, indexStart)
+			return fmt.Errorf("failed to read record for line %d: %v", line, err)
+		}
+		if line < s.lineCount {
+			continue
+		}
+		if userFlags != 0 && !s.format.userFlags() {
+			s.rollback(dataStart, indexStart)
+			return fmt.Errorf("line %d has user flags the store's format can't hold: %w", line, ErrBadArchive)
+		}
+
+		record := s.format.encodeRecord(prefix[0]&recordDeleted, 0, userFlags, written, payload)
+		if grown := dataOffset - dataStart + int64(len(record)); grown > quota {
+			s.rollback(dataStart, indexStart)
+			return quotaError(grown, quota)
+		}
+		_, err = s.file.WriteAt(record, dataOffset)
+		if err != nil {
+			s.rollback(dataStart, indexStart)
+			return fmt.Errorf("failed to write record: %v", err)
+		}
+		index = append(index, s.indexEntry(line, uint64(dataOffset))...)
+		offsets = append(offsets, uint64(dataOffset))
+		dataOffset += int64(len(record))
+	}
+
+	_, err = s.indexFile.WriteAt(index, indexStart)
+	if err != nil {
+		s.rollback(dataStart, indexStart)
+		return fmt.Errorf("failed to write index entries: %v", err)
+	}
+	err = s.sync(s.file)
+	if err != nil {
+		s.rollback(dataStart, indexStart)
+		return fmt.Errorf("failed to sync data file: %v", err)
+	}
+	err = s.sync(s.indexFile)
+	if err != nil {
+		s.rollback(dataStart, indexStart)
+		return fmt.Errorf("failed to sync index file: %v", err)
+	}
+
+	if s.offsets != nil {
+		s.offsets = append(s.offsets, offsets...)
+	}
+	s.lineCount += uint64(len(offsets))
+	return s.evict(true)
+}
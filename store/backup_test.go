@@ -0,0 +1,404 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupToRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	restorePath := filepath.Join(dir, "restored.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = store.BackupTo(&buf)
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	restored, err := RestoreFrom(bytes.NewReader(buf.Bytes()), restorePath)
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	defer restored.Close()
+
+	items, err := restored.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(items) != 2 || string(items[1][1].([]byte)) != "value2" || items[1][0].(uint64) != 2 {
+		t.Errorf("unexpected restored items: %v", items)
+	}
+
+	// Restoring over an existing store is refused
+	_, err = RestoreFrom(bytes.NewReader(buf.Bytes()), restorePath)
+	if err == nil {
+		t.Error("expected error restoring over an existing store")
+	}
+
+	// A truncated archive leaves nothing behind
+	os.Remove(filepath.Join(dir, "truncated.db"))
+	_, err = RestoreFrom(bytes.NewReader(buf.Bytes()[:buf.Len()-5]), filepath.Join(dir, "truncated.db"))
+	if !errors.Is(err, ErrBadArchive) {
+		t.Errorf("expected ErrBadArchive for a truncated archive, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "truncated.db")); !os.IsNotExist(err) {
+		t.Error("expected truncated restore to remove its data file")
+	}
+}
+
+func TestBackupIncremental(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	replicaPath := filepath.Join(dir, "replica.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	var full bytes.Buffer
+	err = store.BackupTo(&full)
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	replica, err := RestoreFrom(&full, replicaPath)
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	defer replica.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(3)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	var incr bytes.Buffer
+	err = store.BackupIncremental(&incr, replica.Count())
+	if err != nil {
+		t.Fatalf("incremental backup failed: %v", err)
+	}
+	archive := incr.Bytes()
+
+	// A full archive is not accepted as an increment
+	err = store.BackupTo(&full)
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	err = replica.ApplyIncremental(&full)
+	if !errors.Is(err, ErrBadArchive) {
+		t.Errorf("expected ErrBadArchive applying a full archive, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		// Applying twice is idempotent
+		err = replica.ApplyIncremental(bytes.NewReader(archive))
+		if err != nil {
+			t.Fatalf("apply incremental failed: %v", err)
+		}
+	}
+	if replica.Count() != 4 {
+		t.Errorf("expected 4 lines in replica, got %d", replica.Count())
+	}
+	value, err := replica.Get(2)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "value2" {
+		t.Errorf("expected value2, got %s", value)
+	}
+	_, err = replica.Get(3)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected deleted line 3 in replica, got %v", err)
+	}
+}
+
+func TestCloneTo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	clonePath := filepath.Join(dir, "test_clone.db")
+	compactPath := filepath.Join(dir, "test_compact.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	clone, err := store.CloneTo(clonePath)
+	if err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+	defer clone.Close()
+	if clone.Count() != 3 {
+		t.Errorf("expected 3 lines in the clone, got %d", clone.Count())
+	}
+	_, err = clone.Get(1)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted for line 1, got %v", err)
+	}
+
+	// The clone is independent of the original
+	_, err = clone.Set([]byte("value3"))
+	if err != nil {
+		t.Fatalf("set on clone failed: %v", err)
+	}
+	if store.Count() != 3 {
+		t.Errorf("expected the original to keep 3 lines, got %d", store.Count())
+	}
+
+	compacted, err := store.CloneWithOptions(compactPath, CloneOptions{Compact: true})
+	if err != nil {
+		t.Fatalf("compacting clone failed: %v", err)
+	}
+	defer compacted.Close()
+	value, err := compacted.Get(1)
+	if compacted.Count() != 2 || err != nil || string(value) != "value2" {
+		t.Errorf("expected 2 lines ending in value2, got %d lines and %s, %v", compacted.Count(), value, err)
+	}
+
+	_, err = store.CloneTo(clonePath)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected os.ErrExist for an existing store, got %v", err)
+	}
+}
+
+func TestRestoreFromPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	backupPath := filepath.Join(dir, "test_restore.db")
+	otherPath := filepath.Join(dir, "test_other.db")
+
+	store, err := NewStore(path, WithMemoryIndex())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Backup(backupPath, false)
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	_, err = store.Set([]byte("value2"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	err = store.Delete(0)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	err = store.RestoreFromPath(backupPath)
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if store.Count() != 2 {
+		t.Errorf("expected 2 lines after restore, got %d", store.Count())
+	}
+	value, err := store.Get(0)
+	if err != nil || string(value) != "value0" {
+		t.Errorf("expected value0 back at line 0, got %s, %v", value, err)
+	}
+	line, err := store.Set([]byte("value2b"))
+	if err != nil || line != 2 {
+		t.Errorf("expected the next set at line 2, got %d, %v", line, err)
+	}
+
+	// A backup in a different format is refused and the store is left alone
+	other, err := NewStore(otherPath, WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	other.Close()
+	err = store.RestoreFromPath(otherPath)
+	if err == nil {
+		t.Error("expected an error restoring a gzip backup into a plain store")
+	}
+	if store.Count() != 3 {
+		t.Errorf("expected 3 lines after the refused restore, got %d", store.Count())
+	}
+}
+
+func TestBackupArchive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	restorePath := filepath.Join(dir, "test_restored.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	for _, archivePath := range []string{filepath.Join(dir, "test_backup.tar"), filepath.Join(dir, "test_backup.tar.gz")} {
+		err = store.BackupArchive(archivePath)
+		if err != nil {
+			t.Fatalf("backup to %s failed: %v", archivePath, err)
+		}
+		restored, err := RestoreArchive(archivePath, restorePath)
+		if err != nil {
+			t.Fatalf("restore from %s failed: %v", archivePath, err)
+		}
+		value, err := restored.Get(2)
+		if restored.Count() != 3 || err != nil || string(value) != "value2" {
+			t.Errorf("expected 3 lines ending in value2, got %d lines and %s, %v", restored.Count(), value, err)
+		}
+		_, err = RestoreArchive(archivePath, restorePath)
+		if !errors.Is(err, os.ErrExist) {
+			t.Errorf("expected os.ErrExist for an existing store, got %v", err)
+		}
+		restored.Close()
+		os.Remove(restorePath)
+		os.Remove(restorePath + ".idx")
+	}
+
+	// An existing index file is refused and left alone
+	indexPath := filepath.Join(dir, "existing.idx")
+	err = os.WriteFile(indexPath, []byte("keep"), 0666)
+	if err != nil {
+		t.Fatalf("write index failed: %v", err)
+	}
+	_, err = RestoreArchive(filepath.Join(dir, "test_backup.tar"), restorePath, WithIndexPath(indexPath))
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected os.ErrExist for an existing index file, got %v", err)
+	}
+	existing, err := os.ReadFile(indexPath)
+	if err != nil || string(existing) != "keep" {
+		t.Errorf("expected the existing index file to be kept, got %q, %v", existing, err)
+	}
+
+	// A value corrupted inside the archive fails the manifest check
+	data, err := os.ReadFile(filepath.Join(dir, "test_backup.tar"))
+	if err != nil {
+		t.Fatalf("read archive failed: %v", err)
+	}
+	i := bytes.Index(data, []byte("value2"))
+	data[i+5] = '9'
+	err = os.WriteFile(filepath.Join(dir, "test_backup.tar"), data, 0666)
+	if err != nil {
+		t.Fatalf("write archive failed: %v", err)
+	}
+	_, err = RestoreArchive(filepath.Join(dir, "test_backup.tar"), restorePath)
+	if err == nil {
+		t.Fatal("expected the corrupted archive to be rejected")
+	}
+	if _, statErr := os.Stat(restorePath); !os.IsNotExist(statErr) {
+		t.Errorf("expected no store left behind, got %v", statErr)
+	}
+}
+
+func TestHotBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	backupPath := filepath.Join(dir, "test_hot.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 100; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+
+	// Writers keep appending and updating while the backup is taken
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		for i := 100; ; i++ {
+			select {
+			case <-stop:
+				done <- nil
+				return
+			default:
+			}
+			_, err := store.Set([]byte(fmt.Sprintf("value%d", i)))
+			if err == nil {
+				_, err = store.Update(0, []byte("updated"))
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	err = store.HotBackup(backupPath)
+	close(stop)
+	if writeErr := <-done; writeErr != nil {
+		t.Fatalf("concurrent write failed: %v", writeErr)
+	}
+	if err != nil {
+		t.Fatalf("hot backup failed: %v", err)
+	}
+
+	backup, err := NewStore(backupPath)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer backup.Close()
+	count := backup.Count()
+	if count < 100 || count > store.Count() {
+		t.Fatalf("unexpected backup line count %d, store has %d", count, store.Count())
+	}
+	for line := uint64(1); line < count; line++ {
+		value, err := backup.Get(line)
+		if err != nil || string(value) != fmt.Sprintf("value%d", line) {
+			t.Fatalf("unexpected backup line %d: %q, %v", line, value, err)
+		}
+	}
+	value, err := backup.Get(0)
+	if err != nil || string(value) != "value0" && string(value) != "updated" {
+		t.Errorf("unexpected backup line 0: %q, %v", value, err)
+	}
+
+}
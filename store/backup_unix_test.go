@@ -0,0 +1,72 @@
+//go:build unix
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestHotBackupPolish(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, opts := range [][]Option{nil, {WithMmap()}} {
+		path := filepath.Join(dir, "test.db")
+		backupPath := filepath.Join(dir, "test_hot.db")
+		os.Remove(path)
+		os.Remove(path + ".idx")
+		store, err := NewStore(path, opts...)
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		values := make([][]byte, 100)
+		for i := range values {
+			values[i] = bytes.Repeat([]byte{byte('a' + i%26)}, 4096)
+		}
+		_, err = store.SetBatch(values)
+		if err != nil {
+			t.Fatalf("set batch failed: %v", err)
+		}
+
+		// The backup's data file is a pipe, so the copy stalls until it is read
+		err = syscall.Mkfifo(backupPath, 0o666)
+		if err != nil {
+			t.Fatalf("mkfifo failed: %v", err)
+		}
+		result := make(chan error)
+		go func() {
+			result <- store.HotBackup(backupPath)
+		}()
+		pipe, err := os.Open(backupPath)
+		if err != nil {
+			t.Fatalf("failed to open pipe: %v", err)
+		}
+		_, err = io.ReadFull(pipe, make([]byte, 1))
+		if err != nil {
+			t.Fatalf("failed to read pipe: %v", err)
+		}
+
+		// Polish replaces the data file in the middle of the copy
+		err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+		if err != nil {
+			t.Fatalf("polish failed: %v", err)
+		}
+		io.Copy(io.Discard, pipe)
+		pipe.Close()
+		err = <-result
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict, got %v", err)
+		}
+
+		value, err := store.Get(99)
+		if err != nil || !bytes.Equal(value, values[99]) {
+			t.Errorf("expected line 99 after the polish, got %d bytes, %v", len(value), err)
+		}
+		store.Close()
+	}
+}
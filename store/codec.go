@@ -0,0 +1,224 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// Compression identifies the codec used to compress values on disk.
+// It is recorded in the file header when the store is created.
+type Compression byte
+
+const (
+	// CompressionNone stores values as given. This is the default.
+	CompressionNone Compression = 0
+	// CompressionGzip compresses each value with gzip.
+	CompressionGzip Compression = 1
+	// CompressionZstd compresses each value with Zstandard, which is usually both faster
+	// and smaller than gzip. It is unavailable in builds with the nozstd tag, which drop
+	// the zstd dependency; such builds fail to create or open zstd stores.
+	CompressionZstd Compression = 2
+	// CompressionSnappy compresses each value with Snappy, which costs the least CPU of
+	// the built-in codecs but compresses the least.
+	CompressionSnappy Compression = 3
+)
+
+// Codec compresses and decompresses values for a Compression registered with
+// RegisterCodec. Its methods must be safe for concurrent use.
+type Codec interface {
+	Compress(value []byte) []byte
+	Decompress(payload []byte) ([]byte, error)
+}
+
+// boundedCodec is implemented by the built-in codecs, which can check the uncompressed
+// size before decoding and know how much they expand incompressible values.
+type boundedCodec interface {
+	Codec
+	decompressLimit(payload []byte, limit uint32) ([]byte, error)
+	maxOverhead(n uint32) uint32
+}
+
+// registeredCodec is a codec and its name.
+type registeredCodec struct {
+	name  string
+	codec Codec
+}
+
+// codecs holds the built-in and registered codecs by id. The zstd codec is added by
+// codec_zstd.go unless the build excludes it.
+var codecs = struct {
+	sync.RWMutex
+	byID map[Compression]registeredCodec
+}{byID: map[Compression]registeredCodec{
+	CompressionGzip:   {"gzip", gzipCodec{}},
+	CompressionSnappy: {"snappy", snappyCodec{}},
+}}
+
+// RegisterCodec makes c available as compression id, so WithCompression(id) creates
+// stores compressed with it. Ids below 128 are reserved for the built-in codecs. The
+// id is recorded in the header of every store created with it, so a program opening
+// such a store must register the same codec under the same id first. Payloads that
+// the codec expands to more than twice the maximum value size plus 1 KiB can't be
+// read back.
+func RegisterCodec(id Compression, name string, c Codec) error {
+	if id < 128 {
+		return fmt.Errorf("compression id %d is reserved", byte(id))
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, ok := codecs.byID[id]; ok {
+		return fmt.Errorf("compression id %d is already registered", byte(id))
+	}
+	codecs.byID[id] = registeredCodec{name, c}
+	return nil
+}
+
+// lookup returns the codec registered for c.
+func (c Compression) lookup() (registeredCodec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	rc, ok := codecs.byID[c]
+	return rc, ok
+}
+
+// String returns the codec name.
+func (c Compression) String() string {
+	if c == CompressionNone {
+		return "none"
+	}
+	if rc, ok := c.lookup(); ok {
+		return rc.name
+	}
+	return fmt.Sprintf("compression(%d)", byte(c))
+}
+
+// valid reports whether c is a known codec.
+func (c Compression) valid() bool {
+	_, ok := c.lookup()
+	return c == CompressionNone || ok
+}
+
+// compress encodes value with the codec.
+func (c Compression) compress(value []byte) ([]byte, error) {
+	rc, ok := c.lookup()
+	if !ok {
+		return value, nil
+	}
+	return rc.codec.Compress(value), nil
+}
+
+// decompress decodes a stored value, refusing to produce more than limit bytes.
+func (c Compression) decompress(payload []byte, limit uint32) ([]byte, error) {
+	rc, ok := c.lookup()
+	if !ok {
+		return payload, nil
+	}
+	if bc, ok := rc.codec.(boundedCodec); ok {
+		return bc.decompressLimit(payload, limit)
+	}
+	value, err := rc.codec.Decompress(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > int(limit) {
+		return nil, fmt.Errorf("uncompressed size %d exceeds limit of %d: %w", len(value), limit, ErrValueTooLarge)
+	}
+	return value, nil
+}
+
+// maxOverhead returns how many bytes the codec may add to a value of size n
+// when the value doesn't compress.
+func (c Compression) maxOverhead(n uint32) uint32 {
+	rc, ok := c.lookup()
+	if !ok {
+		return 0
+	}
+	if bc, ok := rc.codec.(boundedCodec); ok {
+		return bc.maxOverhead(n)
+	}
+	return n + 1024
+}
+
+// gzipCodec compresses values with gzip.
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(value []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(value) // Writes to a bytes.Buffer don't fail
+	w.Close()
+	return buf.Bytes()
+}
+
+func (g gzipCodec) Decompress(payload []byte) ([]byte, error) {
+	return g.decompressLimit(payload, math.MaxUint32)
+}
+
+func (gzipCodec) decompressLimit(payload []byte, limit uint32) ([]byte, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("gzip payload too short")
+	}
+	// The gzip trailer ends with the uncompressed size, so the value is allocated once
+	size := binary.LittleEndian.Uint32(payload[len(payload)-4:])
+	if size > limit {
+		return nil, fmt.Errorf("uncompressed size %d exceeds limit of %d: %w", size, limit, ErrValueTooLarge)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, size)
+	_, err = io.ReadFull(r, value)
+	if err != nil {
+		return nil, err
+	}
+	// Only reading to the end checks the trailer's CRC32 and size
+	_, err = io.ReadFull(r, make([]byte, 1))
+	if err == nil {
+		err = fmt.Errorf("gzip payload is longer than its trailer's size of %d", size)
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (gzipCodec) maxOverhead(n uint32) uint32 {
+	// Stored deflate blocks cost 5 bytes per 64 KiB, plus the gzip header and trailer
+	return n/(1<<16)*5 + 64
+}
+
+// snappyCodec compresses values with Snappy's block format.
+type snappyCodec struct{}
+
+func (snappyCodec) Compress(value []byte) []byte {
+	return snappy.Encode(nil, value)
+}
+
+func (s snappyCodec) Decompress(payload []byte) ([]byte, error) {
+	return s.decompressLimit(payload, math.MaxUint32)
+}
+
+func (snappyCodec) decompressLimit(payload []byte, limit uint32) ([]byte, error) {
+	// The block starts with the uncompressed size
+	size, err := snappy.DecodedLen(payload)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(size) > uint64(limit) {
+		return nil, fmt.Errorf("uncompressed size %d exceeds limit of %d: %w", size, limit, ErrValueTooLarge)
+	}
+	return snappy.Decode(nil, payload)
+}
+
+func (snappyCodec) maxOverhead(n uint32) uint32 {
+	// The bound snappy.MaxEncodedLen uses for the original format, which covers every encoder
+	return n/6 + 32
+}
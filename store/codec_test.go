@@ -0,0 +1,237 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGzipCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	value := bytes.Repeat([]byte(`{"name":"linestore","kind":"json"}`), 100)
+	line, err := store.Set(value)
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	store.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if info.Size() >= int64(len(value)) {
+		t.Errorf("expected data file smaller than %d bytes, got %d", len(value), info.Size())
+	}
+
+	// The codec is read from the header, so no option is needed on reopen
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	got, err := store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("round-tripped value does not match")
+	}
+
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	got, err = store.Get(line)
+	if err != nil {
+		t.Fatalf("get after polish failed: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("value does not match after polish")
+	}
+}
+
+func TestGzipTrailer(t *testing.T) {
+	value := bytes.Repeat([]byte("linestore"), 100)
+	payload := gzipCodec{}.Compress(value)
+
+	// A damaged CRC32 fails the value
+	damaged := bytes.Clone(payload)
+	damaged[len(damaged)-8] ^= 0xff
+	_, err := gzipCodec{}.Decompress(damaged)
+	if err == nil {
+		t.Error("expected an error for a damaged checksum")
+	}
+
+	// So does a size one byte short, which would otherwise cut the value
+	short := bytes.Clone(payload)
+	binary.LittleEndian.PutUint32(short[len(short)-4:], uint32(len(value)-1))
+	got, err := gzipCodec{}.Decompress(short)
+	if err == nil {
+		t.Errorf("expected an error for a wrong size, got %d bytes", len(got))
+	}
+
+	got, err = gzipCodec{}.Decompress(payload)
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("expected the value back, got %d bytes, %v", len(got), err)
+	}
+}
+
+func TestSnappyCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithCompression(CompressionSnappy))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	values := [][]byte{
+		bytes.Repeat([]byte("a"), 4096), // Highly compressible
+		random,                          // Incompressible
+		{},
+	}
+	lines, err := store.SetBatch(values)
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	for i, line := range lines {
+		got, err := store.Get(line)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if !bytes.Equal(got, values[i]) {
+			t.Errorf("value %d does not round-trip", i)
+		}
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.DataSize >= 2*4096 {
+		t.Errorf("expected the compressible value to shrink, got %d bytes", stats.DataSize)
+	}
+}
+
+// reverseCodec is a toy codec that stores values reversed.
+type reverseCodec struct{}
+
+func (reverseCodec) Compress(value []byte) []byte {
+	out := make([]byte, len(value))
+	for i, b := range value {
+		out[len(value)-1-i] = b
+	}
+	return out
+}
+
+func (c reverseCodec) Decompress(payload []byte) ([]byte, error) {
+	return c.Compress(payload), nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	const compressionReverse Compression = 200
+	err := RegisterCodec(CompressionGzip, "gzip2", reverseCodec{})
+	if err == nil {
+		t.Error("expected an error registering a reserved id")
+	}
+	if !compressionReverse.valid() { // Registered by an earlier run with -count
+		_, err = NewStore(path, WithCompression(compressionReverse))
+		if err == nil {
+			t.Fatal("expected an error creating a store with an unregistered codec")
+		}
+		os.Remove(path)
+		os.Remove(path + ".idx")
+
+		err = RegisterCodec(compressionReverse, "reverse", reverseCodec{})
+		if err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+	}
+	err = RegisterCodec(compressionReverse, "reverse", reverseCodec{})
+	if err == nil {
+		t.Error("expected an error registering an id twice")
+	}
+	if compressionReverse.String() != "reverse" {
+		t.Errorf("expected the codec name, got %s", compressionReverse)
+	}
+	store, err := NewStore(path, WithCompression(compressionReverse), WithMaxValueSize(8))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	line, err := store.Set([]byte("linestor"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read data file failed: %v", err)
+	}
+	if !bytes.Contains(data, []byte("otsenil")) {
+		t.Errorf("expected the value stored reversed")
+	}
+	value, err := store.Get(line)
+	if err != nil || string(value) != "linestor" {
+		t.Errorf("expected linestor, got %s, %v", value, err)
+	}
+	_, err = store.Set([]byte("too long!"))
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+}
+
+func benchmarkCompression(b *testing.B, c Compression) {
+	dir := b.TempDir()
+	path := filepath.Join(dir, "bench.db")
+
+	store, err := NewStore(path, WithCompression(c), WithSyncMode(SyncNone))
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	value := bytes.Repeat([]byte(`{"user":"alice","event":"login","ok":true}`), 25)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		line, err := store.Set(value)
+		if err != nil {
+			b.Fatalf("set failed: %v", err)
+		}
+		_, err = store.Get(line)
+		if err != nil {
+			b.Fatalf("get failed: %v", err)
+		}
+	}
+	b.StopTimer()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatalf("stat failed: %v", err)
+	}
+	b.ReportMetric(float64(info.Size())/float64(b.N), "disk-bytes/op")
+}
+
+func BenchmarkCompressionNone(b *testing.B) {
+	benchmarkCompression(b, CompressionNone)
+}
+
+func BenchmarkCompressionGzip(b *testing.B) {
+	benchmarkCompression(b, CompressionGzip)
+}
+
+func BenchmarkCompressionSnappy(b *testing.B) {
+	benchmarkCompression(b, CompressionSnappy)
+}
@@ -0,0 +1,55 @@
+//go:build !nozstd
+
+package store
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	codecs.byID[CompressionZstd] = registeredCodec{"zstd", zstdCodec{}}
+}
+
+// The encoder and decoder are safe for concurrent use with EncodeAll and DecodeAll.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// zstdCodec compresses each value as a single zstd frame that records its size.
+type zstdCodec struct{}
+
+func (zstdCodec) Compress(value []byte) []byte {
+	return zstdEncoder.EncodeAll(value, nil)
+}
+
+func (z zstdCodec) Decompress(payload []byte) ([]byte, error) {
+	return z.decompressLimit(payload, math.MaxUint32)
+}
+
+func (zstdCodec) decompressLimit(payload []byte, limit uint32) ([]byte, error) {
+	var header zstd.Header
+	err := header.Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	if header.HasFCS && header.FrameContentSize > uint64(limit) {
+		return nil, fmt.Errorf("uncompressed size %d exceeds limit of %d: %w", header.FrameContentSize, limit, ErrValueTooLarge)
+	}
+	value, err := zstdDecoder.DecodeAll(payload, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > int(limit) {
+		return nil, fmt.Errorf("uncompressed size %d exceeds limit of %d: %w", len(value), limit, ErrValueTooLarge)
+	}
+	return value, nil
+}
+
+func (zstdCodec) maxOverhead(n uint32) uint32 {
+	// Raw blocks cost 3 bytes per 128 KiB, plus the frame header and checksum
+	return n/(1<<17)*3 + 64
+}
@@ -0,0 +1,65 @@
+//go:build !nozstd
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZstdCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithCompression(CompressionZstd))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	value := bytes.Repeat([]byte(`{"name":"linestore","kind":"json"}`), 100)
+	line, err := store.Set(value)
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	store.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read data file failed: %v", err)
+	}
+	if len(data) >= len(value) {
+		t.Errorf("expected data file smaller than %d bytes, got %d", len(value), len(data))
+	}
+	if Compression(data[5]) != CompressionZstd {
+		t.Errorf("expected codec %d in the header, got %d", CompressionZstd, data[5])
+	}
+
+	// The codec is read from the header, so the gzip option is ignored on reopen
+	store, err = NewStore(path, WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	got, err := store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("round-tripped value does not match")
+	}
+	store.Close()
+
+	store, err = NewStore(path, WithMaxValueSize(100))
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.Get(line)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge above the value limit, got %v", err)
+	}
+}
+
+func BenchmarkCompressionZstd(b *testing.B) {
+	benchmarkCompression(b, CompressionZstd)
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// commitGroup collects Set calls that share one fsync under WithCommitInterval.
+type commitGroup struct {
+	mu      sync.Mutex
+	pending *commitBatch // Batch still accepting writers, nil when none is open
+}
+
+// commitBatch is one group of writes synced together.
+type commitBatch struct {
+	done chan struct{} // Closed once the batch is synced
+	err  error         // Result of the sync, valid after done is closed
+}
+
+// join adds the caller's write to the open batch, opening one if needed. The caller
+// that opens a batch becomes its leader and must call commit.
+func (g *commitGroup) join() (b *commitBatch, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.pending == nil {
+		g.pending = &commitBatch{done: make(chan struct{})}
+		leader = true
+	}
+	return g.pending, leader
+}
+
+// setGroupCommit implements Set with group commit. The record and index entry are
+// written under the write lock, then the lock is released and the caller waits for
+// the batch's shared fsync. The first writer of a batch waits out the commit interval
+// so others can join, closes the batch, and syncs both files for all of them.
+func (s *Store) setGroupCommit(value []byte) (uint64, error) {
+	s.mu.Lock()
+	line, err := s.appendValue(value, 0, false)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	batch, leader := s.commits.join()
+	s.mu.Unlock()
+
+	if !leader {
+		<-batch.done
+		return line, batch.err
+	}
+
+	time.Sleep(s.opts.commitInterval)
+	s.commits.mu.Lock()
+	s.commits.pending = nil
+	s.commits.mu.Unlock()
+
+	// Every writer in the batch finished writing before it joined. The read lock keeps
+	// Polish from swapping the files out mid-sync; a closed store was synced by Close
+	s.mu.RLock()
+	if !s.closed {
+		err = s.syncFile(s.file)
+		if err != nil {
+			batch.err = writeError("failed to sync data file", err)
+		} else {
+			err = s.syncFile(s.indexFile)
+			if err != nil {
+				batch.err = writeError("failed to sync index file", err)
+			}
+		}
+	}
+	s.mu.RUnlock()
+	close(batch.done)
+	return line, batch.err
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ValueEncoding selects how ExportCSV writes values, which may be arbitrary bytes.
+type ValueEncoding byte
+
+const (
+	EncodingBase64 ValueEncoding = iota // Standard base64 with padding
+	EncodingHex                         // Lowercase hex
+	EncodingRaw                         // The bytes as they are, for stores of text values
+)
+
+// encode returns value as text in encoding e.
+func (e ValueEncoding) encode(value []byte) (string, error) {
+	switch e {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(value), nil
+	case EncodingHex:
+		return hex.EncodeToString(value), nil
+	case EncodingRaw:
+		return string(value), nil
+	}
+	return "", fmt.Errorf("unknown value encoding %d", e)
+}
+
+// ExportCSV writes every live record to w as CSV, starting with a "line,value" header
+// row followed by one row per line with the value in the given encoding. Quoting is
+// handled by encoding/csv, so raw values may contain commas, quotes, and newlines.
+// Deleted lines are skipped. Like ForEach, records are read one at a time without
+// holding the read lock in between.
+func (s *Store) ExportCSV(w io.Writer, encoding ValueEncoding) error {
+	_, err := encoding.encode(nil)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	err = cw.Write([]string{"line", "value"})
+	if err != nil {
+		return fmt.Errorf("failed to write header row: %v", err)
+	}
+	err = s.ForEach(func(line uint64, value []byte) error {
+		text, _ := encoding.encode(value)
+		err := cw.Write([]string{strconv.FormatUint(line, 10), text})
+		if err != nil {
+			return fmt.Errorf("failed to write line %d: %v", line, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	err = cw.Error()
+	if err != nil {
+		return fmt.Errorf("failed to flush CSV: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("a,b"), []byte("gone"), []byte("say \"hi\"")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	tests := []struct {
+		encoding ValueEncoding
+		want     string
+	}{
+		{EncodingRaw, "line,value\n0,\"a,b\"\n2,\"say \"\"hi\"\"\"\n"},
+		{EncodingHex, "line,value\n0,612c62\n2,7361792022686922\n"},
+		{EncodingBase64, "line,value\n0,YSxi\n2,c2F5ICJoaSI=\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err = store.ExportCSV(&buf, tt.encoding)
+		if err != nil {
+			t.Fatalf("export with encoding %d failed: %v", tt.encoding, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("encoding %d: expected %q, got %q", tt.encoding, tt.want, buf.String())
+		}
+	}
+
+	err = store.ExportCSV(&bytes.Buffer{}, ValueEncoding(9))
+	if err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+}
@@ -0,0 +1,148 @@
+package store
+
+import "errors"
+
+// Cursor moves back and forth over the live records of a store, for callers such as a
+// scrollable viewer that navigate in both directions. Deleted and evicted lines are
+// skipped. Every move reads the store's current line count, so lines appended while
+// the cursor is in use are reachable, and the read lock is only held during a move.
+//
+// A cursor is either on a line or off one end of the store. A new cursor is before
+// the first line: Next moves to the first live line and Prev returns false. Moving
+// past the last live line with Next, or before the first with Prev, returns false and
+// leaves the cursor off that end, from where a move in the other direction returns to
+// the last or first live line. Line and Value are only meaningful while on a line.
+type Cursor struct {
+	store *Store
+	pos   cursorPos
+	line  uint64
+	value []byte
+	err   error
+}
+
+// cursorPos says where a cursor is.
+type cursorPos int
+
+const (
+	cursorBefore cursorPos = iota // Before the first live line
+	cursorOn                      // On line
+	cursorAfter                   // After the last live line
+)
+
+// Cursor returns a cursor positioned before the first live line.
+func (s *Store) Cursor() *Cursor {
+	return &Cursor{store: s}
+}
+
+// Seek moves to line, or to the first live line after it if line is deleted or evicted.
+// It returns false, leaving the cursor after the last line, if there is no such line.
+func (c *Cursor) Seek(line uint64) bool {
+	return c.move(line, true)
+}
+
+// Next moves to the next live line. It returns false, leaving the cursor after the
+// last line, when there is none or an error occurred (check Err).
+func (c *Cursor) Next() bool {
+	switch c.pos {
+	case cursorBefore:
+		return c.move(0, true)
+	case cursorOn:
+		return c.move(c.line+1, true)
+	}
+	return false
+}
+
+// Prev moves to the previous live line. It returns false, leaving the cursor before
+// the first line, when there is none or an error occurred (check Err).
+func (c *Cursor) Prev() bool {
+	switch c.pos {
+	case cursorAfter:
+		return c.move(^uint64(0), false)
+	case cursorOn:
+		if c.line == 0 {
+			c.off(cursorBefore)
+			return false
+		}
+		return c.move(c.line-1, false)
+	}
+	return false
+}
+
+// Line returns the line number the cursor is on.
+func (c *Cursor) Line() uint64 {
+	return c.line
+}
+
+// Value returns the value of the line the cursor is on, or nil when it is off either end.
+func (c *Cursor) Value() []byte {
+	return c.value
+}
+
+// Err returns the error that stopped the last move, if any. A later successful move
+// clears it.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// move goes to the first live line at or after from, or at or before it when forward
+// is false, clamping from to the lines the store holds.
+func (c *Cursor) move(from uint64, forward bool) bool {
+	s := c.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	end := cursorBefore
+	if forward {
+		end = cursorAfter
+	}
+	c.err = nil
+	if s.closed {
+		c.err = ErrClosed
+		return c.off(end)
+	}
+	if forward {
+		for line := max(from, s.evictLine); line < s.lineCount; line++ {
+			found, err := c.visit(line)
+			if found || err != nil {
+				c.err = err
+				return found || c.off(end)
+			}
+		}
+		return c.off(end)
+	}
+	if s.lineCount == s.evictLine || from < s.evictLine {
+		return c.off(end)
+	}
+	for line := min(from, s.lineCount-1); ; line-- {
+		found, err := c.visit(line)
+		if found || err != nil {
+			c.err = err
+			return found || c.off(end)
+		}
+		if line == s.evictLine {
+			return c.off(end)
+		}
+	}
+}
+
+// visit puts the cursor on line if it is live. The caller must hold the lock.
+func (c *Cursor) visit(line uint64) (bool, error) {
+	typeByte, value, err := c.store.readLine(line)
+	if errors.Is(err, ErrEvicted) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if typeByte&recordDeleted != 0 {
+		return false, nil
+	}
+	c.pos, c.line, c.value = cursorOn, line, value
+	return true, nil
+}
+
+// off moves the cursor off one end of the store and returns false.
+func (c *Cursor) off(pos cursorPos) bool {
+	c.pos, c.value = pos, nil
+	return false
+}
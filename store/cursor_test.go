@@ -0,0 +1,72 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	for _, line := range []uint64{0, 2} {
+		err = store.Delete(line)
+		if err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+	}
+
+	// expect checks a move landed on line, or off the store when line is -1
+	c := store.Cursor()
+	expect := func(name string, moved bool, line int) {
+		t.Helper()
+		if line < 0 {
+			if moved || c.Value() != nil {
+				t.Errorf("%s: expected to move off the store, got line %d", name, c.Line())
+			}
+			return
+		}
+		if !moved || c.Line() != uint64(line) || string(c.Value()) != fmt.Sprintf("value%d", line) {
+			t.Errorf("%s: expected line %d, got %v at %d with '%s'", name, line, moved, c.Line(), c.Value())
+		}
+	}
+
+	expect("prev from start", c.Prev(), -1)
+	expect("next from start", c.Next(), 1)
+	expect("next", c.Next(), 3)
+	expect("next", c.Next(), 4)
+	expect("next past end", c.Next(), -1)
+	expect("next after end", c.Next(), -1)
+	expect("prev from end", c.Prev(), 4)
+	expect("prev", c.Prev(), 3)
+	expect("prev skips deleted", c.Prev(), 1)
+	expect("prev past start", c.Prev(), -1)
+	expect("next from before", c.Next(), 1)
+
+	expect("seek deleted", c.Seek(2), 3)
+	expect("seek live", c.Seek(4), 4)
+	expect("seek past end", c.Seek(9), -1)
+	expect("prev after seek", c.Prev(), 4)
+
+	// Appended lines are reachable
+	_, err = store.Set([]byte("value5"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	expect("next to appended", c.Next(), 5)
+	if c.Err() != nil {
+		t.Errorf("unexpected error: %v", c.Err())
+	}
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// dedupMap maps the hash of a value, as returned by hashValue, to the offset of a
+// record holding it.
+type dedupMap map[string]uint64
+
+// hashProbe is hashed to fingerprint the algorithm chosen with WithHash.
+const hashProbe = "linestore hash probe"
+
+// sha256ID is the HashID of SHA-256, the default hash.
+var sha256ID = hashFingerprint(sha256.New)
+
+// hashFingerprint returns a short identifier for the algorithm newHash creates: the
+// digest size and the start of the digest of hashProbe. Two algorithms only share it
+// by accident, so it tells hash spaces apart without the algorithm having a name.
+func hashFingerprint(newHash func() hash.Hash) string {
+	h := newHash()
+	h.Write([]byte(hashProbe))
+	sum := h.Sum(nil)
+	if len(sum) > 8 {
+		sum = sum[:8]
+	}
+	return hex.EncodeToString(sum)
+}
+
+// HashID identifies the hash the store uses for WithDedup and ContentHash: "sha256"
+// by default, or a fingerprint of the algorithm given to WithHash. Digests made by
+// stores with different HashIDs can't be compared.
+func (s *Store) HashID() string {
+	if s.hashID == sha256ID {
+		return "sha256"
+	}
+	return "custom-" + s.hashID
+}
+
+// newHash returns a new hash.Hash of the algorithm set with WithHash.
+func (s *Store) newHash() hash.Hash {
+	if s.opts.hash == nil {
+		return sha256.New()
+	}
+	return s.opts.hash()
+}
+
+// hashValue returns the dedup key of value.
+func (s *Store) hashValue(value []byte) string {
+	h := s.newHash()
+	h.Write(value)
+	return string(h.Sum(nil))
+}
+
+// sharedRecord returns the offset of a live record holding value, given its hash sum.
+// Unless the hash is SHA-256 the record is read back and compared, so a collision in
+// a weaker hash writes a copy instead of pointing the line at a different value.
+func (s *Store) sharedRecord(sum string, value []byte) (uint64, bool) {
+	offset, ok := s.hashes[sum]
+	if !ok || s.hashID == sha256ID {
+		return offset, ok
+	}
+	_, stored, err := s.readRecord(int64(offset), s.lineCount, false)
+	return offset, err == nil && bytes.Equal(stored, value)
+}
+
+// loadHashes hashes the value of every live line for WithDedup. When several lines
+// already hold the same value, the first one's record is used for new duplicates.
+// The hashes are rebuilt on every open, so reopening with a different WithHash is safe.
+func (s *Store) loadHashes() error {
+	s.hashes = make(dedupMap)
+	for line := s.evictLine; line < s.lineCount; line++ {
+		dataOffset, err := s.readIndexOffset(line)
+		if err != nil {
+			return err
+		}
+		typeByte, value, err := s.readRecord(int64(dataOffset), line, false)
+		if err != nil {
+			return err
+		}
+		if typeByte&recordDeleted != 0 {
+			continue
+		}
+		sum := s.hashValue(value)
+		if _, ok := s.hashes[sum]; !ok {
+			s.hashes[sum] = dataOffset
+		}
+	}
+	return nil
+}
+
+// remap moves every hash to the new offset of its record after Polish, given the old
+// and new offsets of the records Polish copied. Hashes of records that were dropped
+// are forgotten.
+func (m dedupMap) remap(copied map[uint64]uint64) {
+	for sum, offset := range m {
+		newOffset, ok := copied[offset]
+		if !ok {
+			delete(m, sum)
+			continue
+		}
+		m[sum] = newOffset
+	}
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithDedup())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for _, value := range []string{"a", "b", "a"} {
+		_, err = store.Set([]byte(value))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	_, err = store.SetBatch([][]byte{[]byte("b"), []byte("c"), []byte("c")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.Records != 3 || stats.Lines != 6 || stats.LiveLines != 6 {
+		t.Errorf("expected 3 records for 6 lines, got %+v", stats)
+	}
+
+	// Deleting a line leaves the lines sharing its record alone
+	err = store.Delete(0)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = store.Get(0)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted for line 0, got %v", err)
+	}
+	value, err := store.Get(2)
+	if err != nil || string(value) != "a" {
+		t.Errorf("expected a at line 2, got %s, %v", value, err)
+	}
+
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	stats, err = store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.Records != 3 || stats.Lines != 5 || stats.DeadBytes != 0 {
+		t.Errorf("expected 3 records for 5 lines after polish, got %+v", stats)
+	}
+	_, err = store.Set([]byte("c"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	store.Close()
+
+	// Shared records are read back without the option
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	records, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	want := []string{"b", "a", "b", "c", "c", "c"}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records, got %v", len(want), records)
+	}
+	for i, record := range records {
+		if string(record[1].([]byte)) != want[i] {
+			t.Errorf("expected %s at line %d, got %s", want[i], i, record[1])
+		}
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() || report.Records != 3 {
+		t.Errorf("expected a clean report with 3 records, got %+v", report)
+	}
+}
+
+// shortHash keeps only the first byte of an FNV-1a sum, so distinct values collide often.
+type shortHash struct{ hash.Hash }
+
+func (h shortHash) Sum(b []byte) []byte { return h.Hash.Sum(b)[:len(b)+1] }
+func (h shortHash) Size() int           { return 1 }
+
+func TestWithHash(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	archivePath := filepath.Join(dir, "test_hash.tar")
+	restorePath := filepath.Join(dir, "test_hash_restored.db")
+
+	newShort := func() hash.Hash { return shortHash{fnv.New32a()} }
+	store, err := NewStore(path, WithDedup(), WithHash(newShort))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if id := store.HashID(); id == "sha256" || id == "" {
+		t.Errorf("expected a custom hash ID, got %q", id)
+	}
+
+	// With 300 values and 256 possible sums, colliding values must still be stored apart
+	values := make([][]byte, 300)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("value%d", i))
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	for _, value := range values {
+		_, err = store.Set(value)
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	for i := range values {
+		for _, line := range []uint64{uint64(i), uint64(len(values) + i)} {
+			value, err := store.Get(line)
+			if err != nil || string(value) != string(values[i]) {
+				t.Fatalf("expected %s at line %d, got %s, %v", values[i], line, value, err)
+			}
+		}
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	// A collision costs a copy, but values whose sum is unique are still shared
+	if stats.Records < uint64(len(values)) || stats.Records >= uint64(2*len(values)) {
+		t.Errorf("expected between %d and %d records, got %d", len(values), 2*len(values), stats.Records)
+	}
+
+	// The archive records the hash, so restoring it under SHA-256 is refused
+	err = store.BackupArchive(archivePath)
+	if err != nil {
+		t.Fatalf("backup archive failed: %v", err)
+	}
+	store.Close()
+	_, err = RestoreArchive(archivePath, restorePath)
+	if !errors.Is(err, ErrBadArchive) {
+		t.Errorf("expected ErrBadArchive for a different hash, got %v", err)
+	}
+	restored, err := RestoreArchive(archivePath, restorePath, WithHash(newShort))
+	if err != nil {
+		t.Fatalf("restore archive failed: %v", err)
+	}
+	restored.Close()
+	os.Remove(restorePath)
+	os.Remove(restorePath + ".idx")
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if id := store.HashID(); id != "sha256" {
+		t.Errorf("expected sha256 by default, got %q", id)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !plan9
+
+package store
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDiskFull reports whether err means the disk ran out of space.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// IsTransient reports whether err is an interrupted or would-block system call error
+// (EINTR or EAGAIN), which a retry may get past. It is the default predicate of
+// WithWriteRetry.
+func IsTransient(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
@@ -0,0 +1,17 @@
+package store
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDiskFull always reports false on Plan 9, which has no ENOSPC errno to detect.
+func isDiskFull(err error) bool {
+	return false
+}
+
+// IsTransient reports whether err is an interrupted system call error, which a retry
+// may get past. It is the default predicate of WithWriteRetry.
+func IsTransient(err error) bool {
+	return errors.Is(err, syscall.EINTR)
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrDecrypt is returned when a value cannot be decrypted, either because the key is
+// wrong, the value was tampered with, or an encrypted store was opened without a key.
+var ErrDecrypt = errors.New("failed to decrypt value")
+
+// encryptionOverhead is the number of bytes AES-GCM adds to each value: the nonce
+// stored in front of the ciphertext plus the authentication tag.
+const encryptionOverhead = 12 + 16
+
+// newAEAD creates the AES-GCM cipher for key, which must be 16, 24, or 32 bytes long.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals value with a random nonce, which is prepended to the result.
+func encrypt(aead cipher.AEAD, value []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, value, nil), nil
+}
+
+// decrypt opens a value sealed by encrypt.
+func decrypt(aead cipher.AEAD, payload []byte) ([]byte, error) {
+	if len(payload) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := payload[:aead.NonceSize()], payload[aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return value, nil
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	key := bytes.Repeat([]byte("k"), 32)
+	store, err := NewStore(path, WithEncryption(key), WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	line, err := store.Set([]byte("secret value"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	store.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read data file failed: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("data file contains the plaintext value")
+	}
+
+	// Opening without the key fails fast
+	_, err = NewStore(path)
+	if !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt opening without a key, got %v", err)
+	}
+
+	// A wrong key is caught by authentication
+	wrong, err := NewStore(path, WithEncryption(bytes.Repeat([]byte("x"), 32)))
+	if err != nil {
+		t.Fatalf("failed to open with wrong key: %v", err)
+	}
+	_, err = wrong.Get(line)
+	if !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt with wrong key, got %v", err)
+	}
+	wrong.Close()
+
+	store, err = NewStore(path, WithEncryption(key))
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	value, err := store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "secret value" {
+		t.Errorf("expected 'secret value', got '%s'", value)
+	}
+}
+
+func TestEncryptionKeyOnPlaintextStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Close()
+
+	_, err = NewStore(path, WithEncryption(bytes.Repeat([]byte("k"), 16)))
+	if err == nil {
+		t.Error("expected error opening a plaintext store with a key, got nil")
+	}
+}
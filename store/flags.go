@@ -0,0 +1,60 @@
+package store
+
+import "fmt"
+
+// SetWithFlags appends value like Set and stores flags alongside it, a byte of
+// per-record metadata whose meaning is up to the caller. The flags survive Update and
+// Polish and can be read back with GetFlags without reading the value. Stores created
+// before records carried flags can only take flags of 0 and return an error wrapping
+// ErrUnsupportedVersion otherwise. With WithDedup, values with flags are always
+// stored as their own record. SetWithFlags does not share fsyncs with
+// WithCommitInterval.
+func (s *Store) SetWithFlags(value []byte, flags uint8) (uint64, error) {
+	line, err := s.setWithFlags(value, flags)
+	s.observe(opSet, 1, err)
+	return line, err
+}
+
+// setWithFlags implements SetWithFlags.
+func (s *Store) setWithFlags(value []byte, flags uint8) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if flags != 0 && !s.format.userFlags() {
+		return 0, fmt.Errorf("record flags need format version 3, store has version %d: %w", s.format.version, ErrUnsupportedVersion)
+	}
+	return s.appendValue(value, flags, true)
+}
+
+// GetFlags returns the flags stored with the value at line by SetWithFlags, or 0 for
+// a value written without them. Only the record's prefix is read.
+func (s *Store) GetFlags(line uint64) (uint8, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if line >= s.lineCount {
+		return 0, fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
+	}
+	dataOffset, typeByte, err := s.readLineType(line)
+	if err != nil {
+		return 0, err
+	}
+	if typeByte&recordDeleted != 0 {
+		return 0, deletedError(typeByte, line)
+	}
+	return s.readFlags(int64(dataOffset), line)
+}
+
+// readFlags returns the user flags of the record starting at offset, or 0 when the
+// format has no user flags.
+func (s *Store) readFlags(offset int64, line uint64) (byte, error) {
+	if !s.format.userFlags() {
+		return 0, nil
+	}
+	prefix := make([]byte, maxPrefixLen)
+	n, err := s.file.ReadAt(prefix, offset)
+	if n < 1 || int64(n) < s.format.prefixLen(prefix[0]) {
+		return 0, fmt.Errorf("failed to read flags at line %d: %v", line, err)
+	}
+	return prefix[s.format.flagsPos(prefix[0])], nil
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetWithFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetWithFlags([]byte("value0"), 0x01)
+	if err != nil {
+		t.Fatalf("set with flags failed: %v", err)
+	}
+	_, err = store.Set([]byte("value1"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	line, err := store.SetWithFlags([]byte("value2"), 0x81)
+	if err != nil {
+		t.Fatalf("set with flags failed: %v", err)
+	}
+	flags, err := store.GetFlags(line)
+	if err != nil || flags != 0x81 {
+		t.Errorf("expected flags 0x81, got %#x, %v", flags, err)
+	}
+	flags, err = store.GetFlags(1)
+	if err != nil || flags != 0 {
+		t.Errorf("expected no flags, got %#x, %v", flags, err)
+	}
+
+	// Flags survive an update and a polish that moves the line
+	_, err = store.Update(line, []byte("updated"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(0)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = store.GetFlags(0)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted, got %v", err)
+	}
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	flags, err = store.GetFlags(1)
+	if err != nil || flags != 0x81 {
+		t.Errorf("expected flags 0x81 after polish, got %#x, %v", flags, err)
+	}
+	value, err := store.Get(1)
+	if err != nil || string(value) != "updated" {
+		t.Errorf("expected updated, got %q, %v", value, err)
+	}
+}
+
+func TestSetWithFlagsOldFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Write a version 2 file, whose records have no flags
+	old := format{version: 2, flags: flagChecksum}
+	data := append(old.encodeHeader(), old.encodeRecord(recordActive, 0, 0, time.Now().UnixNano(), []byte("old"))...)
+	err := os.WriteFile(path, data, 0666)
+	if err != nil {
+		t.Fatalf("failed to write data file: %v", err)
+	}
+	err = os.WriteFile(path+".idx", old.encodeIndexEntry(0, headerSize), 0666)
+	if err != nil {
+		t.Fatalf("failed to write index file: %v", err)
+	}
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	flags, err := store.GetFlags(0)
+	if err != nil || flags != 0 {
+		t.Errorf("expected no flags, got %#x, %v", flags, err)
+	}
+	_, err = store.SetWithFlags([]byte("new"), 1)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+	line, err := store.SetWithFlags([]byte("new"), 0)
+	if err != nil {
+		t.Fatalf("set with no flags failed: %v", err)
+	}
+	value, err := store.Get(line)
+	if err != nil || string(value) != "new" {
+		t.Errorf("expected new, got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,355 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"time"
+)
+
+// A data file starts with a fixed-size header:
+//
+//	[0:4]   magic "LNST"
+//	[4]     format version
+//	[5]     compression codec
+//	[6:8]   reserved
+//	[8:12]  feature flags, little endian
+//	[12:20] base line: line number of the first record in the file, little endian
+//	[20:28] first line not evicted by WithMaxRecords, little endian
+//	[28:32] index interval with flagSparse, little endian; reserved otherwise
+//
+// followed by the records, each laid out as:
+//
+//	type byte | replaced line (8, update records only) | user flags (1, version 3) | write time (8, version 2) | value length (4, 8 with flagLargeValues) | value | CRC32 of value (4, with flagChecksum)
+//
+// The value length and checksum describe the value as stored, after compression and
+// encryption. Encrypted values start with their 12-byte nonce. The write time is in
+// Unix nanoseconds; files of version 1 have no write times and read as the zero time.
+// The user flags are set with SetWithFlags; files before version 3 read as flags 0.
+//
+// Files created before the header was introduced start directly with the first
+// record. They are recognized by a valid record type byte at offset 0 and read as
+// format version 0, which has no flags. Anything else is rejected with ErrBadMagic.
+
+const (
+	headerMagic   = "LNST"
+	headerSize    = 32
+	formatVersion = 3 // Version written to new files
+
+	maxPrefixLen = 1 + 8 + 1 + 8 + 8 // Longest record prefix: an update record with user flags, a write time, and a large value length
+
+	maxLargeValueSize = 1 << 62 // Values of stores with flagLargeValues are only limited by WithMaxValueSize; this keeps offsets in range
+)
+
+// Feature flags stored in the header.
+const (
+	flagChecksum    uint32 = 1 << 0 // Records end with a CRC32 of the value
+	flagEncrypted   uint32 = 1 << 1 // Values are encrypted with AES-GCM
+	flagSegmented   uint32 = 1 << 2 // Records continue in segment files; see segment.go
+	flagBaseLine    uint32 = 1 << 3 // Lines are numbered from the header's base line
+	flagShared      uint32 = 1 << 4 // Several index entries may point at one record; see WithDedup
+	flagReserved    uint32 = 1 << 5 // Records may be pending placeholders; see Reserve
+	flagSparse      uint32 = 1 << 6 // The index only holds every interval-th line; see WithSparseIndex
+	flagCompact     uint32 = 1 << 7 // Index entries hold only the offset; see WithCompactIndex
+	flagLargeValues uint32 = 1 << 8 // Value lengths take 8 bytes; see WithLargeValues
+	flagIndexCRC    uint32 = 1 << 9 // Index entries end with a CRC32 of the entry; see WithIndexChecksums
+
+	knownFlags = flagChecksum | flagEncrypted | flagSegmented | flagBaseLine | flagShared | flagReserved | flagSparse | flagCompact |
+		flagLargeValues | flagIndexCRC
+)
+
+// Record type bits stored at the start of every data record.
+const (
+	recordActive  byte = 0      // Live record
+	recordDeleted byte = 1 << 0 // Tombstoned record, kept in place so line numbers stay stable
+	recordUpdate  byte = 1 << 1 // Value written by Update; followed by the 8-byte line it replaces
+	recordPending byte = 1 << 2 // Placeholder for a line reserved by Reserve; always set with recordDeleted
+)
+
+// validRecordType reports whether typeByte only uses known record type bits.
+func validRecordType(typeByte byte) bool {
+	return typeByte&^(recordDeleted|recordUpdate|recordPending) == 0
+}
+
+// format describes the layout of a store's data file.
+type format struct {
+	version  byte        // 0 for headerless files
+	codec    Compression // Codec used for values
+	flags    uint32      // Feature flags from the header
+	interval uint32      // Lines per index entry with flagSparse
+}
+
+// newFormat returns the format used for files created with o.
+func newFormat(o options) format {
+	f := format{version: formatVersion, codec: o.compression, flags: flagChecksum}
+	if o.encryptionKey != nil {
+		f.flags |= flagEncrypted
+	}
+	if o.dedup {
+		f.flags |= flagShared
+	}
+	if o.sparseIndex > 1 {
+		f.flags |= flagSparse
+		f.interval = o.sparseIndex
+	}
+	if o.compactIndex {
+		f.flags |= flagCompact
+	}
+	if o.largeValues {
+		f.flags |= flagLargeValues
+	}
+	if o.indexChecksums {
+		f.flags |= flagIndexCRC
+	}
+	return f
+}
+
+// headerLen returns the number of bytes before the first record.
+func (f format) headerLen() int64 {
+	if f.version == 0 {
+		return 0
+	}
+	return headerSize
+}
+
+// encrypted reports whether values are encrypted.
+func (f format) encrypted() bool {
+	return f.flags&flagEncrypted != 0
+}
+
+// checksums reports whether records carry a trailing CRC32.
+func (f format) checksums() bool {
+	return f.flags&flagChecksum != 0
+}
+
+// timestamps reports whether records carry their write time.
+func (f format) timestamps() bool {
+	return f.version >= 2
+}
+
+// indexEvery returns how many lines share an index entry: the interval with
+// flagSparse, 1 otherwise.
+func (f format) indexEvery() uint64 {
+	if f.flags&flagSparse != 0 {
+		return uint64(f.interval)
+	}
+	return 1
+}
+
+// indexEntrySize returns the size of an index entry: 8 bytes of offset with
+// flagCompact, preceded by 8 bytes of line number otherwise, and followed by a 4-byte
+// CRC32 with flagIndexCRC.
+func (f format) indexEntrySize() int64 {
+	n := int64(16)
+	if f.flags&flagCompact != 0 {
+		n = 8
+	}
+	if f.flags&flagIndexCRC != 0 {
+		n += 4
+	}
+	return n
+}
+
+// userFlags reports whether records carry a user flags byte.
+func (f format) userFlags() bool {
+	return f.version >= 3
+}
+
+// lengthSize returns the size of a record's value length field.
+func (f format) lengthSize() int64 {
+	if f.flags&flagLargeValues != 0 {
+		return 8
+	}
+	return 4
+}
+
+// prefixLen returns the size of a record's fields before its value.
+func (f format) prefixLen(typeByte byte) int64 {
+	n := 1 + f.lengthSize()
+	if typeByte&recordUpdate != 0 {
+		n += 8
+	}
+	if f.userFlags() {
+		n++
+	}
+	if f.timestamps() {
+		n += 8
+	}
+	return n
+}
+
+// flagsPos returns the position of the user flags byte in a record's prefix. It is only
+// meaningful when the format has user flags, which always come with a write time.
+func (f format) flagsPos(typeByte byte) int64 {
+	return f.prefixLen(typeByte) - 8 - f.lengthSize() - 1
+}
+
+// valueLen returns the value length stored in prefix, a record's fields before its
+// value. A large value length past the int64 range reads as -1.
+func (f format) valueLen(prefix []byte) int64 {
+	end := f.prefixLen(prefix[0])
+	if f.flags&flagLargeValues == 0 {
+		return int64(binary.LittleEndian.Uint32(prefix[end-4 : end]))
+	}
+	n := binary.LittleEndian.Uint64(prefix[end-8 : end])
+	if n > math.MaxInt64 {
+		return -1
+	}
+	return int64(n)
+}
+
+// writeTime returns the write time stored in prefix, in Unix nanoseconds. It is only
+// meaningful when the format has timestamps.
+func (f format) writeTime(prefix []byte) int64 {
+	end := f.prefixLen(prefix[0]) - f.lengthSize()
+	return int64(binary.LittleEndian.Uint64(prefix[end-8 : end]))
+}
+
+// unixTime converts a stored write time to a time.Time, keeping 0 as the zero time.
+func unixTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// trailerLen returns the size of a record's fields after its value.
+func (f format) trailerLen() int64 {
+	if f.checksums() {
+		return 4
+	}
+	return 0
+}
+
+// encodeHeader builds the file header for f.
+func (f format) encodeHeader() []byte {
+	header := make([]byte, headerSize)
+	copy(header[0:4], headerMagic)
+	header[4] = f.version
+	header[5] = byte(f.codec)
+	binary.LittleEndian.PutUint32(header[8:12], f.flags)
+	if f.flags&flagSparse != 0 {
+		binary.LittleEndian.PutUint32(header[28:32], f.interval)
+	}
+	return header
+}
+
+// encodeLineHeader builds the file header for f with a store's base line and first
+// kept line. flagBaseLine is only set when either is nonzero, so stores that never
+// evicted anything keep the plain header.
+func (f format) encodeLineHeader(baseLine, evictLine uint64) []byte {
+	f.flags &^= flagBaseLine
+	if baseLine > 0 || evictLine > 0 {
+		f.flags |= flagBaseLine
+	}
+	header := f.encodeHeader()
+	binary.LittleEndian.PutUint64(header[12:20], baseLine)
+	binary.LittleEndian.PutUint64(header[20:28], evictLine)
+	return header
+}
+
+// decodeHeader parses a file header.
+func decodeHeader(header []byte) (format, error) {
+	f := format{
+		version: header[4],
+		codec:   Compression(header[5]),
+		flags:   binary.LittleEndian.Uint32(header[8:12]),
+	}
+	if f.version == 0 || f.version > formatVersion {
+		return format{}, fmt.Errorf("format version %d: %w", f.version, ErrUnsupportedVersion)
+	}
+	if f.flags&^knownFlags != 0 {
+		return format{}, fmt.Errorf("unknown feature flags %#x: %w", f.flags&^knownFlags, ErrUnsupportedVersion)
+	}
+	if !f.codec.valid() {
+		return format{}, fmt.Errorf("compression codec %d: %w", header[5], ErrUnsupportedVersion)
+	}
+	if f.flags&flagSparse != 0 {
+		f.interval = binary.LittleEndian.Uint32(header[28:32])
+		if f.interval < 2 {
+			return format{}, fmt.Errorf("index interval %d: %w", f.interval, ErrUnsupportedVersion)
+		}
+	}
+	return f, nil
+}
+
+// encodeRecord builds a data record. target is only written for update records,
+// userFlags only when the format has user flags, and written, the write time in Unix
+// nanoseconds, only when the format has timestamps.
+func (f format) encodeRecord(typeByte byte, target uint64, userFlags byte, written int64, value []byte) []byte {
+	prefix := f.prefixLen(typeByte)
+	record := make([]byte, prefix+int64(len(value))+f.trailerLen())
+	f.putPrefix(record, typeByte, target, userFlags, written, uint64(len(value)))
+	copy(record[prefix:], value)
+	if f.checksums() {
+		binary.LittleEndian.PutUint32(record[prefix+int64(len(value)):], crc32.ChecksumIEEE(value))
+	}
+	return record
+}
+
+// putPrefix writes the fields before a record's value to the start of record.
+func (f format) putPrefix(record []byte, typeByte byte, target uint64, userFlags byte, written int64, valLen uint64) {
+	prefix := f.prefixLen(typeByte)
+	lenStart := prefix - f.lengthSize()
+	record[0] = typeByte
+	if typeByte&recordUpdate != 0 {
+		binary.LittleEndian.PutUint64(record[1:9], target)
+	}
+	if f.userFlags() {
+		record[f.flagsPos(typeByte)] = userFlags
+	}
+	if f.timestamps() {
+		binary.LittleEndian.PutUint64(record[lenStart-8:lenStart], uint64(written))
+	}
+	if f.flags&flagLargeValues != 0 {
+		binary.LittleEndian.PutUint64(record[lenStart:prefix], valLen)
+	} else {
+		binary.LittleEndian.PutUint32(record[lenStart:prefix], uint32(valLen))
+	}
+}
+
+// encodeIndexEntry builds an index entry: 8 bytes lineNum + 8 bytes offset, or only
+// the offset with flagCompact, then a CRC32 of those with flagIndexCRC.
+func (f format) encodeIndexEntry(line, dataOffset uint64) []byte {
+	indexEntry := make([]byte, f.indexEntrySize())
+	if f.flags&flagCompact == 0 {
+		binary.LittleEndian.PutUint64(indexEntry[0:8], line)
+	}
+	end := f.entryCRCPos()
+	binary.LittleEndian.PutUint64(indexEntry[end-8:end], dataOffset)
+	if f.flags&flagIndexCRC != 0 {
+		binary.LittleEndian.PutUint32(indexEntry[end:], crc32.ChecksumIEEE(indexEntry[:end]))
+	}
+	return indexEntry
+}
+
+// entryCRCPos returns the position of an index entry's CRC32, which is also where its
+// other fields end.
+func (f format) entryCRCPos() int64 {
+	if f.flags&flagIndexCRC != 0 {
+		return f.indexEntrySize() - 4
+	}
+	return f.indexEntrySize()
+}
+
+// entryOffset returns the data offset stored in indexEntry.
+func (f format) entryOffset(indexEntry []byte) uint64 {
+	end := f.entryCRCPos()
+	return binary.LittleEndian.Uint64(indexEntry[end-8 : end])
+}
+
+// entryIntact reports whether indexEntry matches its CRC32, or has none.
+func (f format) entryIntact(indexEntry []byte) bool {
+	if f.flags&flagIndexCRC == 0 {
+		return true
+	}
+	end := f.entryCRCPos()
+	return crc32.ChecksumIEEE(indexEntry[:end]) == binary.LittleEndian.Uint32(indexEntry[end:])
+}
+
+// entryHolds reports whether indexEntry belongs to line. Compact entries carry no line
+// number and are only found by their position.
+func (f format) entryHolds(indexEntry []byte, line uint64) bool {
+	return f.flags&flagCompact != 0 || binary.LittleEndian.Uint64(indexEntry[0:8]) == line
+}
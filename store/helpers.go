@@ -0,0 +1,28 @@
+package store
+
+import "fmt"
+
+// SetString appends v like Set and returns its line number.
+func (s *Store) SetString(v string) (uint64, error) {
+	return s.Set([]byte(v))
+}
+
+// GetString retrieves the value at line like Get, as a string.
+func (s *Store) GetString(line uint64) (string, error) {
+	value, err := s.Get(line)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// MustGet retrieves the value at line like Get, but panics if Get returns an error.
+// It is meant for scripts, examples, and tests, where a missing or unreadable line is
+// a bug; code that must keep running should call Get and handle the error.
+func (s *Store) MustGet(line uint64) []byte {
+	value, err := s.Get(line)
+	if err != nil {
+		panic(fmt.Sprintf("linestore: MustGet(%d): %v", line, err))
+	}
+	return value
+}
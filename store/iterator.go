@@ -0,0 +1,156 @@
+package store
+
+import "errors"
+
+// ErrStopIteration can be returned by a ForEach callback to stop early without an error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// Iter walks the live records of a store in line order, or from the last line to the
+// first for ReverseIterator, reading one record at a time so memory use stays constant
+// regardless of the store size.
+//
+// The iterator captures the line count when it is created, so records appended
+// afterwards are not visited. The store's read lock is only held while a record is
+// being read, which means writers are never blocked for the iterator's lifetime.
+type Iter struct {
+	store  *Store
+	next   uint64 // Next line to read, or one past it when reverse
+	end    uint64 // Line count captured at creation, or the first kept line when reverse
+	line   uint64
+	value  []byte
+	err    error
+	closed bool
+
+	reverse        bool // Walk from the last line to the first
+	includeDeleted bool // Stop at deleted lines too; set by IncludeDeleted
+	deleted        bool // The current line is deleted
+}
+
+// Iterator returns an iterator over all live records, starting at the first line that
+// has not been evicted.
+func (s *Store) Iterator() *Iter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &Iter{store: s, next: s.evictLine, end: s.lineCount}
+}
+
+// ReverseIterator returns an iterator over all live records starting from the last line,
+// for scanning newest-first without reading the whole store, like ListAllReverse does.
+func (s *Store) ReverseIterator() *Iter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &Iter{store: s, next: s.lineCount, end: s.evictLine, reverse: true}
+}
+
+// IncludeDeleted makes the iterator stop at deleted lines as well, reporting them with
+// Deleted and a nil Value. It must be called before the first Next and returns it.
+func (it *Iter) IncludeDeleted() *Iter {
+	it.includeDeleted = true
+	return it
+}
+
+// ForEach calls fn for every live record in line order. It stops at the first error
+// returned by fn and returns it, except for ErrStopIteration which stops cleanly with a
+// nil error. Like Iterator, the read lock is not held while fn runs, so fn may write to
+// the store; records appended during the walk are not visited.
+func (s *Store) ForEach(fn func(line uint64, value []byte) error) error {
+	it := s.Iterator()
+	defer it.Close()
+
+	for it.Next() {
+		err := fn(it.Line(), it.Value())
+		if errors.Is(err, ErrStopIteration) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// Next advances to the next live record. It returns false when the iteration is
+// finished, the iterator was closed, or an error occurred (check Err).
+func (it *Iter) Next() bool {
+	if it.closed || it.err != nil {
+		return false
+	}
+
+	for it.more() {
+		var line uint64
+		if it.reverse {
+			it.next--
+			line = it.next
+		} else {
+			line = it.next
+			it.next++
+		}
+
+		it.store.mu.RLock()
+		typeByte, value, err := it.store.readLine(line)
+		it.store.mu.RUnlock()
+		if errors.Is(err, ErrEvicted) {
+			// Evicted by writes since the iterator was created; in reverse, so is every
+			// line before it
+			if it.reverse {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			it.err = err
+			it.value = nil
+			return false
+		}
+		it.deleted = typeByte&recordDeleted != 0
+		if it.deleted {
+			if !it.includeDeleted {
+				continue
+			}
+			value = nil
+		}
+
+		it.line = line
+		it.value = value
+		return true
+	}
+
+	it.value = nil
+	return false
+}
+
+// more reports whether lines are left to read.
+func (it *Iter) more() bool {
+	if it.reverse {
+		return it.next > it.end
+	}
+	return it.next < it.end
+}
+
+// Line returns the line number of the current record.
+func (it *Iter) Line() uint64 {
+	return it.line
+}
+
+// Value returns the value of the current record.
+func (it *Iter) Value() []byte {
+	return it.value
+}
+
+// Deleted reports whether the current line is deleted, which is only the case for an
+// iterator set up with IncludeDeleted.
+func (it *Iter) Deleted() bool {
+	return it.deleted
+}
+
+// Err returns the first error encountered during iteration, if any.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// Close stops the iteration and releases the current value.
+func (it *Iter) Close() error {
+	it.closed = true
+	it.value = nil
+	return nil
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	it := store.Iterator()
+	defer it.Close()
+
+	// Appends after creation are not visited
+	_, err = store.Set([]byte("value3"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	var lines []uint64
+	for it.Next() {
+		lines = append(lines, it.Line())
+		if want := "value" + string(rune('0'+it.Line())); string(it.Value()) != want {
+			t.Errorf("expected '%s', got '%s'", want, it.Value())
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if len(lines) != 2 || lines[0] != 0 || lines[1] != 2 {
+		t.Errorf("expected lines [0 2], got %v", lines)
+	}
+}
+
+func TestReverseIterator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	var lines []uint64
+	it := store.ReverseIterator()
+	for it.Next() {
+		lines = append(lines, it.Line())
+		if want := "value" + string(rune('0'+it.Line())); string(it.Value()) != want {
+			t.Errorf("expected '%s', got '%s'", want, it.Value())
+		}
+	}
+	it.Close()
+	if err := it.Err(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if len(lines) != 3 || lines[0] != 3 || lines[1] != 1 || lines[2] != 0 {
+		t.Errorf("expected lines [3 1 0], got %v", lines)
+	}
+
+	// Deleted lines are reported when asked for
+	var deleted []uint64
+	it = store.ReverseIterator().IncludeDeleted()
+	defer it.Close()
+	for it.Next() {
+		if it.Deleted() {
+			if it.Value() != nil {
+				t.Errorf("expected no value for deleted line %d, got '%s'", it.Line(), it.Value())
+			}
+			deleted = append(deleted, it.Line())
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != 2 {
+		t.Errorf("expected deleted lines [2], got %v", deleted)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+
+	var seen []uint64
+	err = store.ForEach(func(line uint64, value []byte) error {
+		seen = append(seen, line)
+		if line == 1 {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("for each failed: %v", err)
+	}
+	if len(seen) != 2 {
+		t.Errorf("expected to stop after 2 records, saw %v", seen)
+	}
+
+	errBoom := errors.New("boom")
+	err = store.ForEach(func(line uint64, value []byte) error {
+		return errBoom
+	})
+	if !errors.Is(err, errBoom) {
+		t.Errorf("expected callback error to propagate, got %v", err)
+	}
+}
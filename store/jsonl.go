@@ -0,0 +1,122 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// jsonlRecord is one line of the newline-delimited JSON written by ExportJSONL.
+// Values are arbitrary bytes, so encoding/json writes them as base64.
+type jsonlRecord struct {
+	Line  uint64 `json:"line"`
+	Value []byte `json:"value"`
+}
+
+// importBatchSize is the number of lines ImportJSONL and ReadFrom append per SetBatch.
+const importBatchSize = 1024
+
+// ExportJSONL writes every live record to w as newline-delimited JSON, one object per
+// line such as {"line":0,"value":"dmFsdWUw"}, with the value base64-encoded. Deleted
+// lines are skipped. Like ForEach, records are read one at a time without holding the
+// read lock in between.
+func (s *Store) ExportJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return s.ForEach(func(line uint64, value []byte) error {
+		err := enc.Encode(jsonlRecord{Line: line, Value: value})
+		if err != nil {
+			return fmt.Errorf("failed to write line %d: %v", line, err)
+		}
+		return nil
+	})
+}
+
+// ImportJSONL creates a store at path from newline-delimited JSON written by ExportJSONL
+// and returns it open. Line numbers are kept: lines missing from the input, such as those
+// deleted before the export, are filled with deleted records. Lines must appear in
+// increasing order. It refuses to overwrite an existing store, and removes the new files
+// again if the input cannot be imported. opts are passed to NewStore.
+func ImportJSONL(r io.Reader, path string, opts ...Option) (*Store, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil, fmt.Errorf("failed to import into %s: %w", path, os.ErrExist)
+	}
+	store, err := NewStore(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.importJSONL(r)
+	if err != nil {
+		store.Close()
+		os.Remove(path)
+		os.Remove(indexPathFor(path, store.opts))
+		return nil, err
+	}
+	return store, nil
+}
+
+// importJSONL appends the records read from r, in batches.
+func (s *Store) importJSONL(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	return s.importRecords(func() (uint64, []byte, error) {
+		var record jsonlRecord
+		err := dec.Decode(&record)
+		return record.Line, record.Value, err
+	})
+}
+
+// importRecords appends the records returned by next, in batches, until it returns
+// io.EOF. Line numbers are kept by filling the lines missing in between with deleted
+// records, so they must be increasing and not below the store's line count.
+func (s *Store) importRecords(next func() (uint64, []byte, error)) error {
+	line := s.Count() // Line the next value is appended as
+	var batch [][]byte
+	var gaps []uint64
+	flush := func() error {
+		_, err := s.SetBatch(batch)
+		if err != nil {
+			return err
+		}
+		for _, gap := range gaps {
+			err = s.Delete(gap)
+			if err != nil {
+				return err
+			}
+		}
+		batch, gaps = batch[:0], gaps[:0]
+		return nil
+	}
+
+	for {
+		recordLine, value, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read record after line %d: %w", line, err)
+		}
+		if recordLine < line {
+			return fmt.Errorf("line %d is out of order, expected %d or later", recordLine, line)
+		}
+
+		for ; line <= recordLine; line++ {
+			lineValue := value
+			if line < recordLine {
+				// Placeholder for a missing line, deleted once appended
+				lineValue = nil
+				gaps = append(gaps, line)
+			}
+			batch = append(batch, lineValue)
+			if len(batch) == importBatchSize {
+				err = flush()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flush()
+}
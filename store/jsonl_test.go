@@ -0,0 +1,62 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	importPath := filepath.Join(dir, "test_import.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), {0xff, '\n', 0x00}})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = store.ExportJSONL(&buf)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	want := `{"line":0,"value":"dmFsdWUw"}` + "\n" + `{"line":2,"value":"/woA"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+
+	imported, err := ImportJSONL(strings.NewReader(buf.String()), importPath)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	defer imported.Close()
+	if imported.Count() != 3 {
+		t.Errorf("expected 3 lines, got %d", imported.Count())
+	}
+	_, err = imported.Get(1)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted for the missing line, got %v", err)
+	}
+	value, err := imported.Get(2)
+	if err != nil || !bytes.Equal(value, []byte{0xff, '\n', 0x00}) {
+		t.Errorf("expected the binary value at line 2, got %v, %v", value, err)
+	}
+
+	_, err = ImportJSONL(strings.NewReader(buf.String()), importPath)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected os.ErrExist for an existing store, got %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package store
+
+import "os"
+
+// lockFile is a no-op on platforms without file locking.
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+// unlockFile is a no-op on platforms without file locking.
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,54 @@
+//go:build windows
+
+package store
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockRange returns the byte range that is locked. Windows locks are mandatory, so a
+// byte far past any real data is used to keep reads and writes unaffected.
+func lockRange() *syscall.Overlapped {
+	return &syscall.Overlapped{Offset: 0xffffffff, OffsetHigh: 0x7fffffff}
+}
+
+// lockFile takes a non-blocking LockFileEx lock on f, shared or exclusive.
+func lockFile(f *os.File, exclusive bool) error {
+	flags := uint32(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	r1, _, err := procLockFileEx.Call(f.Fd(), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(lockRange())))
+	if r1 == 0 {
+		if err == errorLockViolation {
+			return ErrLocked
+		}
+		return fmt.Errorf("failed to lock data file: %v", err)
+	}
+	return nil
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	r1, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(lockRange())))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
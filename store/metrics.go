@@ -0,0 +1,108 @@
+package store
+
+// Counter is a metric that only goes up. prometheus.Counter satisfies it.
+type Counter interface {
+	Add(float64)
+}
+
+// Gauge is a metric that is set to the current value. prometheus.Gauge satisfies it.
+type Gauge interface {
+	Set(float64)
+}
+
+// MetricsRegistry creates the metrics a store reports, so the store doesn't depend on a
+// particular metrics library. The metrics must be safe for concurrent use. For
+// Prometheus, an adapter whose methods return promauto.With(reg).NewCounter and
+// NewGauge with the given name and help is enough.
+type MetricsRegistry interface {
+	NewCounter(name, help string) Counter
+	NewGauge(name, help string) Gauge
+}
+
+// metricOp identifies an operation counted by the store's metrics.
+type metricOp int
+
+const (
+	opSet metricOp = iota
+	opGet
+	opUpdate
+	opDelete
+	opPolish
+	opCount // Number of operations
+)
+
+// storeMetrics holds the metrics registered with RegisterMetrics.
+type storeMetrics struct {
+	ops          [opCount]Counter
+	errors       Counter
+	bytesWritten Counter
+	bytesRead    Counter
+	lines        Gauge
+	dataSize     Gauge
+}
+
+// RegisterMetrics creates the store's metrics in r and starts updating them: counters
+// for sets, gets, updates, deletes, polishes, errors, and bytes written and read, and
+// gauges for the line count and the data file size. Values written by SetBatch count
+// as sets, and every record read counts towards the bytes read, including reads by
+// List and the iterators. Calling it again replaces the metrics.
+func (s *Store) RegisterMetrics(r MetricsRegistry) {
+	m := &storeMetrics{
+		errors:       r.NewCounter("linestore_errors_total", "Operations that returned an error."),
+		bytesWritten: r.NewCounter("linestore_bytes_written_total", "Bytes of records written to the data file."),
+		bytesRead:    r.NewCounter("linestore_bytes_read_total", "Bytes of records read from the data file."),
+		lines:        r.NewGauge("linestore_lines", "Lines in the store, including deleted ones."),
+		dataSize:     r.NewGauge("linestore_data_bytes", "Size of the data file in bytes."),
+	}
+	m.ops[opSet] = r.NewCounter("linestore_sets_total", "Values appended by Set, SetReader, and SetBatch.")
+	m.ops[opGet] = r.NewCounter("linestore_gets_total", "Values read by Get.")
+	m.ops[opUpdate] = r.NewCounter("linestore_updates_total", "Lines replaced by Update.")
+	m.ops[opDelete] = r.NewCounter("linestore_deletes_total", "Lines deleted by Delete.")
+	m.ops[opPolish] = r.NewCounter("linestore_polishes_total", "Completed Polish and Compact runs.")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.Store(m)
+	s.updateGauges()
+}
+
+// observe counts n successful operations of kind op, or an error if err is set.
+func (s *Store) observe(op metricOp, n int, err error) {
+	m := s.metrics.Load()
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.errors.Add(1)
+		return
+	}
+	m.ops[op].Add(float64(n))
+}
+
+// countWritten counts n bytes written to the data file.
+func (s *Store) countWritten(n int) {
+	if m := s.metrics.Load(); m != nil {
+		m.bytesWritten.Add(float64(n))
+	}
+}
+
+// countRead counts n bytes read from the data file.
+func (s *Store) countRead(n int) {
+	if m := s.metrics.Load(); m != nil {
+		m.bytesRead.Add(float64(n))
+	}
+}
+
+// updateGauges sets the gauges to the store's current size. The caller must hold the
+// lock.
+func (s *Store) updateGauges() {
+	m := s.metrics.Load()
+	if m == nil {
+		return
+	}
+	m.lines.Set(float64(s.lineCount))
+	size, err := s.dataSize()
+	if err == nil {
+		m.dataSize.Set(float64(size))
+	}
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"sync"
+	"testing"
+)
+
+// testMetric records the value of a counter or gauge.
+type testMetric struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (m *testMetric) Add(v float64) {
+	m.mu.Lock()
+	m.value += v
+	m.mu.Unlock()
+}
+
+func (m *testMetric) Set(v float64) {
+	m.mu.Lock()
+	m.value = v
+	m.mu.Unlock()
+}
+
+// testRegistry keeps every metric it creates by name.
+type testRegistry map[string]*testMetric
+
+func (r testRegistry) NewCounter(name, help string) Counter {
+	r[name] = &testMetric{}
+	return r[name]
+}
+
+func (r testRegistry) NewGauge(name, help string) Gauge {
+	r[name] = &testMetric{}
+	return r[name]
+}
+
+func TestMetrics(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.Set([]byte("before"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	metrics := testRegistry{}
+	store.RegisterMetrics(metrics)
+	if metrics["linestore_lines"].value != 1 {
+		t.Errorf("expected the lines gauge to start at 1, got %v", metrics["linestore_lines"].value)
+	}
+
+	_, err = store.Set([]byte("value1"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Get(1)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	err = store.Delete(0)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = store.Get(0)
+	if err == nil {
+		t.Fatal("expected an error getting a deleted line")
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+
+	want := map[string]float64{
+		"linestore_sets_total":     3,
+		"linestore_gets_total":     1,
+		"linestore_deletes_total":  1,
+		"linestore_polishes_total": 1,
+		"linestore_errors_total":   1,
+		"linestore_lines":          3,
+	}
+	for name, value := range want {
+		if metrics[name].value != value {
+			t.Errorf("expected %s to be %v, got %v", name, value, metrics[name].value)
+		}
+	}
+	size, err := store.dataSize()
+	if err != nil {
+		t.Fatalf("failed to get data size: %v", err)
+	}
+	if metrics["linestore_data_bytes"].value != float64(size) {
+		t.Errorf("expected data size %d, got %v", size, metrics["linestore_data_bytes"].value)
+	}
+	if metrics["linestore_bytes_written_total"].value == 0 || metrics["linestore_bytes_read_total"].value == 0 {
+		t.Errorf("expected bytes to be counted, got %v written and %v read",
+			metrics["linestore_bytes_written_total"].value, metrics["linestore_bytes_read_total"].value)
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import "sync"
+
+// mmapBackend serves reads of a data file from a read-only memory mapping, so values
+// can be borrowed without copying. Appends only ever extend the file, so the mapping
+// stays valid across them; reads past its end map the file again. Mappings replaced
+// that way are kept until the next write, since borrowed slices may still point into
+// them, and every mapping is dropped before the file is truncated.
+type mmapBackend struct {
+	*fileBackend
+	mu      sync.Mutex
+	data    []byte   // Current mapping, nil until the first read
+	retired [][]byte // Older, shorter mappings that borrowed slices may still use
+}
+
+// borrow returns the n bytes at off as a slice of the mapping, or false if they are
+// past the end of the file or the file cannot be mapped.
+func (m *mmapBackend) borrow(off, n int64) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	end := off + n
+	if end > int64(len(m.data)) {
+		size, err := m.fileBackend.Size()
+		if err != nil || end > size {
+			return nil, false
+		}
+		data, err := mapFile(m.File, size)
+		if err != nil {
+			return nil, false
+		}
+		if m.data != nil {
+			m.retired = append(m.retired, m.data)
+		}
+		m.data = data
+	}
+	return m.data[off:end:end], true
+}
+
+// ReadAt implements io.ReaderAt, copying from the mapping where possible.
+func (m *mmapBackend) ReadAt(p []byte, off int64) (int, error) {
+	b, ok := m.borrow(off, int64(len(p)))
+	if !ok {
+		return m.fileBackend.ReadAt(p, off)
+	}
+	return copy(p, b), nil
+}
+
+// WriteAt drops the retired mappings and writes to the file. The store only writes
+// while holding its write lock, which ends the lifetime of borrowed slices.
+func (m *mmapBackend) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	m.unmapRetired()
+	m.mu.Unlock()
+	return m.fileBackend.WriteAt(p, off)
+}
+
+// Truncate drops every mapping before changing the file's size, since pages past the
+// new end could no longer be read.
+func (m *mmapBackend) Truncate(size int64) error {
+	m.mu.Lock()
+	m.unmapAll()
+	m.mu.Unlock()
+	return m.fileBackend.Truncate(size)
+}
+
+// Close drops every mapping and closes the file.
+func (m *mmapBackend) Close() error {
+	m.mu.Lock()
+	m.unmapAll()
+	m.mu.Unlock()
+	return m.fileBackend.Close()
+}
+
+// unmapRetired unmaps the retired mappings. The caller must hold m.mu.
+func (m *mmapBackend) unmapRetired() {
+	for _, data := range m.retired {
+		unmapFile(data)
+	}
+	m.retired = nil
+}
+
+// unmapAll unmaps every mapping. The caller must hold m.mu.
+func (m *mmapBackend) unmapAll() {
+	m.unmapRetired()
+	if m.data != nil {
+		unmapFile(m.data)
+		m.data = nil
+	}
+}
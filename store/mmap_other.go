@@ -0,0 +1,21 @@
+//go:build !unix
+
+package store
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapSupported reports whether WithMmap has an effect on this platform.
+const mmapSupported = false
+
+// mapFile fails on platforms without mmap support.
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
+
+// unmapFile is a no-op on platforms without mmap support.
+func unmapFile(data []byte) error {
+	return nil
+}
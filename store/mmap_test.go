@@ -0,0 +1,66 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithMmap())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		// Each read past the mapping maps the grown file again
+		value, err := store.Get(uint64(i))
+		if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("expected value%d, got %s, %v", i, value, err)
+		}
+	}
+	if m, ok := store.file.(*mmapBackend); mmapSupported && (!ok || m.data == nil) {
+		t.Errorf("expected reads through a mapping, got %T", store.file)
+	}
+
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	records, err := store.ListBorrow()
+	if err != nil {
+		t.Fatalf("list borrow failed: %v", err)
+	}
+	if len(records) != 2 || string(records[1][1].([]byte)) != "value2" {
+		t.Errorf("expected lines 0 and 2, got %v", records)
+	}
+
+	// Polish replaces the data file, which is mapped afresh
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	value, err := store.Get(1)
+	if err != nil || string(value) != "value2" {
+		t.Errorf("expected value2 at line 1 after polish, got %s, %v", value, err)
+	}
+	err = store.Clear()
+	if err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	line, err := store.Set([]byte("fresh"))
+	if err != nil {
+		t.Fatalf("set after clear failed: %v", err)
+	}
+	value, err = store.Get(line)
+	if err != nil || string(value) != "fresh" {
+		t.Errorf("expected fresh after clear, got %s, %v", value, err)
+	}
+}
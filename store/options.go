@@ -0,0 +1,377 @@
+package store
+
+import (
+	"hash"
+	"os"
+	"time"
+)
+
+// SyncMode controls whether writes are fsynced before they return.
+type SyncMode int
+
+const (
+	// SyncAlways fsyncs the data and index files after every write. This is the default.
+	SyncAlways SyncMode = iota
+	// SyncNone leaves fsyncing to Flush and Close, so a crash may lose recent writes.
+	SyncNone
+)
+
+// OrphanPolicy controls what recovery does with complete records that have no index entry.
+type OrphanPolicy int
+
+const (
+	// OrphanDiscard truncates orphaned records away. This is the default.
+	OrphanDiscard OrphanPolicy = iota
+	// OrphanReindex keeps orphaned records and appends their index entries.
+	OrphanReindex
+)
+
+// Default settings used by NewStore when no option overrides them.
+const (
+	DefaultFileMode     os.FileMode = 0666
+	DefaultMaxValueSize uint32      = 1 << 20
+)
+
+// Option configures a store opened with NewStore.
+type Option func(*options)
+
+// options holds the settings a store is opened with.
+type options struct {
+	fileMode       os.FileMode      // Permissions for files the store creates
+	maxValueSize   uint32           // Largest value accepted, in bytes
+	maxValueSet    bool             // maxValueSize was set by WithMaxValueSize, so it holds for large values too
+	syncMode       SyncMode         // When writes are fsynced
+	memoryIndex    bool             // Keep the index offsets in memory
+	compression    Compression      // Codec for values in newly created files
+	encryptionKey  []byte           // AES key for encrypted stores
+	recovery       bool             // Repair an incomplete trailing write on open
+	orphanPolicy   OrphanPolicy     // What recovery does with records that have no index entry
+	commitInterval time.Duration    // How long Set waits to share an fsync with other writers
+	readLock       bool             // Take a shared lock in OpenReadOnly
+	segmentSize    int64            // Start a new segment file once the last one reaches this size
+	maxRecords     uint64           // Evict the oldest lines beyond this many, 0 for no limit
+	dedup          bool             // Point lines with identical values at one record
+	logger         Logger           // Receives recovery, compaction, and fsync failure messages
+	tracer         Tracer           // Starts spans for Get, Set, List, and Polish, nil for none
+	mmap           bool             // Read the data file through a memory mapping
+	autoPolish     float64          // Compact in the background above this share of dead bytes, 0 for never
+	maxStoreSize   int64            // Largest size the data file may grow to, 0 for no limit
+	retryAttempts  int              // Tries per file write or fsync, 0 or 1 for no retries
+	retryBackoff   time.Duration    // Wait before the first retry, doubled for each one after
+	retryable      func(error) bool // Reports whether a failed write or fsync is retried, nil for IsTransient
+	autoReindex    bool             // Rebuild an index whose size doesn't match the data file
+	periodicSync   time.Duration    // Fsync both files in the background this often, 0 for never
+	hash           func() hash.Hash // Hash for WithDedup and ContentHash, nil for SHA-256
+	onSyncError    func(error)      // Called with every failed fsync, nil for none
+	syncErrorStop  bool             // Reject writes after the first failed fsync
+	indexPath      string           // Path of the index file, empty for the data file's path plus ".idx"
+	singleFile     bool             // Keep the index in memory only, rebuilt from the data file on open
+	sparseIndex    uint32           // Lines per index entry for new stores; 0 or 1 indexes every line
+	compactIndex   bool             // New stores write 8-byte index entries without line numbers
+	largeValues    bool             // New stores write 8-byte value lengths
+	indexChecksums bool             // New stores end each index entry with a CRC32
+	backupDir      string           // Directory of the backups taken by WithAutoBackup
+	backupInterval time.Duration    // Take a hot backup this often, 0 for never
+	backupKeep     int              // Number of automatic backups kept, below 1 for all of them
+	writeVerify    bool             // Read every appended value back and compare it
+}
+
+// Logger receives the store's internal warnings and events. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// nopLogger discards every message; it is the default Logger.
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...any) {}
+
+// indexPathFor returns the path of the index file of the store at path opened with o.
+func indexPathFor(path string, o options) string {
+	if o.indexPath != "" {
+		return o.indexPath
+	}
+	return path + ".idx"
+}
+
+// defaultOptions returns the settings used when no options are given.
+func defaultOptions() options {
+	return options{
+		fileMode:     DefaultFileMode,
+		maxValueSize: DefaultMaxValueSize,
+		syncMode:     SyncAlways,
+		logger:       nopLogger{},
+	}
+}
+
+// WithFileMode sets the permissions of the files the store creates. The default is DefaultFileMode.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode
+	}
+}
+
+// WithMaxValueSize sets the largest value, in bytes, the store accepts. The default is DefaultMaxValueSize.
+func WithMaxValueSize(size uint32) Option {
+	return func(o *options) {
+		o.maxValueSize = size
+		o.maxValueSet = true
+	}
+}
+
+// WithLargeValues makes a new store write 8-byte value lengths and lifts the
+// DefaultMaxValueSize limit, so values can exceed 4 GiB. It can't be combined with
+// WithCompression. Off by default.
+func WithLargeValues() Option {
+	return func(o *options) {
+		o.largeValues = true
+	}
+}
+
+// WithSyncMode sets when writes are fsynced to disk. The default is SyncAlways.
+func WithSyncMode(mode SyncMode) Option {
+	return func(o *options) {
+		o.syncMode = mode
+	}
+}
+
+// WithMemoryIndex keeps every index offset in memory, 8 bytes per line, so lookups skip the
+// index file. Off by default.
+func WithMemoryIndex() Option {
+	return func(o *options) {
+		o.memoryIndex = true
+	}
+}
+
+// WithCompression sets the codec for values in new stores; existing stores keep theirs.
+// The default is CompressionNone.
+func WithCompression(c Compression) Option {
+	return func(o *options) {
+		o.compression = c
+	}
+}
+
+// WithEncryption encrypts every value with AES-GCM using a 16, 24, or 32-byte key. Stores
+// are unencrypted by default.
+func WithEncryption(key []byte) Option {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+// WithRecovery lets NewStore truncate away a write interrupted by a crash instead of
+// failing to open. Off by default.
+func WithRecovery() Option {
+	return func(o *options) {
+		o.recovery = true
+	}
+}
+
+// WithOrphanPolicy enables recovery like WithRecovery and sets what happens to records
+// with no index entry. The default is OrphanDiscard.
+func WithOrphanPolicy(p OrphanPolicy) Option {
+	return func(o *options) {
+		o.recovery = true
+		o.orphanPolicy = p
+	}
+}
+
+// WithCommitInterval makes concurrent Set calls wait up to d to share one fsync. If it
+// fails, every Set in the group returns the error, although its record was appended.
+// Off by default.
+func WithCommitInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.commitInterval = d
+	}
+}
+
+// WithReadLock makes OpenReadOnly take a shared lock on the data file, which NewStore
+// then waits out with ErrLocked. Off by default.
+func WithReadLock() Option {
+	return func(o *options) {
+		o.readLock = true
+	}
+}
+
+// WithSegmentSize starts a new segment file (store.db.1, store.db.2, ...) once the current
+// one reaches size bytes. The default of 0 keeps a single data file.
+func WithSegmentSize(size int64) Option {
+	return func(o *options) {
+		o.segmentSize = size
+	}
+}
+
+// WithMaxRecords keeps only the most recent n lines, evicting older ones as lines are
+// added. The default of 0 keeps every line.
+func WithMaxRecords(n uint64) Option {
+	return func(o *options) {
+		o.maxRecords = n
+	}
+}
+
+// WithDedup stores identical values once, pointing every line that holds one at the
+// same record. A store opened with it may share records for good, so Delete appends
+// tombstones from then on. Off by default.
+func WithDedup() Option {
+	return func(o *options) {
+		o.dedup = true
+	}
+}
+
+// WithHash sets the hash WithDedup and ContentHash use. The default is SHA-256.
+func WithHash(newHash func() hash.Hash) Option {
+	return func(o *options) {
+		o.hash = newHash
+	}
+}
+
+// WithLogger sends recovery, compaction, and fsync failure messages to l. By default
+// they are discarded.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithTracer records a span with t for every Get, Set, List, and Polish. There is no
+// tracer by default.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// WithMmap reads the data file through a memory mapping, where the platform supports it.
+// Values Get returns may then be slices of the mapping, which must not be modified and
+// are only valid until the next write or Close. Off by default.
+func WithMmap() Option {
+	return func(o *options) {
+		o.mmap = true
+	}
+}
+
+// WithAutoPolish compacts the store in the background once dead records make up more
+// than ratio of the data file. The default of 0 never does.
+func WithAutoPolish(ratio float64) Option {
+	return func(o *options) {
+		o.autoPolish = ratio
+	}
+}
+
+// WithMaxStoreSize fails writes that would grow the data file past size bytes with
+// ErrQuotaExceeded. The default of 0 means no limit.
+func WithMaxStoreSize(size int64) Option {
+	return func(o *options) {
+		o.maxStoreSize = size
+	}
+}
+
+// WithWriteRetry tries a write or fsync that fails with a transient error up to attempts
+// times, waiting backoff, then twice as long each time. Off by default.
+func WithWriteRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = attempts
+		o.retryBackoff = backoff
+	}
+}
+
+// WithRetryPredicate sets which errors WithWriteRetry retries. The default is IsTransient.
+func WithRetryPredicate(retryable func(err error) bool) Option {
+	return func(o *options) {
+		o.retryable = retryable
+	}
+}
+
+// WithAutoReindex lets NewStore rebuild an index file whose size doesn't match the data
+// file instead of failing to open. Stores opened with WithDedup can't be rebuilt and
+// still fail. Off by default.
+func WithAutoReindex() Option {
+	return func(o *options) {
+		o.autoReindex = true
+	}
+}
+
+// WithPeriodicSync fsyncs both files every interval in the background instead of after
+// each write, implying SyncNone. Off by default.
+func WithPeriodicSync(interval time.Duration) Option {
+	return func(o *options) {
+		o.syncMode = SyncNone
+		o.periodicSync = interval
+	}
+}
+
+// WithAutoBackup takes a HotBackup into dir every interval and on Close, keeping the
+// newest keep of them, or all if keep is below 1. Off by default.
+func WithAutoBackup(dir string, interval time.Duration, keep int) Option {
+	return func(o *options) {
+		o.backupDir = dir
+		o.backupInterval = interval
+		o.backupKeep = keep
+	}
+}
+
+// WithIndexPath keeps the index file at indexPath. The default is the data file's path
+// plus ".idx".
+func WithIndexPath(indexPath string) Option {
+	return func(o *options) {
+		o.indexPath = indexPath
+	}
+}
+
+// WithSingleFile writes no index file and rebuilds the index in memory from the records
+// on every open. Compared to the two-file layout, the store can be copied as one file
+// and writes skip the index fsync, but opening reads every record and the index takes 16
+// bytes of memory per line. Off by default.
+func WithSingleFile() Option {
+	return func(o *options) {
+		o.singleFile = true
+	}
+}
+
+// WithSparseIndex makes a new store write an index entry for only every every-th line.
+// The default of 0 or 1 indexes every line.
+func WithSparseIndex(every uint32) Option {
+	return func(o *options) {
+		o.sparseIndex = every
+	}
+}
+
+// WithCompactIndex makes a new store write 8-byte index entries without line numbers.
+// Off by default.
+func WithCompactIndex() Option {
+	return func(o *options) {
+		o.compactIndex = true
+	}
+}
+
+// WithIndexChecksums makes a new store end each index entry with a CRC32, failing
+// damaged entries with ErrIndexCorrupt. Off by default.
+func WithIndexChecksums() Option {
+	return func(o *options) {
+		o.indexChecksums = true
+	}
+}
+
+// WithWriteVerify reads every appended value back and fails with ErrWriteVerify if it
+// differs; the line stays in the store. Off by default.
+func WithWriteVerify() Option {
+	return func(o *options) {
+		o.writeVerify = true
+	}
+}
+
+// WithSyncErrorHandler calls fn for every failed fsync. fn runs with the store's lock
+// held, so it must not call the store's methods. There is no handler by default.
+func WithSyncErrorHandler(fn func(error)) Option {
+	return func(o *options) {
+		o.onSyncError = fn
+	}
+}
+
+// WithReadOnlyOnSyncError rejects writes with ErrReadOnly after the first failed fsync.
+// Off by default.
+func WithReadOnlyOnSyncError() Option {
+	return func(o *options) {
+		o.syncErrorStop = true
+	}
+}
@@ -0,0 +1,879 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithFileMode(0600), WithSyncMode(SyncNone))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for _, p := range []string{path, path + ".idx"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("stat %s failed: %v", p, err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("expected %s to have mode 0600, got %v", p, info.Mode().Perm())
+		}
+	}
+
+	line, err := store.Set([]byte("value1"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	err = store.Flush()
+	if err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	value, err := store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "value1" {
+		t.Errorf("expected 'value1', got '%s'", value)
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithMaxValueSize(2<<20))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	large := make([]byte, 1<<20+1)
+	line, err := store.Set(large)
+	if err != nil {
+		t.Fatalf("set of value above the default limit failed: %v", err)
+	}
+	value, err := store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if len(value) != len(large) {
+		t.Errorf("expected %d bytes, got %d", len(large), len(value))
+	}
+
+	_, err = store.Set(make([]byte, 2<<20+1))
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	store.Close()
+
+	// Reading back with the default limit rejects the stored value
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.Get(line)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge on read, got %v", err)
+	}
+}
+
+func TestMaxStoreSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Room for the header and three 24-byte records
+	store, err := NewStore(path, WithMaxStoreSize(headerSize+3*24))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value2"), []byte("value3")})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded for the batch, got %v", err)
+	}
+	_, err = store.Set([]byte("value2"))
+	if err != nil {
+		t.Fatalf("set within the limit failed: %v", err)
+	}
+	_, err = store.Set([]byte("value3"))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	_, err = store.Update(0, []byte("value0"))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded for the update, got %v", err)
+	}
+	size, err := store.FileSize()
+	if err != nil || size != headerSize+3*24 || store.Count() != 3 {
+		t.Errorf("expected nothing written past the limit, got %d bytes and %d lines, %v", size, store.Count(), err)
+	}
+	err = store.Delete(0)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	store.Close()
+
+	// A ring buffer compacts its evicted records to make room
+	os.Remove(path)
+	os.Remove(path + ".idx")
+	store, err = NewStore(path, WithMaxStoreSize(headerSize+3*24), WithMaxRecords(2))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 5; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set %d failed: %v", i, err)
+		}
+	}
+	value, err := store.Get(4)
+	if err != nil || string(value) != "value4" {
+		t.Errorf("expected value4, got %q, %v", value, err)
+	}
+}
+
+func TestPeriodicSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithPeriodicSync(time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if store.opts.syncMode != SyncNone || store.periodic == nil {
+		t.Fatal("expected inline syncs to be skipped and a background sync to run")
+	}
+	for i := 0; i < 20; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	select {
+	case <-store.periodic.done:
+	default:
+		t.Error("expected Close to stop the background sync")
+	}
+	err = store.Close()
+	if err != nil {
+		t.Errorf("second close failed: %v", err)
+	}
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != 20 {
+		t.Errorf("expected 20 lines, got %d", store.Count())
+	}
+}
+
+func TestAutoBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	dir := filepath.Join(t.TempDir(), "backups")
+
+	store, err := NewStore(path, WithAutoBackup(dir, time.Millisecond, 2))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if !store.LastBackup().IsZero() {
+		t.Error("expected no backup yet")
+	}
+	for i := 0; i < 10; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for store.LastBackup().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if store.LastBackup().IsZero() {
+		t.Fatal("expected a background backup")
+	}
+	for i := 10; i < 20; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	// Close took a final backup, and only the newest two are kept
+	matches, err := filepath.Glob(filepath.Join(dir, "test.db-*.backup"))
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("expected 2 backups, got %v, %v", matches, err)
+	}
+	backup, err := NewStore(matches[1])
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer backup.Close()
+	if backup.Count() != 20 {
+		t.Errorf("expected 20 lines in the final backup, got %d", backup.Count())
+	}
+}
+
+func TestAutoBackupSharedDir(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "backups")
+
+	// Two stores whose names share a prefix keep their backups side by side
+	for _, name := range []string{"events-archive", "events-archive", "events"} {
+		store, err := NewStore(filepath.Join(base, name), WithAutoBackup(dir, time.Hour, 2))
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		err = store.Close()
+		if err != nil {
+			t.Fatalf("close failed: %v", err)
+		}
+	}
+
+	archives, err := filepath.Glob(filepath.Join(dir, "events-archive-*.backup"))
+	if err != nil || len(archives) != 2 {
+		t.Errorf("expected 2 backups of events-archive, got %v, %v", archives, err)
+	}
+	all, err := filepath.Glob(filepath.Join(dir, "events-*.backup"))
+	if err != nil || len(all) != 3 {
+		t.Errorf("expected the backup of events to be kept, got %v, %v", all, err)
+	}
+}
+
+func TestMemoryIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithMemoryIndex())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Set([]byte("value2"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	_, err = store.Update(0, []byte("updated0"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(path, WithMemoryIndex())
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	for line, want := range []string{"updated0", "value1", "value2"} {
+		value, err := store.Get(uint64(line))
+		if err != nil {
+			t.Fatalf("get line %d failed: %v", line, err)
+		}
+		if string(value) != want {
+			t.Errorf("expected '%s', got '%s'", want, value)
+		}
+	}
+
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	value, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("get after polish failed: %v", err)
+	}
+	if string(value) != "value2" {
+		t.Errorf("expected 'value2' after polish, got '%s'", value)
+	}
+}
+
+// benchmarkGet reads lines stride apart, wrapping around, so a stride above 1 reads
+// them out of order.
+func benchmarkGet(b *testing.B, stride int, opts ...Option) {
+	dir := b.TempDir()
+	path := filepath.Join(dir, "bench.db")
+
+	store, err := NewStore(path, opts...)
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	values := make([][]byte, 10000)
+	for i := range values {
+		values[i] = []byte("benchmark value")
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		b.Fatalf("set batch failed: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = store.Get(uint64(i * stride % len(values)))
+		if err != nil {
+			b.Fatalf("get failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	benchmarkGet(b, 1)
+}
+
+func BenchmarkGetMemoryIndex(b *testing.B) {
+	benchmarkGet(b, 1, WithMemoryIndex())
+}
+
+func BenchmarkGetRandom(b *testing.B) {
+	benchmarkGet(b, 7919)
+}
+
+// BenchmarkGetSparse reads consecutive lines, each continuing from the previous one
+func BenchmarkGetSparse(b *testing.B) {
+	benchmarkGet(b, 1, WithSparseIndex(64))
+}
+
+// BenchmarkGetSparseRandom reads lines out of order, reading forward from the index
+// entry every time
+func BenchmarkGetSparseRandom(b *testing.B) {
+	benchmarkGet(b, 7919, WithSparseIndex(64))
+}
+
+func benchmarkList(b *testing.B, readahead int) {
+	dir := b.TempDir()
+	path := filepath.Join(dir, "bench.db")
+	defer func(size int) { readaheadSize = size }(readaheadSize)
+	readaheadSize = readahead
+
+	store, err := NewStore(path)
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	values := make([][]byte, 100000)
+	for i := range values {
+		values[i] = []byte("benchmark value")
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		b.Fatalf("set batch failed: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = store.List()
+		if err != nil {
+			b.Fatalf("list failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	benchmarkList(b, readaheadSize)
+}
+
+// BenchmarkListUnbuffered reads every record with its own reads, for comparison
+func BenchmarkListUnbuffered(b *testing.B) {
+	benchmarkList(b, 0)
+}
+
+func TestCommitInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithCommitInterval(time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	lines := make([]uint64, 20)
+	for i := range lines {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			line, err := store.Set([]byte("value"))
+			if err != nil {
+				t.Errorf("set failed: %v", err)
+			}
+			lines[i] = line
+		}(i)
+	}
+	wg.Wait()
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != uint64(len(lines)) {
+		t.Errorf("expected %d lines, got %d", len(lines), store.Count())
+	}
+	seen := make(map[uint64]bool)
+	for _, line := range lines {
+		if seen[line] {
+			t.Errorf("line %d returned twice", line)
+		}
+		seen[line] = true
+	}
+}
+
+func TestCommitIntervalPolish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path, WithCommitInterval(time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Batch leaders sync while Polish swaps the files out
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, err := store.Set([]byte("value"))
+				if err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+		if err != nil {
+			t.Fatalf("polish failed: %v", err)
+		}
+	}
+	wg.Wait()
+	if store.Count() != 160 {
+		t.Errorf("expected 160 lines, got %d", store.Count())
+	}
+}
+
+// recordingLogger keeps every message it is given.
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...any) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	store.Close()
+
+	// Leave a partial record for recovery to discard
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("open data file failed: %v", err)
+	}
+	f.Write([]byte{recordActive, 0, 0})
+	f.Close()
+
+	logger := &recordingLogger{}
+	store, err = NewStore(path, WithRecovery(), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("failed to recover store: %v", err)
+	}
+	defer store.Close()
+	if len(logger.messages) != 1 || !strings.Contains(logger.messages[0], "discarded_data_bytes=3") {
+		t.Errorf("expected one recovery message, got %q", logger.messages)
+	}
+
+	logger.messages = nil
+	err = store.Delete(0)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if len(logger.messages) != 2 || !strings.HasPrefix(logger.messages[0], "linestore: compaction started") ||
+		!strings.Contains(logger.messages[1], "reclaimed_bytes=24") {
+		t.Errorf("expected compaction start and finish messages, got %q", logger.messages)
+	}
+}
+
+func TestAutoPolish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithAutoPolish(0.4))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 10; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	for i := uint64(0); i < 5; i++ {
+		err = store.Delete(i)
+		if err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+	}
+
+	// The compaction runs in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err := store.Stats()
+		if err != nil {
+			t.Fatalf("stats failed: %v", err)
+		}
+		if st.DeadBytes < 5*24 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a background compaction, dead bytes still %d", st.DeadBytes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	value, err := store.Get(9)
+	if err != nil || string(value) != "value9" {
+		t.Errorf("expected line numbers to be kept, got %q, %v", value, err)
+	}
+}
+
+func benchmarkSetParallel(b *testing.B, opts ...Option) {
+	dir := b.TempDir()
+	path := filepath.Join(dir, "bench.db")
+
+	store, err := NewStore(path, opts...)
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	value := []byte("benchmark value")
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := store.Set(value)
+			if err != nil {
+				b.Errorf("set failed: %v", err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	benchmarkSetParallel(b)
+}
+
+func BenchmarkSetParallelGroupCommit(b *testing.B) {
+	benchmarkSetParallel(b, WithCommitInterval(200*time.Microsecond))
+}
+
+func TestIndexPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	indexPath := filepath.Join(t.TempDir(), "test.idx")
+
+	store, err := NewStore(path, WithIndexPath(indexPath))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	_, err = os.Stat(path + ".idx")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no index next to the data file, got %v", err)
+	}
+	info, err := os.Stat(indexPath)
+	if err != nil || info.Size() != 2*16 {
+		t.Errorf("expected the polished index at %s, got %v, %v", indexPath, info, err)
+	}
+
+	// The backup Polish made is a store of its own
+	backup, err := NewStore(path + ".backup")
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	if backup.Count() != 3 {
+		t.Errorf("expected 3 lines in the backup, got %d", backup.Count())
+	}
+	backup.Close()
+	store.Close()
+
+	store, err = NewStore(path, WithIndexPath(indexPath))
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	value, err := store.Get(1)
+	if err != nil || string(value) != "value2" {
+		t.Errorf("expected value2 at line 1, got %s, %v", value, err)
+	}
+}
+
+func TestSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithSingleFile())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(2, []byte("updated2"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = os.Stat(path + ".idx")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no index file, got %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(path, WithSingleFile())
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	if store.Count() != 4 {
+		t.Errorf("expected 4 lines after reopening, got %d", store.Count())
+	}
+	value, err := store.Get(2)
+	if err != nil || string(value) != "updated2" {
+		t.Errorf("expected updated2 at line 2, got %s, %v", value, err)
+	}
+	_, err = store.Get(1)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted at line 1, got %v", err)
+	}
+
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	value, err = store.Get(1)
+	if err != nil || string(value) != "updated2" {
+		t.Errorf("expected updated2 at line 1 after polish, got %s, %v", value, err)
+	}
+	_, err = os.Stat(path + ".idx")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no index file after polish, got %v", err)
+	}
+	store.Close()
+
+	// A torn final record is only dropped with WithRecovery
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open data file: %v", err)
+	}
+	file.Write([]byte{recordActive, 0, 0})
+	file.Close()
+	_, err = NewStore(path, WithSingleFile())
+	if err == nil {
+		t.Error("expected an error for a torn record without WithRecovery")
+	}
+	store, err = NewStore(path, WithSingleFile(), WithRecovery())
+	if err != nil {
+		t.Fatalf("failed to recover store: %v", err)
+	}
+	if store.Count() != 3 {
+		t.Errorf("expected 3 lines after recovery, got %d", store.Count())
+	}
+	value, err = store.Get(2)
+	if err != nil || string(value) != "value3" {
+		t.Errorf("expected value3 at line 2, got %s, %v", value, err)
+	}
+	store.Close()
+
+	_, err = NewStore(path, WithSingleFile(), WithDedup())
+	if err == nil {
+		t.Error("expected WithDedup to be rejected")
+	}
+}
+
+func TestCompactIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithCompactIndex())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Set([]byte("value4"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	_, err = store.Update(1, []byte("updated1"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(3)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	size, err := store.IndexSize()
+	if err != nil || size != 5*8 {
+		t.Errorf("expected a 40-byte index, got %d, %v", size, err)
+	}
+	report, err := store.Verify()
+	if err != nil || !report.OK() || report.IndexEntries != 5 {
+		t.Errorf("expected a healthy compact index, got %+v, %v", report, err)
+	}
+	store.Close()
+
+	// The layout is kept in the file, so the option isn't needed again
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	pairs, err := store.ListAllReverse()
+	if err != nil || len(pairs) != 4 {
+		t.Fatalf("expected 4 live lines, got %v, %v", pairs, err)
+	}
+	if pairs[0][0].(uint64) != 4 || pairs[2][0].(uint64) != 1 || string(pairs[2][1].([]byte)) != "updated1" {
+		t.Errorf("unexpected reverse listing %v", pairs)
+	}
+
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	size, err = store.IndexSize()
+	if err != nil || size != 4*8 {
+		t.Errorf("expected a 32-byte index after polish, got %d, %v", size, err)
+	}
+	value, err := store.Get(3)
+	if err != nil || string(value) != "value4" {
+		t.Errorf("expected value4 at line 3 after polish, got %s, %v", value, err)
+	}
+}
+
+func TestLargeValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithLargeValues())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	// Larger than DefaultMaxValueSize, which large values lift
+	large := bytes.Repeat([]byte("x"), int(DefaultMaxValueSize)+1)
+	_, err = store.Set([]byte("small"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	_, err = store.Set(large)
+	if err != nil {
+		t.Fatalf("set of a large value failed: %v", err)
+	}
+	_, err = store.Update(0, []byte("updated"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	value, err := store.Get(1)
+	if err != nil || !bytes.Equal(value, large) {
+		t.Errorf("expected the large value at line 1, got %d bytes, %v", len(value), err)
+	}
+	_, written, err := store.GetWithTime(0)
+	if err != nil || written.IsZero() {
+		t.Errorf("expected a write time at line 0, got %v, %v", written, err)
+	}
+	report, err := store.Verify()
+	if err != nil || !report.OK() || report.Records != 3 {
+		t.Errorf("expected 3 healthy records, got %+v, %v", report, err)
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	pairs, err := store.List()
+	if err != nil || len(pairs) != 2 || string(pairs[0][1].([]byte)) != "updated" || !bytes.Equal(pairs[1][1].([]byte), large) {
+		t.Errorf("unexpected listing after polish: %d pairs, %v", len(pairs), err)
+	}
+	store.Close()
+
+	// An explicit limit still applies
+	store, err = NewStore(path, WithMaxValueSize(16))
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	_, err = store.Set(bytes.Repeat([]byte("x"), 17))
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	store.Close()
+
+	os.Remove(path)
+	os.Remove(path + ".idx")
+	_, err = NewStore(path, WithLargeValues(), WithCompression(CompressionGzip))
+	if err == nil {
+		t.Error("expected compression to be rejected")
+	}
+}
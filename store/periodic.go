@@ -0,0 +1,52 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// periodicSync fsyncs a store's files in the background, for WithPeriodicSync.
+type periodicSync struct {
+	once sync.Once
+	stop chan struct{} // Closed to stop the goroutine
+	done chan struct{} // Closed once the goroutine has returned
+}
+
+// startPeriodicSync starts a goroutine that fsyncs both files every interval until
+// stopPeriodicSync is called. Failures are reported to the logger by syncFile.
+func (s *Store) startPeriodicSync(interval time.Duration) {
+	p := &periodicSync{stop: make(chan struct{}), done: make(chan struct{})}
+	s.periodic = p
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+			// The read lock keeps Polish from swapping the files out mid-sync
+			s.mu.RLock()
+			if !s.closed {
+				s.syncFile(s.file)
+				s.syncFile(s.indexFile)
+			}
+			s.mu.RUnlock()
+		}
+	}()
+}
+
+// stopPeriodicSync stops the background fsync goroutine, if any, and waits for it to
+// return. It must be called without holding the lock.
+func (s *Store) stopPeriodicSync() {
+	p := s.periodic
+	if p == nil {
+		return
+	}
+	p.once.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+}
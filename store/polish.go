@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// polishQueueLen is how many records Polish reads ahead of the records it writes. It
+// bounds the memory a compaction holds, at most this many values, whatever the store's
+// size. With 0 the records are read and written in turn on one goroutine.
+var polishQueueLen = 64
+
+// polishRecord is one line read from the store for Polish.
+type polishRecord struct {
+	line       uint64 // Line number in the store being polished
+	origOffset uint64 // Offset of the record in the store's data file
+	typeByte   byte
+	payload    []byte // Value as stored, not decompressed
+	written    int64  // Write time in Unix nanoseconds
+	userFlags  byte
+	err        error // Set when the line could not be read; ends the compaction
+}
+
+// polishWriter writes the records of a compaction to the temp files.
+type polishWriter struct {
+	format    format
+	data      backend
+	index     backend
+	every     uint64            // Lines per index entry, more than 1 for a sparse index
+	keepLines bool              // Deleted lines stay as tombstones and lines keep their numbers
+	dataEnd   int64             // Size of the polished data file so far
+	newLine   uint64            // Number of index entries written so far
+	offsets   []uint64          // Offsets of the polished records, only with WithMemoryIndex
+	copied    map[uint64]uint64 // New offsets of records copied so far, when lines share records
+}
+
+// readPolishRecord reads line for Polish.
+func (s *Store) readPolishRecord(ctx context.Context, line uint64) polishRecord {
+	r := polishRecord{line: line}
+	r.err = ctx.Err()
+	if r.err != nil {
+		return r
+	}
+	r.origOffset, r.err = s.readIndexOffset(line)
+	if r.err != nil {
+		return r
+	}
+	r.typeByte, r.payload, r.err = s.readPayload(int64(r.origOffset), line, false)
+	if r.err != nil {
+		return r
+	}
+	r.written, r.err = s.readWriteTime(int64(r.origOffset), line)
+	if r.err != nil {
+		return r
+	}
+	r.userFlags, r.err = s.readFlags(int64(r.origOffset), line)
+	return r
+}
+
+// copyLines copies the kept lines to w in order. A reader goroutine reads up to
+// polishQueueLen records ahead while the calling goroutine writes them, so reading the
+// old files and writing the new ones overlap. The reader has stopped by the time
+// copyLines returns. The caller must hold the write lock.
+func (s *Store) copyLines(ctx context.Context, w *polishWriter, progress func(done, total uint64)) error {
+	first, total := s.evictLine, s.lineCount-s.evictLine
+	add := func(r polishRecord) error {
+		if r.err != nil {
+			return r.err
+		}
+		// Checked again here so a canceled polish doesn't write the records read ahead
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(r.line-first+1, total)
+		}
+		return w.add(r)
+	}
+
+	if polishQueueLen == 0 {
+		for line := first; line < s.lineCount; line++ {
+			err := add(s.readPolishRecord(ctx, line))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	records := make(chan polishRecord, polishQueueLen)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(records)
+		for line := first; line < s.lineCount; line++ {
+			r := s.readPolishRecord(ctx, line)
+			select {
+			case records <- r:
+			case <-stop:
+				return
+			}
+			if r.err != nil {
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(stop)
+
+	for r := range records {
+		err := add(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add writes r to the polished files, unless it is a deleted line being dropped.
+func (w *polishWriter) add(r polishRecord) error {
+	// Values are copied as stored, without decompressing them, and keep their write
+	// time and user flags
+	record := w.format.encodeRecord(recordActive, 0, r.userFlags, r.written, r.payload)
+	line := w.newLine
+	if r.typeByte&recordDeleted != 0 {
+		if !w.keepLines {
+			// Deleted records are dropped from the polished file
+			return nil
+		}
+		// Reserved lines stay pending
+		record = w.format.encodeRecord(r.typeByte&(recordDeleted|recordPending), 0, 0, r.written, nil)
+	}
+	if w.keepLines {
+		line = r.line
+	}
+	recordOffset, shared := w.copied[r.origOffset]
+	if !shared {
+		// Each record is copied once, however many lines point at it
+		recordOffset = uint64(w.dataEnd)
+		_, err := w.data.WriteAt(record, w.dataEnd)
+		if err != nil {
+			return fmt.Errorf("failed to write polished record: %v", err)
+		}
+		w.dataEnd += int64(len(record))
+		if w.copied != nil && r.typeByte&recordDeleted == 0 {
+			w.copied[r.origOffset] = recordOffset
+		}
+	}
+
+	if w.newLine%w.every == 0 {
+		_, err := w.index.WriteAt(w.format.encodeIndexEntry(line, recordOffset), int64(w.newLine/w.every)*w.format.indexEntrySize())
+		if err != nil {
+			return fmt.Errorf("failed to write polished index entry: %v", err)
+		}
+	}
+	if w.offsets != nil {
+		w.offsets = append(w.offsets, recordOffset)
+	}
+	w.newLine++
+	return nil
+}
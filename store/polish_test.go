@@ -0,0 +1,209 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// polishLines fills a store at path with lines values, deletes every third one, and
+// polishes it, returning the polished lines.
+func polishLines(t *testing.T, path string, lines int) [][2]interface{} {
+	os.Remove(path)
+	os.Remove(path + ".idx")
+
+	store, err := NewStore(path, WithSyncMode(SyncNone))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	values := make([][]byte, lines)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("value%d", i))
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	for line := uint64(0); line < uint64(lines); line += 3 {
+		err = store.Delete(line)
+		if err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+	}
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if store.Count() != uint64(lines-(lines+2)/3) {
+		t.Fatalf("expected %d lines after polish, got %d", lines-(lines+2)/3, store.Count())
+	}
+	value, err := store.Get(store.Count() - 1)
+	if err != nil || string(value) != fmt.Sprintf("value%d", lines-1) {
+		t.Fatalf("expected the last value to keep its place, got %q, %v", value, err)
+	}
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	return list
+}
+
+func TestPolishPipeline(t *testing.T) {
+	dir := t.TempDir()
+	lines := 10*polishQueueLen + 1
+	pipelined := polishLines(t, filepath.Join(dir, "test.db"), lines)
+
+	defer func(n int) { polishQueueLen = n }(polishQueueLen)
+	polishQueueLen = 0
+	sequential := polishLines(t, filepath.Join(dir, "test.db"), lines)
+	if !reflect.DeepEqual(pipelined, sequential) {
+		t.Error("expected the pipeline to keep the same lines as a sequential polish")
+	}
+}
+
+func TestPolishPipelineCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	values := make([][]byte, 4*polishQueueLen)
+	for i := range values {
+		values[i] = []byte("value")
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+
+	// Cancel from the writer's side while the reader is ahead of it
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := PolishOptions{SkipBackup: true, Progress: func(done, total uint64) {
+		if done == 2 {
+			cancel()
+		}
+	}}
+	err = store.polish(ctx, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if store.Count() != uint64(len(values)) {
+		t.Errorf("expected the store to be left as it was, got %d lines", store.Count())
+	}
+}
+
+func TestPolishConcurrentGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	for _, opts := range [][]Option{nil, {WithMemoryIndex()}, {WithMmap()}} {
+		os.Remove(path)
+		os.Remove(path + ".idx")
+		store, err := NewStore(path, opts...)
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		values := make([][]byte, 200)
+		for i := range values {
+			values[i] = []byte(fmt.Sprintf("value%d", i))
+		}
+		_, err = store.SetBatch(values)
+		if err != nil {
+			t.Fatalf("set batch failed: %v", err)
+		}
+
+		// Readers check every value while lines are deleted and the store compacted
+		stop := make(chan struct{})
+		errs := make(chan error, 4)
+		var wg sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func(r int) {
+				defer wg.Done()
+				for i := r; ; i += 7 {
+					select {
+					case <-stop:
+						errs <- nil
+						return
+					default:
+					}
+					line := uint64(i % len(values))
+					value, err := store.Get(line)
+					if errors.Is(err, ErrDeleted) {
+						continue
+					}
+					if err != nil || string(value) != string(values[line]) {
+						errs <- fmt.Errorf("line %d: expected %s, got %q, %v", line, values[line], value, err)
+						return
+					}
+				}
+			}(r)
+		}
+		for i := 0; i < 20; i++ {
+			err = store.Delete(uint64(i * 9))
+			if err != nil {
+				t.Fatalf("delete failed: %v", err)
+			}
+			err = store.Compact()
+			if err != nil {
+				t.Fatalf("compact failed: %v", err)
+			}
+		}
+		close(stop)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		store.Close()
+	}
+}
+
+func benchmarkPolish(b *testing.B, queueLen int) {
+	dir := b.TempDir()
+	path := filepath.Join(dir, "bench.db")
+
+	defer func(n int) { polishQueueLen = n }(polishQueueLen)
+	polishQueueLen = queueLen
+
+	store, err := NewStore(path, WithSyncMode(SyncNone))
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	values := make([][]byte, 20000)
+	for i := range values {
+		values[i] = bytes.Repeat([]byte{byte(i)}, 512)
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		b.Fatalf("set batch failed: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+		if err != nil {
+			b.Fatalf("polish failed: %v", err)
+		}
+	}
+	b.SetBytes(int64(len(values) * 512))
+}
+
+func BenchmarkPolish(b *testing.B) {
+	benchmarkPolish(b, polishQueueLen)
+}
+
+func BenchmarkPolishSequential(b *testing.B) {
+	benchmarkPolish(b, 0)
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package store
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, which reserves blocks past the end of the file
+// without changing its size.
+const fallocKeepSize = 0x1
+
+// preallocateFile reserves disk blocks for f up to size bytes. Filesystems without
+// fallocate support are silently skipped.
+func preallocateFile(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package store
+
+import "os"
+
+// preallocateFile is a no-op on platforms without fallocate.
+func preallocateFile(f *os.File, size int64) error {
+	return nil
+}
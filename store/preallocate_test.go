@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	err = store.Preallocate(1000, 16)
+	if err != nil {
+		t.Fatalf("preallocate failed: %v", err)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if stat.Size() != headerSize {
+		t.Errorf("expected preallocation to keep the size at %d, got %d", headerSize, stat.Size())
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	store.Close()
+
+	stat, err = os.Stat(path + ".idx")
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if stat.Size() != 10*16 {
+		t.Errorf("expected index of %d bytes, got %d", 10*16, stat.Size())
+	}
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	value, err := store.Get(9)
+	if err != nil || string(value) != "value9" {
+		t.Errorf("expected value9, got %s, %v", value, err)
+	}
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// quotaLeft returns how many bytes the data file may still grow before it reaches the
+// WithMaxStoreSize limit, which may be negative if the store was opened over the
+// limit.
+func (s *Store) quotaLeft() (int64, error) {
+	if s.opts.maxStoreSize <= 0 {
+		return math.MaxInt64, nil
+	}
+	size, err := s.dataSize()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat data file: %v", err)
+	}
+	return s.opts.maxStoreSize - size, nil
+}
+
+// checkQuota returns an error wrapping ErrQuotaExceeded if appending n bytes to the
+// data file would grow it past the WithMaxStoreSize limit. A ring buffer whose files
+// still hold evicted records compacts them away first to make room. The caller must
+// hold the write lock.
+func (s *Store) checkQuota(n int64) error {
+	left, err := s.quotaLeft()
+	if err != nil {
+		return err
+	}
+	if n > left && s.opts.maxRecords > 0 && s.evictLine > s.baseLine {
+		err = s.polishLocked(context.Background(), PolishOptions{SkipBackup: true})
+		if err != nil {
+			return fmt.Errorf("failed to compact evicted lines: %v", err)
+		}
+		left, err = s.quotaLeft()
+		if err != nil {
+			return err
+		}
+	}
+	if n > left {
+		return quotaError(n, left)
+	}
+	return nil
+}
+
+// quotaError reports that n bytes don't fit in the left bytes of the store's quota.
+func quotaError(n, left int64) error {
+	return fmt.Errorf("%d bytes exceed the %d bytes left of the store size limit: %w", n, max(left, 0), ErrQuotaExceeded)
+}
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// RawRecord is a physical record of the data file, as seen by RawIter.
+type RawRecord struct {
+	Offset int64  // Position of the record in the data file, segment-encoded in segmented stores
+	Type   byte   // Record type bits: 1 for deleted, 2 for a value written by Update
+	Length uint32 // Length of the value as stored, capped at math.MaxUint32; len(Value) is exact
+	Value  []byte // Value as stored: still compressed or encrypted, and its checksum unchecked
+}
+
+// RawIter walks every physical record of a store's data file in file order, including
+// deleted records and values superseded by Update. Unlike Iter it holds the store's read
+// lock from RawRecords until the iteration ends or Close is called, so the offsets it
+// reports stay valid; writers block meanwhile, and writing to the store from the same
+// goroutine before closing the iterator deadlocks.
+//
+// RawIter is a low-level API for repair, migration, and forensic tools. The records it
+// yields follow the file format, which may change between releases.
+type RawIter struct {
+	store    *Store
+	ranges   [][2]int64 // Record ranges of the data file, one per segment
+	next     int64      // Offset of the next record
+	record   RawRecord
+	err      error
+	released bool
+}
+
+// RawRecords returns an iterator over the physical records of the data file. The
+// iterator must be closed, or run to the end, to release the read lock.
+func (s *Store) RawRecords() *RawIter {
+	s.mu.RLock()
+	it := &RawIter{store: s}
+	if s.closed {
+		it.err = ErrClosed
+		it.release()
+		return it
+	}
+	ranges, err := s.dataRanges()
+	if err != nil {
+		it.err = fmt.Errorf("failed to stat data file: %v", err)
+		it.release()
+		return it
+	}
+	it.ranges = ranges
+	if len(ranges) > 0 {
+		it.next = ranges[0][0]
+	}
+	return it
+}
+
+// Next advances to the next record. It returns false when the iteration is finished,
+// the iterator was closed, or an error occurred (check Err).
+func (it *RawIter) Next() bool {
+	if it.released {
+		return false
+	}
+	for len(it.ranges) > 0 && it.next >= it.ranges[0][1] {
+		it.ranges = it.ranges[1:]
+		if len(it.ranges) > 0 {
+			it.next = it.ranges[0][0]
+		}
+	}
+	if len(it.ranges) == 0 {
+		it.record = RawRecord{}
+		it.release()
+		return false
+	}
+
+	s := it.store
+	info, next, ok := s.scanRecord(it.next, it.ranges[0][1])
+	if !ok {
+		it.fail(fmt.Errorf("unreadable record at offset %d: %w", it.next, ErrInvalidRecord))
+		return false
+	}
+	value := make([]byte, info.valLen)
+	_, err := s.file.ReadAt(value, next-s.format.trailerLen()-info.valLen)
+	if err != nil {
+		it.fail(fmt.Errorf("failed to read record at offset %d: %v", it.next, err))
+		return false
+	}
+	s.countRead(int(next - it.next))
+
+	it.record = RawRecord{Offset: it.next, Type: info.typeByte, Length: uint32(min(info.valLen, math.MaxUint32)), Value: value}
+	it.next = next
+	return true
+}
+
+// Record returns the current record.
+func (it *RawIter) Record() RawRecord {
+	return it.record
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *RawIter) Err() error {
+	return it.err
+}
+
+// Close stops the iteration and releases the read lock.
+func (it *RawIter) Close() error {
+	it.record = RawRecord{}
+	it.release()
+	return nil
+}
+
+// fail ends the iteration with err.
+func (it *RawIter) fail(err error) {
+	it.err = err
+	it.record = RawRecord{}
+	it.release()
+}
+
+// release gives up the read lock, once.
+func (it *RawIter) release() {
+	if !it.released {
+		it.released = true
+		it.store.mu.RUnlock()
+	}
+}
+
+// GetRaw returns the record line currently maps to exactly as it is stored: the type
+// byte, the rest of the prefix, the value still compressed or encrypted, and the
+// checksum if the store has them. Deleted lines return their tombstone. Records no line
+// maps to, such as values superseded by Update, are only visible through RawRecords,
+// so copying a data file byte for byte takes RawRecords rather than GetRaw.
+func (s *Store) GetRaw(line uint64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if line >= s.lineCount {
+		return nil, fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
+	}
+	dataOffset, err := s.readIndexOffset(line)
+	if err != nil {
+		return nil, err
+	}
+	size, err := s.recordSize(int64(dataOffset), line)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, size)
+	_, err = s.file.ReadAt(record, int64(dataOffset))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record at line %d: %v", line, err)
+	}
+	return record, nil
+}
+
+// AppendRaw appends a record returned by GetRaw as the next line, without decoding or
+// re-encoding its value, so a follower can replicate a leader's records and keep their
+// checksums and compression exactly. The record must follow this store's format: the
+// same format version, checksums, compression codec, and encryption key as the store it
+// came from, since its value is stored as is. AppendRaw checks the framing, the value
+// length, and the checksum, and rejects anything malformed with ErrInvalidRecord. An
+// update record, returned by GetRaw for an updated line, is appended as a plain record
+// holding the same value, since a new line can't replace one. Appended records are not
+// deduplicated.
+func (s *Store) AppendRaw(record []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+	err = s.checkRawRecord(record)
+	if err != nil {
+		return 0, err
+	}
+	if record[0]&recordUpdate != 0 {
+		// Drop the replaced line; the rest of the prefix moves up unchanged
+		plain := make([]byte, len(record)-8)
+		plain[0] = record[0] &^ recordUpdate
+		copy(plain[1:], record[9:])
+		record = plain
+	}
+	err = s.checkQuota(int64(len(record)))
+	if err != nil {
+		return 0, err
+	}
+
+	dataOffset, err := s.appendOffset()
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.file.WriteAt(record, dataOffset)
+	if err != nil {
+		s.rollback(dataOffset, s.indexPos(s.lineCount))
+		return 0, writeError("failed to write record", err)
+	}
+	s.countWritten(len(record))
+	line, err := s.commitRecord(dataOffset, dataOffset, true)
+	if err == nil && record[0]&recordDeleted != 0 {
+		s.addDead(int64(len(record)))
+	}
+	return line, err
+}
+
+// checkRawRecord checks that record is a single well-formed record of the store's format.
+func (s *Store) checkRawRecord(record []byte) error {
+	if len(record) == 0 {
+		return fmt.Errorf("empty record: %w", ErrInvalidRecord)
+	}
+	typeByte := record[0]
+	if !validRecordType(typeByte) {
+		return fmt.Errorf("invalid record type %d: %w", typeByte, ErrInvalidRecord)
+	}
+	prefixLen := s.format.prefixLen(typeByte)
+	if int64(len(record)) < prefixLen+s.format.trailerLen() {
+		return fmt.Errorf("record of %d bytes is shorter than its prefix: %w", len(record), ErrInvalidRecord)
+	}
+	valLen := s.format.valueLen(record)
+	if valLen < 0 || uint64(valLen) > s.payloadLimit() {
+		return fmt.Errorf("invalid value length %d: %w: %w", valLen, ErrInvalidRecord, ErrValueTooLarge)
+	}
+	if size := prefixLen + valLen + s.format.trailerLen(); int64(len(record)) != size {
+		return fmt.Errorf("record of %d bytes does not match its value length %d: %w", len(record), valLen, ErrInvalidRecord)
+	}
+	if s.format.checksums() {
+		value := record[prefixLen : prefixLen+valLen]
+		if crc32.ChecksumIEEE(value) != binary.LittleEndian.Uint32(record[prefixLen+valLen:]) {
+			return fmt.Errorf("record: %w: %w", ErrInvalidRecord, ErrChecksumMismatch)
+		}
+	}
+	return nil
+}
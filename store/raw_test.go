@@ -0,0 +1,202 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRawRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(0, []byte("updated"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	expected := []RawRecord{
+		{Type: recordActive, Value: []byte("value0")},
+		{Type: recordDeleted, Value: []byte("value1")},
+		{Type: recordActive, Value: []byte("value2")},
+		{Type: recordUpdate, Value: []byte("updated")},
+	}
+	it := store.RawRecords()
+	offset := int64(headerSize)
+	i := 0
+	for it.Next() {
+		r := it.Record()
+		if i >= len(expected) {
+			t.Fatalf("unexpected record %+v", r)
+		}
+		if r.Offset != offset || r.Type != expected[i].Type || string(r.Value) != string(expected[i].Value) ||
+			int(r.Length) != len(r.Value) {
+			t.Errorf("record %d: expected %q of type %d at %d, got %+v", i, expected[i].Value, expected[i].Type, offset, r)
+		}
+		offset += store.format.prefixLen(r.Type) + int64(r.Length) + store.format.trailerLen()
+		i++
+	}
+	if it.Err() != nil {
+		t.Fatalf("iteration failed: %v", it.Err())
+	}
+	if i != len(expected) {
+		t.Errorf("expected %d records, got %d", len(expected), i)
+	}
+
+	// The read lock is released once the iteration ends or the iterator is closed
+	_, err = store.Set([]byte("value3"))
+	if err != nil {
+		t.Fatalf("set after iteration failed: %v", err)
+	}
+	it = store.RawRecords()
+	if !it.Next() {
+		t.Fatalf("expected a record, got %v", it.Err())
+	}
+	it.Close()
+	if it.Next() {
+		t.Error("expected no records after close")
+	}
+	err = store.Delete(3)
+	if err != nil {
+		t.Fatalf("delete after close failed: %v", err)
+	}
+
+	store.Close()
+	it = store.RawRecords()
+	if it.Next() || !errors.Is(it.Err(), ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", it.Err())
+	}
+}
+
+func TestGetRaw(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	// The raw records are the data file's bytes after the header
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read data file: %v", err)
+	}
+	first, err := store.GetRaw(0)
+	if err != nil {
+		t.Fatalf("get raw failed: %v", err)
+	}
+	second, err := store.GetRaw(1)
+	if err != nil {
+		t.Fatalf("get raw failed: %v", err)
+	}
+	if string(data[headerSize:]) != string(first)+string(second) {
+		t.Errorf("expected the records to match the data file")
+	}
+	if first[0] != recordActive || second[0] != recordDeleted {
+		t.Errorf("expected type bytes 0 and 1, got %d and %d", first[0], second[0])
+	}
+
+	_, err = store.GetRaw(2)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("expected ErrOutOfRange, got %v", err)
+	}
+}
+
+func TestAppendRaw(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	followerPath := filepath.Join(dir, "test_follower.db")
+
+	leader, err := NewStore(path, WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer leader.Close()
+	_, err = leader.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = leader.Update(1, []byte("updated"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = leader.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	follower, err := NewStore(followerPath, WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to create follower: %v", err)
+	}
+	for line := uint64(0); line < leader.Count(); line++ {
+		record, err := leader.GetRaw(line)
+		if err != nil {
+			t.Fatalf("get raw failed: %v", err)
+		}
+		_, err = follower.AppendRaw(record)
+		if err != nil {
+			t.Fatalf("append raw of line %d failed: %v", line, err)
+		}
+	}
+
+	// Malformed records are rejected
+	record, err := leader.GetRaw(0)
+	if err != nil {
+		t.Fatalf("get raw failed: %v", err)
+	}
+	for name, bad := range map[string][]byte{
+		"truncated": record[:len(record)-1],
+		"bad type":  append([]byte{0xff}, record[1:]...),
+		"bad crc":   append(append([]byte{}, record[:len(record)-1]...), record[len(record)-1]^1),
+		"empty":     nil,
+	} {
+		_, err = follower.AppendRaw(bad)
+		if !errors.Is(err, ErrInvalidRecord) {
+			t.Errorf("%s: expected ErrInvalidRecord, got %v", name, err)
+		}
+	}
+	follower.Close()
+
+	// The follower reopens with the leader's lines
+	follower, err = NewStore(followerPath, WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to reopen follower: %v", err)
+	}
+	defer follower.Close()
+	if follower.Count() != 3 {
+		t.Fatalf("expected 3 lines, got %d", follower.Count())
+	}
+	for line, want := range []string{"value0", "updated"} {
+		value, err := follower.Get(uint64(line))
+		if err != nil || string(value) != want {
+			t.Errorf("expected %s at line %d, got %s, %v", want, line, value, err)
+		}
+	}
+	_, err = follower.Get(2)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted for line 2, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package store
+
+import "io"
+
+// readaheadSize is how many bytes a readahead reads from the file at once.
+var readaheadSize = 256 << 10
+
+// readahead serves small reads of a file from a buffer filled with one large read, so a
+// scan over consecutive records costs a read per block instead of several per record.
+// Reads that miss the buffer refill it from their offset, and reads at least a block
+// long go straight to the file. A readahead is only used by one goroutine, for the
+// length of a scan under the store's lock.
+type readahead struct {
+	r     io.ReaderAt
+	buf   []byte
+	start int64 // File offset of buf[0]
+}
+
+// newReadahead returns a readahead over r.
+func newReadahead(r io.ReaderAt) *readahead {
+	return &readahead{r: r}
+}
+
+// ReadAt implements io.ReaderAt. Near the end of the file it returns fewer bytes than
+// asked for along with the file's error, like the file would.
+func (ra *readahead) ReadAt(p []byte, off int64) (int, error) {
+	if off >= ra.start && off+int64(len(p)) <= ra.start+int64(len(ra.buf)) {
+		return copy(p, ra.buf[off-ra.start:]), nil
+	}
+	if len(p) >= readaheadSize {
+		return ra.r.ReadAt(p, off)
+	}
+	if ra.buf == nil {
+		ra.buf = make([]byte, readaheadSize)
+	}
+	n, err := ra.r.ReadAt(ra.buf[:readaheadSize], off)
+	ra.buf, ra.start = ra.buf[:n], off
+	if n >= len(p) {
+		return copy(p, ra.buf), nil
+	}
+	return copy(p, ra.buf), err
+}
+
+// sequentialReaders returns readers of the data and index files for a scan in line
+// order, buffered with a readahead unless the file is memory-mapped or, for the index,
+// held in memory. The caller must hold the lock for as long as it uses them.
+func (s *Store) sequentialReaders() (data, index io.ReaderAt) {
+	data, index = s.file, s.indexFile
+	if _, ok := s.file.(*mmapBackend); !ok {
+		data = newReadahead(s.file)
+	}
+	if s.offsets == nil {
+		index = newReadahead(s.indexFile)
+	}
+	return data, index
+}
@@ -0,0 +1,98 @@
+package store
+
+import "fmt"
+
+// RebuildIndex rewrites the index from the data file, pointing every line at its own
+// record or at the last Update written for it, as WithAutoReindex does when opening.
+// Use it when a lookup fails with ErrIndexCorrupt or Verify finds damaged index
+// entries. Writers and readers wait while the index is rebuilt. Stores whose lines
+// share records, opened with WithDedup, can't be rebuilt from the data file.
+func (s *Store) RebuildIndex() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return err
+	}
+	if s.format.flags&flagShared != 0 {
+		return fmt.Errorf("lines share records, so the index can't be rebuilt from the data file")
+	}
+	ranges, err := s.dataRanges()
+	if err != nil {
+		return fmt.Errorf("failed to stat data file: %v", err)
+	}
+	err = s.rebuildIndex(ranges, s.lineCount-s.baseLine)
+	if err != nil {
+		return err
+	}
+	s.sparseHint.Store(nil)
+	if s.offsets != nil {
+		return s.loadOffsets()
+	}
+	return nil
+}
+
+// rebuildIndex rewrites the index file from the records in ranges, for RebuildIndex,
+// WithAutoReindex, and WithSingleFile. The data file must hold lines complete line
+// records and no partial one, and its lines must not share records. Each line points at its own record, or at the last
+// update record written for it; a sparse index only gets the entries of every
+// interval-th line, which point at the lines' own records. The caller must hold the
+// write lock.
+func (s *Store) rebuildIndex(ranges [][2]int64, lines uint64) error {
+	indexSize, err := s.indexFile.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat index file: %v", err)
+	}
+
+	every := s.format.indexEvery()
+	offsets := make([]uint64, 0, lines/every+1)
+	found := uint64(0) // Line records found so far
+	for _, r := range ranges {
+		for offset := r[0]; offset < r[1]; {
+			info, next, ok := s.scanRecord(offset, r[1])
+			if !ok {
+				return fmt.Errorf("unreadable record at offset %d: %w", offset, ErrInvalidRecord)
+			}
+			if info.typeByte&recordUpdate == 0 {
+				if found%every == 0 {
+					offsets = append(offsets, uint64(offset))
+				}
+				found++
+			} else if every == 1 && info.target >= s.baseLine && info.target-s.baseLine < uint64(len(offsets)) {
+				offsets[info.target-s.baseLine] = uint64(offset)
+			}
+			offset = next
+		}
+	}
+
+	err = s.indexFile.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("failed to truncate index file: %v", err)
+	}
+	var index []byte
+	indexPos := int64(0)
+	for i, offset := range offsets {
+		index = append(index, s.format.encodeIndexEntry(s.baseLine+uint64(i)*every, offset)...)
+		if len(index) >= batchChunkSize || i == len(offsets)-1 {
+			_, err = s.indexFile.WriteAt(index, indexPos)
+			if err != nil {
+				return fmt.Errorf("failed to write rebuilt index: %v", err)
+			}
+			indexPos += int64(len(index))
+			index = index[:0]
+		}
+	}
+	err = s.sync(s.indexFile)
+	if err != nil {
+		return fmt.Errorf("failed to sync index file: %v", err)
+	}
+	if !s.opts.singleFile {
+		// A single-file store rebuilds its index on every open; that isn't worth reporting
+		s.opts.logger.Printf("linestore: rebuilt index store=%q lines=%d index_bytes_before=%d index_bytes_after=%d",
+			s.name(), found, indexSize, indexPos)
+	}
+
+	s.lineCount = s.baseLine + found
+	return nil
+}
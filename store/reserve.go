@@ -0,0 +1,164 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Reserve adds n pending lines, which read as ErrPending until WriteReserved fills them,
+// and returns the first. A Polish that renumbers lines drops pending lines like deleted
+// ones, so it must not run while reservations are outstanding.
+func (s *Store) Reserve(n uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+	if s.format.version == 0 {
+		return 0, fmt.Errorf("reserving lines needs a store with a header: %w", ErrUnsupportedVersion)
+	}
+	first := s.lineCount
+	if n == 0 {
+		return first, nil
+	}
+
+	written := time.Now().UnixNano()
+	placeholder := s.format.encodeRecord(recordDeleted|recordPending, 0, 0, written, nil)
+	if n > math.MaxUint64-first || n > math.MaxInt64/uint64(len(placeholder)) {
+		return 0, fmt.Errorf("cannot reserve %d lines: %w", n, ErrOutOfRange)
+	}
+	size := int64(len(placeholder)) * int64(n)
+	err = s.checkQuota(size)
+	if err != nil {
+		return 0, err
+	}
+
+	dataStart, err := s.appendOffset()
+	if err != nil {
+		return 0, err
+	}
+	indexStart := s.indexPos(first)
+	markReserved := s.format.flags&flagReserved == 0
+	fail := func(msg string, err error) error {
+		s.rollback(dataStart, indexStart)
+		if markReserved && s.format.flags&flagReserved != 0 {
+			s.format.flags &^= flagReserved
+			s.file.WriteAt(s.header(), 0)
+		}
+		return writeError(msg, err)
+	}
+
+	// Placeholders are written in chunks, like SetBatch records, so n doesn't bound memory
+	var data, index []byte
+	var offsets []uint64
+	dataOffset, indexOffset := dataStart, indexStart
+	for i := uint64(0); i < n; i++ {
+		if s.offsets != nil {
+			offsets = append(offsets, uint64(dataOffset))
+		}
+		index = append(index, s.indexEntry(first+i, uint64(dataOffset))...)
+		data = append(data, placeholder...)
+		dataOffset += int64(len(placeholder))
+
+		if len(data) >= batchChunkSize || i == n-1 {
+			_, err = s.file.WriteAt(data, dataOffset-int64(len(data)))
+			if err != nil {
+				return 0, fail("failed to write placeholders", err)
+			}
+			_, err = s.indexFile.WriteAt(index, indexOffset)
+			if err != nil {
+				return 0, fail("failed to write index entries", err)
+			}
+			indexOffset += int64(len(index))
+			data, index = data[:0], index[:0]
+		}
+	}
+	if markReserved {
+		// From now on records may be placeholders, which older releases can't read
+		s.format.flags |= flagReserved
+		_, err = s.file.WriteAt(s.header(), 0)
+		if err != nil {
+			return 0, fail("failed to update header", err)
+		}
+	}
+	err = s.sync(s.file)
+	if err != nil {
+		return 0, fail("failed to sync data file", err)
+	}
+	err = s.sync(s.indexFile)
+	if err != nil {
+		return 0, fail("failed to sync index file", err)
+	}
+
+	if s.offsets != nil {
+		s.offsets = append(s.offsets, offsets...)
+	}
+	s.lineCount += n
+	s.countWritten(int(size))
+	err = s.evict(true)
+	s.updateGauges()
+	return first, err
+}
+
+// WriteReserved stores value at line, which must have been reserved by Reserve and not
+// written or deleted since. The value is appended and the line's index entry repointed
+// at it, like Update, and subscribers are sent an EventSet for the line. Writing a
+// line that isn't pending returns ErrConflict, so each reserved line is filled once.
+// Writes are serialized like every other write; workers gain from filling lines in
+// parallel only through preparing their values concurrently.
+func (s *Store) WriteReserved(line uint64, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return err
+	}
+	if line >= s.lineCount {
+		return fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
+	}
+	err = s.checkValueSize(value)
+	if err != nil {
+		return err
+	}
+	dataOffset, typeByte, err := s.readLineType(line)
+	if err != nil {
+		return err
+	}
+	if typeByte&recordPending == 0 {
+		return fmt.Errorf("line %d is not pending: %w", line, ErrConflict)
+	}
+	oldSize, err := s.recordSize(int64(dataOffset), line)
+	if err != nil {
+		return err
+	}
+
+	payload, err := s.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	record := s.format.encodeRecord(recordUpdate, line, 0, time.Now().UnixNano(), payload)
+	err = s.checkQuota(int64(len(record)))
+	if err != nil {
+		return err
+	}
+	err = s.replaceRecord(line, record)
+	if err != nil {
+		return err
+	}
+	s.addDead(oldSize)
+	s.notify(EventSet, line)
+	return nil
+}
+
+// deletedError returns the error for reading line, whose record of type typeByte is
+// deleted: ErrPending for a reserved line that hasn't been written, ErrDeleted otherwise.
+func deletedError(typeByte byte, line uint64) error {
+	if typeByte&recordPending != 0 {
+		return fmt.Errorf("line %d: %w", line, ErrPending)
+	}
+	return fmt.Errorf("line %d: %w", line, ErrDeleted)
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestReserve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	first, err := store.Reserve(3)
+	if err != nil {
+		t.Fatalf("reserve failed: %v", err)
+	}
+	if first != 1 || store.Count() != 4 {
+		t.Fatalf("expected lines 1 to 3 reserved, got %d with %d lines", first, store.Count())
+	}
+	line, err := store.Set([]byte("value4"))
+	if err != nil || line != 4 {
+		t.Fatalf("expected set after the reservation at line 4, got %d, %v", line, err)
+	}
+
+	_, err = store.Get(2)
+	if !errors.Is(err, ErrPending) {
+		t.Errorf("expected ErrPending for a reserved line, got %v", err)
+	}
+	pairs, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(pairs) != 2 {
+		t.Errorf("expected pending lines to be skipped, got %v", pairs)
+	}
+
+	// Lines are filled out of order, once each
+	err = store.WriteReserved(3, []byte("value3"))
+	if err != nil {
+		t.Fatalf("write reserved failed: %v", err)
+	}
+	err = store.WriteReserved(3, []byte("again"))
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a filled line, got %v", err)
+	}
+	err = store.WriteReserved(0, []byte("value0"))
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a line that wasn't reserved, got %v", err)
+	}
+
+	// Deleting a reservation cancels it
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = store.Get(2)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted for a canceled reservation, got %v", err)
+	}
+	err = store.WriteReserved(2, []byte("value2"))
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a canceled reservation, got %v", err)
+	}
+
+	// Reservations survive reopening and compaction
+	store.Close()
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	err = store.Compact()
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	_, err = store.Get(1)
+	if !errors.Is(err, ErrPending) {
+		t.Errorf("expected ErrPending after compacting, got %v", err)
+	}
+	err = store.WriteReserved(1, []byte("value1"))
+	if err != nil {
+		t.Fatalf("write reserved failed: %v", err)
+	}
+	report, err := store.Verify()
+	if err != nil || !report.OK() {
+		t.Errorf("expected a clean verify, got %+v, %v", report, err)
+	}
+	for line, want := range map[uint64]string{0: "value0", 1: "value1", 3: "value3", 4: "value4"} {
+		value, err := store.Get(line)
+		if err != nil || string(value) != want {
+			t.Errorf("expected %s at line %d, got %s, %v", want, line, value, err)
+		}
+	}
+}
+
+func TestReserveFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	// Too many lines are refused before anything is allocated or written
+	_, err = store.Reserve(1 << 62)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("expected ErrOutOfRange, got %v", err)
+	}
+
+	// A failed write leaves neither placeholders nor the reserved flag behind
+	size, err := store.FileSize()
+	if err != nil {
+		t.Fatalf("file size failed: %v", err)
+	}
+	data := store.file
+	store.file = &failingBackend{backend: data, failAt: size + batchChunkSize*3/2}
+	_, err = store.Reserve(200000)
+	if !errors.Is(err, ErrDiskFull) {
+		t.Errorf("expected ErrDiskFull, got %v", err)
+	}
+	store.file = data
+	if store.Count() != 1 || store.format.flags&flagReserved != 0 {
+		t.Errorf("expected the failed reservation to leave 1 line and no flag, got %d lines, flags %#x", store.Count(), store.format.flags)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.format.flags&flagReserved != 0 {
+		t.Errorf("expected no reserved flag on disk, got flags %#x", store.format.flags)
+	}
+	first, err := store.Reserve(200000)
+	if err != nil || first != 1 {
+		t.Fatalf("expected lines from 1 reserved, got %d, %v", first, err)
+	}
+	_, err = store.Get(200000)
+	if !errors.Is(err, ErrPending) {
+		t.Errorf("expected ErrPending for the last reserved line, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package store
+
+import "time"
+
+// writeRetry retries transient write and fsync errors, as set up by WithWriteRetry.
+// A nil *writeRetry runs every operation once.
+type writeRetry struct {
+	attempts  int              // Tries per operation, including the first
+	backoff   time.Duration    // Wait before the first retry, doubled for each one after
+	retryable func(error) bool // Reports whether an error is worth retrying
+}
+
+// newWriteRetry returns the retry policy set by o's options, or nil for none.
+func newWriteRetry(o options) *writeRetry {
+	if o.retryAttempts <= 1 {
+		return nil
+	}
+	r := &writeRetry{attempts: o.retryAttempts, backoff: o.retryBackoff, retryable: o.retryable}
+	if r.retryable == nil {
+		r.retryable = IsTransient
+	}
+	return r
+}
+
+// do runs op until it succeeds, fails with an error that isn't retryable, or runs out
+// of attempts, and returns its last error.
+func (r *writeRetry) do(op func() error) error {
+	err := op()
+	if r == nil {
+		return err
+	}
+	backoff := r.backoff
+	for attempt := 1; err != nil && attempt < r.attempts && r.retryable(err); attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = op()
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaxRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithMaxRecords(4))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+
+	_, err = store.Get(1)
+	if !errors.Is(err, ErrEvicted) {
+		t.Errorf("expected ErrEvicted for line 1, got %v", err)
+	}
+	exists, err := store.Exists(0)
+	if err != nil || exists {
+		t.Errorf("expected evicted line 0 not to exist, got %v, %v", exists, err)
+	}
+	records, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(records) != 4 || records[0][0].(uint64) != 2 {
+		t.Errorf("expected lines 2-5, got %v", records)
+	}
+
+	// Evicting four lines compacts them away while keeping the line numbers
+	err = store.Delete(5)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value6"), []byte("value7")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.Records != 4 || stats.IndexSize != 4*16 || stats.LiveLines != 3 {
+		t.Errorf("expected 4 records and 3 live lines after compaction, got %+v", stats)
+	}
+	store.Close()
+
+	// Eviction survives a reopen without the option
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != 8 {
+		t.Errorf("expected 8 lines, got %d", store.Count())
+	}
+	_, err = store.Get(3)
+	if !errors.Is(err, ErrEvicted) {
+		t.Errorf("expected ErrEvicted for line 3, got %v", err)
+	}
+	_, err = store.Get(5)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted for line 5, got %v", err)
+	}
+	value, err := store.Get(7)
+	if err != nil || string(value) != "value7" {
+		t.Errorf("expected value7, got %s, %v", value, err)
+	}
+	var lines []uint64
+	err = store.ForEach(func(line uint64, value []byte) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil || fmt.Sprint(lines) != "[4 6 7]" {
+		t.Errorf("expected lines [4 6 7], got %v, %v", lines, err)
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("expected a clean report, got %+v", report)
+	}
+}
@@ -0,0 +1,268 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Offsets into a segmented data file carry the segment id in their top bits, so
+// index entries and the rest of the store address records across segments without
+// knowing about them. Each segment starts with its own copy of the file header.
+const (
+	segmentShift = 48
+	segmentMask  = 1<<segmentShift - 1
+)
+
+// segmentOffset combines a segment id and an offset within that segment.
+func segmentOffset(id int, offset int64) int64 {
+	return int64(id)<<segmentShift | offset
+}
+
+// splitOffset separates an offset into its segment id and the offset within the segment.
+func splitOffset(offset int64) (int, int64) {
+	return int(offset >> segmentShift), offset & segmentMask
+}
+
+// segmentPath returns the file name of segment id. Segment 0 is the store's own data file.
+func segmentPath(path string, id int) string {
+	if id == 0 {
+		return path
+	}
+	return path + "." + strconv.Itoa(id)
+}
+
+// segmentedBackend spreads the data file over several segment files. Writes only
+// append to the last segment, apart from in-place tombstones.
+type segmentedBackend struct {
+	mu       sync.Mutex
+	path     string
+	mode     os.FileMode
+	segments []backend
+	dirty    []bool      // Segments written since the last Sync
+	retry    *writeRetry // Retry policy of new segments, taken from the first
+}
+
+// openSegments wraps first, which is segment 0, in a segmented backend. With discover
+// set, every following segment file that exists is opened as well; otherwise segment
+// files left over from before a Polish are ignored and overwritten as the store grows.
+func openSegments(first backend, path string, flag int, mode os.FileMode, discover bool) (*segmentedBackend, error) {
+	sb := &segmentedBackend{path: path, mode: mode, segments: []backend{first}, dirty: []bool{false}}
+	if f, ok := first.(*fileBackend); ok {
+		sb.retry = f.retry
+	}
+	for id := 1; discover; id++ {
+		file, err := os.OpenFile(segmentPath(path, id), flag&^os.O_CREATE, mode)
+		if os.IsNotExist(err) {
+			return sb, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open segment %d: %v", id, err)
+		}
+		sb.segments = append(sb.segments, &fileBackend{File: file, retry: sb.retry})
+		sb.dirty = append(sb.dirty, false)
+	}
+	return sb, nil
+}
+
+// ReadAt reads from the segment the offset points into.
+func (sb *segmentedBackend) ReadAt(p []byte, off int64) (int, error) {
+	id, offset := splitOffset(off)
+	sb.mu.Lock()
+	if id >= len(sb.segments) {
+		sb.mu.Unlock()
+		return 0, io.EOF
+	}
+	segment := sb.segments[id]
+	sb.mu.Unlock()
+	return segment.ReadAt(p, offset)
+}
+
+// WriteAt writes to the segment the offset points into.
+func (sb *segmentedBackend) WriteAt(p []byte, off int64) (int, error) {
+	id, offset := splitOffset(off)
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if id >= len(sb.segments) {
+		return 0, fmt.Errorf("segment %d does not exist", id)
+	}
+	sb.dirty[id] = true
+	return sb.segments[id].WriteAt(p, offset)
+}
+
+// Truncate cuts the segment the size points into and removes every later segment.
+func (sb *segmentedBackend) Truncate(size int64) error {
+	id, offset := splitOffset(size)
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if id >= len(sb.segments) {
+		return nil
+	}
+	for last := len(sb.segments) - 1; last > id; last-- {
+		sb.segments[last].Close()
+		err := os.Remove(segmentPath(sb.path, last))
+		if err != nil {
+			return fmt.Errorf("failed to remove segment %d: %v", last, err)
+		}
+		sb.segments = sb.segments[:last]
+		sb.dirty = sb.dirty[:last]
+	}
+	sb.dirty[id] = true
+	return sb.segments[id].Truncate(offset)
+}
+
+// Sync syncs every segment written since the last Sync.
+func (sb *segmentedBackend) Sync() error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	for id, dirty := range sb.dirty {
+		if !dirty {
+			continue
+		}
+		err := sb.segments[id].Sync()
+		if err != nil {
+			return err
+		}
+		sb.dirty[id] = false
+	}
+	return nil
+}
+
+// Size returns the end of the last segment as a segment offset, which is where the
+// next record is appended.
+func (sb *segmentedBackend) Size() (int64, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	last := len(sb.segments) - 1
+	size, err := sb.segments[last].Size()
+	if err != nil {
+		return 0, err
+	}
+	return segmentOffset(last, size), nil
+}
+
+// preallocate reserves disk space for n more bytes past the end of the last segment.
+func (sb *segmentedBackend) preallocate(n int64) error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if p, ok := sb.segments[len(sb.segments)-1].(preallocator); ok {
+		return p.preallocate(n)
+	}
+	return nil
+}
+
+// Close closes every segment.
+func (sb *segmentedBackend) Close() error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	var firstErr error
+	for _, segment := range sb.segments {
+		err := segment.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ranges returns the start and end segment offsets of every segment's records.
+func (sb *segmentedBackend) ranges(headerLen int64) ([][2]int64, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	ranges := make([][2]int64, len(sb.segments))
+	for id, segment := range sb.segments {
+		size, err := segment.Size()
+		if err != nil {
+			return nil, err
+		}
+		ranges[id] = [2]int64{segmentOffset(id, headerLen), segmentOffset(id, size)}
+	}
+	return ranges, nil
+}
+
+// addSegment creates the next segment file and writes header to it.
+func (sb *segmentedBackend) addSegment(header []byte) error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	id := len(sb.segments)
+	if id > 1<<(63-segmentShift)-1 {
+		return fmt.Errorf("too many segments")
+	}
+	file, err := os.OpenFile(segmentPath(sb.path, id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, sb.mode)
+	if err != nil {
+		return fmt.Errorf("failed to create segment %d: %v", id, err)
+	}
+	_, err = file.WriteAt(header, 0)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to write segment header: %v", err)
+	}
+	sb.segments = append(sb.segments, &fileBackend{File: file, retry: sb.retry})
+	sb.dirty = append(sb.dirty, true)
+	return nil
+}
+
+// dataRanges returns the start and end offsets of the records in each part of the data
+// file: one range per segment, or a single range for an unsegmented store.
+func (s *Store) dataRanges() ([][2]int64, error) {
+	if sb, ok := s.file.(*segmentedBackend); ok {
+		return sb.ranges(s.format.headerLen())
+	}
+	size, err := s.file.Size()
+	if err != nil {
+		return nil, err
+	}
+	return [][2]int64{{s.format.headerLen(), size}}, nil
+}
+
+// dataSize returns the total size of the data file, summed over all segments.
+func (s *Store) dataSize() (int64, error) {
+	ranges, err := s.dataRanges()
+	if err != nil {
+		return 0, err
+	}
+	total := int64(0)
+	for _, r := range ranges {
+		total += r[1] & segmentMask
+	}
+	return total, nil
+}
+
+// appendOffset returns the offset the next record is appended at. On a segmented store
+// whose last segment has reached the segment size, a new segment is started first.
+func (s *Store) appendOffset() (int64, error) {
+	size, err := s.file.Size()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat data file: %v", err)
+	}
+	sb, ok := s.file.(*segmentedBackend)
+	if !ok || s.opts.segmentSize == 0 || size&segmentMask < s.opts.segmentSize {
+		return size, nil
+	}
+
+	if s.format.flags&flagSegmented == 0 {
+		// Mark segment 0 so the store is always opened with its segments
+		s.format.flags |= flagSegmented
+		_, err = s.file.WriteAt(s.header(), 0)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update header: %v", err)
+		}
+	}
+	err = sb.addSegment(s.header())
+	if err != nil {
+		return 0, err
+	}
+	id, _ := splitOffset(size)
+	return segmentOffset(id+1, s.format.headerLen()), nil
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithSegmentSize(100))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := 0; i < 12; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	_, err = store.Update(0, []byte("updated0"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	store.Close()
+
+	// Each 24-byte record takes a 32-byte header past 100 bytes after 3 records
+	if _, err := os.Stat(segmentPath(path, 2)); err != nil {
+		t.Fatalf("expected a third segment: %v", err)
+	}
+
+	// Segments are found without the option
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != 12 {
+		t.Errorf("expected 12 lines, got %d", store.Count())
+	}
+	value, err := store.Get(0)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "updated0" {
+		t.Errorf("expected updated0, got %s", value)
+	}
+	value, err = store.Get(11)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "value11" {
+		t.Errorf("expected value11, got %s", value)
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() || report.Records != 13 {
+		t.Errorf("expected 13 healthy records, got %+v", report)
+	}
+
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if _, err := os.Stat(segmentPath(path, 1)); !os.IsNotExist(err) {
+		t.Error("expected polish to remove old segments")
+	}
+	items, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(items) != 11 {
+		t.Errorf("expected 11 live items after polish, got %d", len(items))
+	}
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// openIndex opens the index of the store at path: its index file, or with
+// WithSingleFile an empty in-memory index that load fills from the records.
+func openIndex(path string, flag int, o options, retry *writeRetry) (backend, error) {
+	if o.singleFile {
+		if o.dedup {
+			return nil, errors.New("WithSingleFile can't be combined with WithDedup")
+		}
+		if o.indexPath != "" {
+			return nil, errors.New("WithSingleFile can't be combined with WithIndexPath")
+		}
+		return &memBackend{}, nil
+	}
+	indexFile, err := os.OpenFile(indexPathFor(path, o), flag, o.fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index file: %v", err)
+	}
+	return &fileBackend{File: indexFile, retry: retry}, nil
+}
+
+// loadSingleFile builds the in-memory index of a WithSingleFile store from the records
+// in ranges, of which countLines found lines complete line records ending at offset.
+// With WithRecovery, an incomplete record after them is truncated away first. The
+// caller must hold the write lock.
+func (s *Store) loadSingleFile(ranges [][2]int64, offset int64, lines uint64) error {
+	if s.format.flags&flagShared != 0 {
+		return errors.New("store shares records between lines and needs its index file; open it without WithSingleFile")
+	}
+	dataEnd := ranges[len(ranges)-1][1]
+	if offset < dataEnd {
+		if !s.opts.recovery || s.readOnly {
+			return fmt.Errorf("incomplete record at offset %d", offset)
+		}
+		err := s.file.Truncate(offset)
+		if err != nil {
+			return fmt.Errorf("failed to truncate data file: %v", err)
+		}
+		err = s.sync(s.file)
+		if err != nil {
+			return fmt.Errorf("failed to sync data file: %v", err)
+		}
+		s.opts.logger.Printf("linestore: recovered store=%q discarded_data_bytes=%d discarded_index_bytes=0 reindexed_records=0",
+			s.name(), dataEnd-offset)
+		ranges, err = s.dataRanges()
+		if err != nil {
+			return fmt.Errorf("failed to stat data file: %v", err)
+		}
+	}
+	return s.rebuildIndex(ranges, lines)
+}
@@ -0,0 +1,48 @@
+package store
+
+// Snapshot is a point-in-time view of a store. Lines appended after the snapshot
+// was taken are invisible to it, so a long List sees a stable set of lines while
+// writers keep calling Set.
+//
+// Because a snapshot only freezes the line count, it still reads the store's
+// current records: an Update or Delete of a line within the snapshot is visible,
+// and Polish or Clear renumber lines, which invalidates older snapshots.
+type Snapshot struct {
+	store     *Store
+	lineCount uint64
+}
+
+// Snapshot returns a view of the store bounded to the lines written so far.
+func (s *Store) Snapshot() *Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &Snapshot{store: s, lineCount: s.lineCount}
+}
+
+// Count returns the number of lines visible to the snapshot, including deleted ones.
+func (sn *Snapshot) Count() uint64 {
+	return sn.lineCount
+}
+
+// Get retrieves the value at line, which must be within the snapshot.
+func (sn *Snapshot) Get(line uint64) ([]byte, error) {
+	sn.store.mu.RLock()
+	defer sn.store.mu.RUnlock()
+	return sn.store.getLine(line, sn.visibleLines())
+}
+
+// List returns the live line/value pairs visible to the snapshot, in line order.
+func (sn *Snapshot) List() ([][2]interface{}, error) {
+	sn.store.mu.RLock()
+	defer sn.store.mu.RUnlock()
+
+	end := sn.visibleLines()
+	return sn.store.collect(0, end, end)
+}
+
+// visibleLines returns the snapshot's line count, clamped to the store's current
+// line count in case the store shrank since the snapshot was taken.
+// The caller must hold the store's read lock.
+func (sn *Snapshot) visibleLines() uint64 {
+	return min(sn.lineCount, sn.store.lineCount)
+}
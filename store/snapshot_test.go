@@ -0,0 +1,63 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	snap := store.Snapshot()
+
+	line, err := store.Set([]byte("value2"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	if snap.Count() != 2 {
+		t.Errorf("expected snapshot count 2, got %d", snap.Count())
+	}
+	_, err = snap.Get(line)
+	if err == nil {
+		t.Error("expected error reading a line written after the snapshot")
+	}
+	value, err := snap.Get(1)
+	if err != nil {
+		t.Fatalf("snapshot get failed: %v", err)
+	}
+	if string(value) != "value1" {
+		t.Errorf("expected value1, got %s", value)
+	}
+
+	items, err := snap.List()
+	if err != nil {
+		t.Fatalf("snapshot list failed: %v", err)
+	}
+	if len(items) != 2 {
+		t.Errorf("expected 2 items in snapshot, got %d", len(items))
+	}
+
+	// The snapshot is clamped once the store shrinks below it
+	err = store.Clear()
+	if err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	items, err = snap.List()
+	if err != nil {
+		t.Fatalf("snapshot list after clear failed: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected empty snapshot after clear, got %v", items)
+	}
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// sparseHint remembers where the last sparse lookup found a line's own record, so a
+// lookup of a later line in the same interval can read on from there instead of from
+// the index entry.
+type sparseHint struct {
+	line   uint64
+	offset uint64
+}
+
+// checkSparse rejects the options a sparse index doesn't work with.
+func (s *Store) checkSparse() error {
+	switch {
+	case s.opts.dedup || s.format.flags&flagShared != 0:
+		return errors.New("a sparse index can't be combined with WithDedup")
+	case s.opts.memoryIndex:
+		return errors.New("a sparse index can't be combined with WithMemoryIndex")
+	case s.opts.singleFile:
+		return errors.New("a sparse index can't be combined with WithSingleFile")
+	}
+	return nil
+}
+
+// indexEntry returns the index entry for line pointing at dataOffset, or nothing when
+// line falls between the entries of a sparse index.
+func (s *Store) indexEntry(line, dataOffset uint64) []byte {
+	if (line-s.baseLine)%s.format.indexEvery() != 0 {
+		return nil
+	}
+	return s.format.encodeIndexEntry(line, dataOffset)
+}
+
+// sparseOffset returns the data file offset of line's record in a store with a sparse
+// index: the last update record written for it, or else its own record, found by
+// reading forward through the records after the index entry at or before line. index
+// serves reads of the index file. The caller must hold the lock.
+func (s *Store) sparseOffset(index io.ReaderAt, line uint64) (uint64, error) {
+	if offset, ok := s.sparseUpdates[line]; ok {
+		return offset, nil
+	}
+
+	entryLine := line - (line-s.baseLine)%s.format.indexEvery()
+	current, offset := entryLine, uint64(0)
+	if hint := s.sparseHint.Load(); hint != nil && hint.line >= entryLine && hint.line <= line {
+		current, offset = hint.line, hint.offset
+	} else {
+		indexEntry := make([]byte, s.format.indexEntrySize())
+		n, err := index.ReadAt(indexEntry, s.indexPos(entryLine))
+		if n == len(indexEntry) && !s.format.entryIntact(indexEntry) {
+			return 0, fmt.Errorf("index entry for line %d: %w", entryLine, ErrIndexCorrupt)
+		}
+		if n != len(indexEntry) || !s.format.entryHolds(indexEntry, entryLine) {
+			return 0, fmt.Errorf("failed to read index entry for line %d: %v", entryLine, err)
+		}
+		offset = s.format.entryOffset(indexEntry)
+	}
+
+	// Lines are appended in order, so the records in between belong to the lines in
+	// between, apart from update records, which are skipped
+	for {
+		at, info, next, err := s.nextRecord(int64(offset))
+		if err != nil {
+			return 0, fmt.Errorf("failed to find record of line %d: %w", line, err)
+		}
+		offset = uint64(at)
+		if info.typeByte&recordUpdate == 0 {
+			if current == line {
+				break
+			}
+			current++
+		}
+		offset = uint64(next)
+	}
+	s.sparseHint.Store(&sparseHint{line: line, offset: offset})
+	return offset, nil
+}
+
+// nextRecord reads the record at offset like scanRecord, for records known to be
+// complete. At the end of a segment it moves on to the first record of the next one, so
+// it returns the offset it read the record at along with the offset after it.
+func (s *Store) nextRecord(offset int64) (at int64, info recordInfo, next int64, err error) {
+	info, next, ok := s.scanRecord(offset, math.MaxInt64)
+	if ok {
+		return offset, info, next, nil
+	}
+	if sb, isSegmented := s.file.(*segmentedBackend); isSegmented {
+		ranges, err := sb.ranges(s.format.headerLen())
+		if err != nil {
+			return 0, recordInfo{}, 0, err
+		}
+		for i, r := range ranges[:len(ranges)-1] {
+			if r[1] == offset {
+				return s.nextRecord(ranges[i+1][0])
+			}
+		}
+	}
+	return 0, recordInfo{}, 0, fmt.Errorf("unreadable record at offset %d: %w", offset, ErrInvalidRecord)
+}
+
+// loadSparse checks the sparse index of a store in which countLines found lines complete
+// line records, ending at offset, and keeps updates, the last update record found for
+// each line. An index that doesn't match the records is rebuilt with WithAutoReindex or
+// WithRecovery, the latter also truncating an incomplete record away. The caller must
+// hold the write lock.
+func (s *Store) loadSparse(ranges [][2]int64, offset int64, lines uint64, updates map[uint64]uint64) error {
+	err := s.checkSparse()
+	if err != nil {
+		return err
+	}
+	indexSize, err := s.indexFile.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat index file: %v", err)
+	}
+	dataEnd := ranges[len(ranges)-1][1]
+	expectedSize := s.indexPos(s.baseLine + lines)
+
+	s.sparseUpdates = updates
+	s.sparseHint.Store(nil)
+	if offset == dataEnd && indexSize == expectedSize {
+		s.lineCount = s.baseLine + lines
+		return nil
+	}
+	if s.readOnly || !s.opts.recovery && (!s.opts.autoReindex || offset < dataEnd) {
+		if offset < dataEnd {
+			return fmt.Errorf("incomplete record at offset %d", offset)
+		}
+		return fmt.Errorf("index file size %d does not match expected %d", indexSize, expectedSize)
+	}
+	if offset < dataEnd {
+		err = s.file.Truncate(offset)
+		if err != nil {
+			return fmt.Errorf("failed to truncate data file: %v", err)
+		}
+		err = s.sync(s.file)
+		if err != nil {
+			return fmt.Errorf("failed to sync data file: %v", err)
+		}
+		s.opts.logger.Printf("linestore: recovered store=%q discarded_data_bytes=%d discarded_index_bytes=0 reindexed_records=0",
+			s.name(), dataEnd-offset)
+		ranges, err = s.dataRanges()
+		if err != nil {
+			return fmt.Errorf("failed to stat data file: %v", err)
+		}
+	}
+	return s.rebuildIndex(ranges, lines)
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparseIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithSparseIndex(4))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	values := make([][]byte, 10)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("value%d", i))
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	for i := 10; i < 13; i++ {
+		_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	_, err = store.Update(2, []byte("updated2"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	_, err = store.Update(5, []byte("updated5"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(7)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	// 13 lines take an entry for lines 0, 4, 8, and 12
+	size, err := store.IndexSize()
+	if err != nil || size != 4*16 {
+		t.Errorf("expected a 64-byte index, got %d, %v", size, err)
+	}
+	check := func(name string) {
+		t.Helper()
+		for line := uint64(0); line < 13; line++ {
+			expected := fmt.Sprintf("value%d", line)
+			if line == 2 || line == 5 {
+				expected = fmt.Sprintf("updated%d", line)
+			}
+			value, err := store.Get(line)
+			if line == 7 {
+				if !errors.Is(err, ErrDeleted) {
+					t.Errorf("%s: expected ErrDeleted at line 7, got %v", name, err)
+				}
+				continue
+			}
+			if err != nil || string(value) != expected {
+				t.Errorf("%s: expected %s at line %d, got %s, %v", name, expected, line, value, err)
+			}
+		}
+	}
+	check("random reads")
+	// Reading backwards never reuses the previous position
+	for line := uint64(12); line > 8; line-- {
+		value, err := store.Get(line)
+		if err != nil || string(value) != fmt.Sprintf("value%d", line) {
+			t.Errorf("expected value%d at line %d, got %s, %v", line, line, value, err)
+		}
+	}
+	pairs, err := store.List()
+	if err != nil || len(pairs) != 12 {
+		t.Errorf("expected 12 live lines, got %d, %v", len(pairs), err)
+	}
+	report, err := store.Verify()
+	if err != nil || !report.OK() || report.IndexEntries != 4 {
+		t.Errorf("expected a healthy sparse index, got %+v, %v", report, err)
+	}
+	store.Close()
+
+	// The interval is kept in the file, so the option isn't needed again
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	check("reopened")
+	stats, err := store.Stats()
+	if err != nil || stats.DeadBytes == 0 {
+		t.Errorf("expected dead bytes from the updates, got %+v, %v", stats, err)
+	}
+
+	err = store.TruncateTo(9)
+	if err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+	line, err := store.Set([]byte("value10"))
+	if err != nil || line != 10 {
+		t.Fatalf("expected line 10, got %d, %v", line, err)
+	}
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if store.Count() != 10 {
+		t.Errorf("expected 10 lines after polish, got %d", store.Count())
+	}
+	value, err := store.Get(5)
+	if err != nil || string(value) != "updated5" {
+		t.Errorf("expected updated5 at line 5 after polish, got %s, %v", value, err)
+	}
+	value, err = store.Get(9)
+	if err != nil || string(value) != "value10" {
+		t.Errorf("expected value10 at line 9 after polish, got %s, %v", value, err)
+	}
+	size, err = store.IndexSize()
+	if err != nil || size != 3*16 {
+		t.Errorf("expected a 48-byte index after polish, got %d, %v", size, err)
+	}
+	store.Close()
+
+	_, err = NewStore(path, WithMemoryIndex())
+	if err == nil {
+		t.Error("expected WithMemoryIndex to be rejected")
+	}
+}
+
+func TestSparseIndexRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	_, err := NewStore(path, WithSparseIndex(4), WithDedup())
+	if err == nil {
+		t.Fatal("expected WithDedup to be rejected")
+	}
+	os.Remove(path)
+	os.Remove(path + ".idx")
+
+	store, err := NewStore(path, WithSparseIndex(4))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3"), []byte("value4")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	store.Close()
+
+	// A torn record and a lost index entry are both repaired from the records
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open data file: %v", err)
+	}
+	file.Write([]byte{recordActive, 0, 0})
+	file.Close()
+	os.Truncate(path+".idx", 16)
+
+	_, err = NewStore(path)
+	if err == nil {
+		t.Error("expected an error without WithRecovery")
+	}
+	store, err = NewStore(path, WithRecovery())
+	if err != nil {
+		t.Fatalf("failed to recover store: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != 5 {
+		t.Errorf("expected 5 lines after recovery, got %d", store.Count())
+	}
+	value, err := store.Get(4)
+	if err != nil || string(value) != "value4" {
+		t.Errorf("expected value4 at line 4, got %s, %v", value, err)
+	}
+}
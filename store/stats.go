@@ -0,0 +1,253 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// Stats describes the size and fragmentation of a store.
+type Stats struct {
+	DataSize  int64  // Size of the data file in bytes, including the header, summed over all segments
+	IndexSize int64  // Size of the index file in bytes
+	Records   uint64 // Physical records in the data file, including deleted and superseded ones
+	Lines     uint64 // Lines in the store, as returned by Count
+	LiveLines uint64 // Lines that have not been deleted
+	DeadBytes int64  // Bytes held by deleted, evicted, and superseded records
+}
+
+// Fragmentation returns the share of the data file that Polish would reclaim, from 0 to 1.
+func (st Stats) Fragmentation() float64 {
+	if st.DataSize == 0 {
+		return 0
+	}
+	return float64(st.DeadBytes) / float64(st.DataSize)
+}
+
+// FileSize returns the size of the data file in bytes, including the header, summed
+// over all segments. Unlike Stats it only stats the open files, so it is cheap enough
+// to poll.
+func (s *Store) FileSize() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+	size, err := s.dataSize()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat data file: %v", err)
+	}
+	return size, nil
+}
+
+// IndexSize returns the size of the index file in bytes.
+func (s *Store) IndexSize() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+	size, err := s.indexFile.Size()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat index file: %v", err)
+	}
+	return size, nil
+}
+
+// Stats reports file sizes, record counts, and the dead bytes that Polish would remove.
+// Every record in the data file is scanned, so the cost grows with the file size.
+// A record is dead when it is deleted, evicted, or no index entry points at it any more.
+func (s *Store) Stats() (Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return Stats{}, ErrClosed
+	}
+	ranges, err := s.dataRanges()
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to stat data file: %v", err)
+	}
+	dataSize, err := s.dataSize()
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to stat data file: %v", err)
+	}
+	indexSize, err := s.indexFile.Size()
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to stat index file: %v", err)
+	}
+	st := Stats{
+		DataSize:  dataSize,
+		IndexSize: indexSize,
+		Lines:     s.lineCount,
+	}
+
+	indexed, err := s.indexedOffsets()
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, r := range ranges {
+		for offset := r[0]; offset < r[1]; {
+			info, next, ok := s.scanRecord(offset, r[1])
+			if !ok {
+				return Stats{}, fmt.Errorf("invalid record at offset %d", offset)
+			}
+			st.Records++
+			if info.typeByte&recordDeleted != 0 || indexed[offset].lines == 0 {
+				st.DeadBytes += next - offset
+			} else {
+				st.LiveLines += indexed[offset].lines
+			}
+			offset = next
+		}
+	}
+
+	return st, nil
+}
+
+// indexedRecord counts the lines the index points at a record for.
+type indexedRecord struct {
+	line  uint64 // First line pointing at the record
+	lines uint64 // Number of lines pointing at it
+}
+
+// indexedOffsets returns the data file offsets the index points at for the lines that
+// haven't been evicted, with the lines pointing at each. The caller must hold the lock.
+func (s *Store) indexedOffsets() (map[int64]indexedRecord, error) {
+	indexed := make(map[int64]indexedRecord, s.lineCount-s.evictLine)
+	add := func(dataOffset, line uint64) {
+		r := indexed[int64(dataOffset)]
+		if r.lines == 0 {
+			r.line = line
+		}
+		r.lines++
+		indexed[int64(dataOffset)] = r
+	}
+	if s.sparseUpdates != nil {
+		// A sparse index doesn't hold every line; looking them up in order is cheap
+		for line := s.evictLine; line < s.lineCount; line++ {
+			dataOffset, err := s.readIndexOffset(line)
+			if err != nil {
+				return nil, err
+			}
+			add(dataOffset, line)
+		}
+		return indexed, nil
+	}
+
+	index := make([]byte, s.indexPos(s.lineCount))
+	_, err := s.indexFile.ReadAt(index, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read index: %v", err)
+	}
+	for line := s.evictLine; line < s.lineCount; line++ {
+		pos := s.indexPos(line)
+		add(s.format.entryOffset(index[pos:pos+s.format.indexEntrySize()]), line)
+	}
+	return indexed, nil
+}
+
+// valueSize returns the size of the value of line's record at offset, as given to the
+// store. Only compressed values have to be read and decompressed to find it; otherwise
+// the length in the record's header is enough. The caller must hold the lock.
+func (s *Store) valueSize(offset int64, info recordInfo, line uint64) (uint64, error) {
+	if s.format.codec == CompressionNone {
+		size := uint64(info.valLen)
+		if s.format.encrypted() {
+			size -= min(size, encryptionOverhead)
+		}
+		return size, nil
+	}
+	_, value, err := s.readRecord(offset, line, false)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(value)), nil
+}
+
+// SizeReport describes the sizes of the live values in a store, as returned by
+// SizeHistogram. Sizes are those of the values as given to the store, before
+// compression and encryption, so they compare with WithMaxValueSize.
+type SizeReport struct {
+	Buckets []uint32 // Upper bounds of the buckets, inclusive, as passed to SizeHistogram
+	Counts  []uint64 // Values per bucket, with one more count at the end for values above every bound
+	Values  uint64   // Live values counted
+	Min     uint64   // Smallest size, 0 when there are no values
+	Max     uint64   // Largest size
+	Mean    float64  // Average size
+	Median  uint64   // Middle size, the lower of the two middle ones for an even count
+}
+
+// SizeHistogram counts the live values in each size bucket, where buckets holds the
+// inclusive upper bounds in increasing order, for example to choose WithMaxValueSize or
+// whether compression pays off. Every record is visited, so the cost grows with the file
+// size like Stats. Only the length in each record's header is read, except in
+// compressed stores, where each live value is read and decompressed. The sizes are kept
+// in memory to find the median. Lines sharing a record with WithDedup are counted once
+// each.
+func (s *Store) SizeHistogram(buckets []uint32) (SizeReport, error) {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return SizeReport{}, fmt.Errorf("bucket bounds must increase, got %d after %d", buckets[i], buckets[i-1])
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return SizeReport{}, ErrClosed
+	}
+	ranges, err := s.dataRanges()
+	if err != nil {
+		return SizeReport{}, fmt.Errorf("failed to stat data file: %v", err)
+	}
+	indexed, err := s.indexedOffsets()
+	if err != nil {
+		return SizeReport{}, err
+	}
+
+	report := SizeReport{
+		Buckets: slices.Clone(buckets),
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+	var sizes []uint64
+	var total float64
+	for _, r := range ranges {
+		for offset := r[0]; offset < r[1]; {
+			info, next, ok := s.scanRecord(offset, r[1])
+			if !ok {
+				return SizeReport{}, fmt.Errorf("invalid record at offset %d", offset)
+			}
+			lines := indexed[offset].lines
+			if info.typeByte&recordDeleted == 0 && lines > 0 {
+				size, err := s.valueSize(offset, info, indexed[offset].line)
+				if err != nil {
+					return SizeReport{}, err
+				}
+				bucket, _ := slices.BinarySearch(buckets, uint32(min(size, math.MaxUint32)))
+				if size > math.MaxUint32 {
+					bucket = len(buckets)
+				}
+				report.Counts[bucket] += lines
+				for range lines {
+					sizes = append(sizes, size)
+				}
+				total += float64(size) * float64(lines)
+			}
+			offset = next
+		}
+	}
+
+	report.Values = uint64(len(sizes))
+	if len(sizes) > 0 {
+		slices.Sort(sizes)
+		report.Min, report.Max = sizes[0], sizes[len(sizes)-1]
+		report.Mean = total / float64(len(sizes))
+		report.Median = sizes[(len(sizes)-1)/2]
+	}
+	return report, nil
+}
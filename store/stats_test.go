@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(1, []byte("updated1"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	// Each original record is 1 type + 1 flags + 8 time + 4 length + 6 value + 4 checksum bytes
+	if stats.Records != 4 || stats.Lines != 3 || stats.LiveLines != 2 {
+		t.Errorf("unexpected counts: %+v", stats)
+	}
+	if stats.DeadBytes != 2*24 {
+		t.Errorf("expected 48 dead bytes, got %d", stats.DeadBytes)
+	}
+	if stats.IndexSize != 3*16 {
+		t.Errorf("expected index size 48, got %d", stats.IndexSize)
+	}
+	fileSize, err := store.FileSize()
+	if err != nil || fileSize != stats.DataSize {
+		t.Errorf("expected file size %d, got %d, %v", stats.DataSize, fileSize, err)
+	}
+	indexSize, err := store.IndexSize()
+	if err != nil || indexSize != stats.IndexSize {
+		t.Errorf("expected index size %d, got %d, %v", stats.IndexSize, indexSize, err)
+	}
+
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	stats, err = store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.DeadBytes != 0 || stats.Fragmentation() != 0 || stats.LiveLines != 2 {
+		t.Errorf("expected no dead bytes after polish, got %+v", stats)
+	}
+}
+
+func TestSizeHistogram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	values := [][]byte{
+		[]byte(""),
+		[]byte("abc"),
+		[]byte("abcdefghij"),
+		[]byte("abcdefghijk"),
+		[]byte(strings.Repeat("x", 200)),
+		[]byte("deleted"),
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(5)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	// Only the update counts, not the record it replaced
+	_, err = store.Update(1, []byte("abcd"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	report, err := store.SizeHistogram([]uint32{0, 10, 100})
+	if err != nil {
+		t.Fatalf("size histogram failed: %v", err)
+	}
+	if !slices.Equal(report.Counts, []uint64{1, 2, 1, 1}) {
+		t.Errorf("expected counts [1 2 1 1], got %v", report.Counts)
+	}
+	if report.Values != 5 || report.Min != 0 || report.Max != 200 || report.Median != 10 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if report.Mean != float64(0+4+10+11+200)/5 {
+		t.Errorf("expected mean 45, got %v", report.Mean)
+	}
+
+	_, err = store.SizeHistogram([]uint32{10, 10})
+	if err == nil {
+		t.Error("expected error for bounds that don't increase")
+	}
+
+	// Sizes are those of the values given, however they are stored
+	for name, opt := range map[string]Option{
+		"gzip":      WithCompression(CompressionGzip),
+		"encrypted": WithEncryption(bytes.Repeat([]byte{7}, 32)),
+	} {
+		encoded, err := NewStore(filepath.Join(t.TempDir(), "test.db"), opt)
+		if err != nil {
+			t.Fatalf("%s: failed to create store: %v", name, err)
+		}
+		defer encoded.Close()
+		_, err = encoded.SetBatch([][]byte{[]byte("abc"), []byte(strings.Repeat("x", 1000))})
+		if err != nil {
+			t.Fatalf("%s: set batch failed: %v", name, err)
+		}
+		report, err := encoded.SizeHistogram([]uint32{10, 100})
+		if err != nil {
+			t.Fatalf("%s: size histogram failed: %v", name, err)
+		}
+		if !slices.Equal(report.Counts, []uint64{1, 0, 1}) || report.Min != 3 || report.Max != 1000 {
+			t.Errorf("%s: unexpected report: %+v", name, report)
+		}
+	}
+}
@@ -1,434 +1,2507 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"crypto/cipher"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamChunkSize is the buffer size SetReader uses to copy values into the data file.
+const streamChunkSize = 32 << 10
+
+// batchChunkSize is how many bytes of records SetBatch collects before writing them out.
+const batchChunkSize = 1 << 20
+
+var (
+	// ErrDeleted is returned when reading a line whose record has been deleted.
+	ErrDeleted = errors.New("record deleted")
+	// ErrPending is returned when reading a line reserved by Reserve that has not been
+	// written with WriteReserved yet.
+	ErrPending = errors.New("record pending")
+	// ErrValueTooLarge is returned when a value exceeds the store's maximum value size.
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrReadOnly is returned by write methods on a store opened with OpenReadOnly.
+	ErrReadOnly = errors.New("store is read-only")
+	// ErrChecksumMismatch is returned when a record's value does not match its stored CRC32.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrBufferTooSmall is returned by GetInto when the value does not fit in the buffer.
+	ErrBufferTooSmall = errors.New("buffer too small")
+	// ErrEvicted is returned when reading a line dropped by a store opened with WithMaxRecords.
+	ErrEvicted = errors.New("record evicted")
+	// ErrLocked is returned when another process holds a conflicting lock on the store.
+	ErrLocked = errors.New("store is locked by another process")
+	// ErrBadMagic is returned when opening a file that is not a store, or whose header is truncated.
+	ErrBadMagic = errors.New("not a linestore file")
+	// ErrUnsupportedVersion is returned when a store uses a newer format or unknown features.
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+	// ErrConflict is returned by AppendIf when another line was appended since the caller
+	// last looked.
+	ErrConflict = errors.New("append conflict")
+	// ErrEmpty is returned by First and Last when the store has no live lines, and by
+	// GetLastLine when it has no lines at all.
+	ErrEmpty = errors.New("store has no live lines")
+	// ErrNotFound is returned when a line has no index entry. Errors wrapping
+	// ErrOutOfRange match it as well.
+	ErrNotFound = errors.New("line not found")
+	// ErrOutOfRange is returned when a line is past the last line of the store.
+	ErrOutOfRange = fmt.Errorf("line out of range: %w", ErrNotFound)
+	// ErrInvalidRecord is returned when a record has an unknown type or a value length
+	// the store can't hold, which means the data file is damaged or the index points
+	// into the middle of a record.
+	ErrInvalidRecord = errors.New("invalid record")
+	// ErrClosed is returned by methods called on a store after Close.
+	ErrClosed = errors.New("store is closed")
+	// ErrDiskFull is returned, wrapping the underlying error, when a write fails because
+	// the disk ran out of space. The partial write has been rolled back.
+	ErrDiskFull = errors.New("disk full")
+	// ErrQuotaExceeded is returned when a write would grow the data file past the limit
+	// set with WithMaxStoreSize. Nothing has been written.
+	ErrQuotaExceeded = errors.New("store size limit exceeded")
+	// ErrIndexCorrupt is returned when an index entry doesn't match its checksum, in
+	// stores created with WithIndexChecksums. The error names the line; RebuildIndex
+	// rewrites the index from the data file.
+	ErrIndexCorrupt = errors.New("index entry corrupt")
+	// ErrWriteVerify is returned by stores opened with WithWriteVerify when a value just
+	// written doesn't read back the same. The line has been added all the same.
+	ErrWriteVerify = errors.New("written value does not read back")
 )
 
 // Store represents the line/value store with on-disk persistence.
 type Store struct {
-	file      *os.File // File handle for the database
-	indexFile *os.File // File handle for the index
-	lineCount uint64   // Tracks total lines written
+	path      string      // Path of the data file, empty for memory stores
+	file      backend     // Storage for the data file
+	indexFile backend     // Storage for the index
+	lineCount uint64      // Tracks total lines written
+	baseLine  uint64      // Line number of the first record in the files; earlier lines were compacted away
+	evictLine uint64      // First line not evicted by WithMaxRecords
+	format    format      // Layout of the data file, read from its header
+	offsets   []uint64    // In-memory copy of the index offsets, only with WithMemoryIndex
+	hashes    dedupMap    // Offsets of live records by value hash, only with WithDedup
+	hashID    string      // Fingerprint of the hash set with WithHash; see HashID
+	aead      cipher.AEAD // Cipher for encrypted stores
+	opts      options     // Settings the store was opened with
+	retry     *writeRetry // Retry policy for the store's files, from WithWriteRetry
+	readOnly  bool        // Set by OpenReadOnly; rejects all writes
+	closed    bool        // Set by Close; rejects all reads and writes
 	mu        sync.RWMutex
+
+	deadBytes     int64         // Estimated bytes of dead records, counted with WithAutoPolish
+	autoPolishing bool          // A background compaction is pending
+	periodic      *periodicSync // Background fsync started by WithPeriodicSync, nil for none
+	backups       *autoBackup   // Background backups started by WithAutoBackup, nil for none
+
+	syncFailure atomic.Pointer[error] // First failed fsync, kept with WithReadOnlyOnSyncError
+	lastBackup  atomic.Int64          // Unix nanoseconds of the last WithAutoBackup backup, 0 for none
+
+	rewrites      uint64                     // Counts the times the files were rewritten rather than appended to; see HotBackup
+	copies        hotCopies                  // Data files replaced while HotBackup copies from them
+	sparseUpdates map[uint64]uint64          // Offsets of the last update record of updated lines, only with a sparse index
+	sparseHint    atomic.Pointer[sparseHint] // Where the last sparse lookup found a line's record
+
+	subscribers subscribers                  // Channels returned by Subscribe
+	commits     commitGroup                  // Set calls waiting for a shared fsync, with WithCommitInterval
+	metrics     atomic.Pointer[storeMetrics] // Set by RegisterMetrics; atomic so counting needs no lock
+}
+
+// NewStore initializes or opens a store at the given file path. It takes an exclusive
+// advisory lock on the data file until Close and returns ErrLocked if another process
+// has the store open. Without options the store uses 0666 permissions, a 1 MiB value limit, and fsyncs every write.
+func NewStore(path string, opts ...Option) (*Store, error) {
+	return openStore(path, os.O_RDWR|os.O_CREATE, opts)
+}
+
+// OpenReadOnly opens an existing store without acquiring write handles, so it works on
+// read-only filesystems and alongside a process that owns the files. Reads behave as
+// usual while Set, SetBatch, Update, Delete, and Polish return ErrReadOnly. No lock is
+// taken unless WithReadLock is given.
+func OpenReadOnly(path string, opts ...Option) (*Store, error) {
+	return openStore(path, os.O_RDONLY, opts)
+}
+
+// NewMemoryStore creates an empty store that keeps its data and index in memory.
+// It behaves like a file-backed store, including Polish, but nothing touches the
+// disk and the contents are lost on Close. Backup still writes to the given path.
+func NewMemoryStore(opts ...Option) (*Store, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	store := &Store{
+		file:      &memBackend{},
+		indexFile: &memBackend{},
+		opts:      o,
+	}
+	err := store.load()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
 }
 
-// NewStore initializes or opens a store at the given file path.
-func NewStore(path string) (*Store, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+// openStore opens the data and index files with flag and validates them.
+func openStore(path string, flag int, opts []Option) (*Store, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	file, err := os.OpenFile(path, flag, o.fileMode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open data file: %v", err)
 	}
+	retry := newWriteRetry(o)
+	data := &fileBackend{File: file, retry: retry}
+
+	readOnly := flag == os.O_RDONLY
+	if !readOnly || o.readLock {
+		err = lockFile(file, !readOnly)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		data.locked = true
+	}
+
+	index, err := openIndex(path, flag, o, retry)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	store := &Store{
+		path:      path,
+		file:      data,
+		indexFile: index,
+		lineCount: 0,
+		opts:      o,
+		retry:     retry,
+		readOnly:  readOnly,
+	}
+	err = store.load()
+	if err != nil {
+		return nil, err
+	}
+	if o.periodicSync > 0 && !readOnly {
+		store.startPeriodicSync(o.periodicSync)
+	}
+	if o.backupInterval > 0 {
+		store.startAutoBackup(o.backupInterval)
+	}
+	return store, nil
+}
+
+// load reads the header, counts the lines, and loads the index if requested. On
+// failure both backends are closed.
+func (s *Store) load() error {
+	s.hashID = hashFingerprint(s.newHash)
+	err := s.loadFormat()
+	if err == nil && s.path != "" && (s.opts.segmentSize > 0 || s.format.flags&flagSegmented != 0) {
+		err = s.loadSegments()
+	}
+	if err == nil {
+		err = s.countLines()
+		if err != nil {
+			err = fmt.Errorf("failed to count lines: %v", err)
+		}
+	}
+	if err == nil && s.opts.memoryIndex {
+		err = s.loadOffsets()
+	}
+	if err == nil && s.opts.dedup && !s.readOnly {
+		err = s.loadHashes()
+	}
+	if err == nil && s.opts.autoPolish > 0 && !s.readOnly {
+		var st Stats
+		st, err = s.Stats()
+		s.deadBytes = st.DeadBytes
+	}
+	if err != nil {
+		s.file.Close()
+		s.indexFile.Close()
+		return err
+	}
+	s.mapData()
+	return nil
+}
+
+// mapData switches a file-backed data file over to reads through a memory mapping when
+// the store was opened with WithMmap. Segmented data files are left alone.
+func (s *Store) mapData() {
+	if f, ok := s.file.(*fileBackend); ok && s.opts.mmap && mmapSupported {
+		s.file = &mmapBackend{fileBackend: f}
+	}
+}
+
+// loadSegments switches the data file over to a segmented backend.
+func (s *Store) loadSegments() error {
+	if s.format.headerLen() == 0 {
+		return fmt.Errorf("segments are not supported by this store's format")
+	}
+	flag := os.O_RDWR
+	if s.readOnly {
+		flag = os.O_RDONLY
+	}
+	sb, err := openSegments(s.file, s.path, flag, s.opts.fileMode, s.format.flags&flagSegmented != 0)
+	if err != nil {
+		return err
+	}
+	s.file = sb
+	return nil
+}
+
+// name describes the store in log messages.
+func (s *Store) name() string {
+	if s.path == "" {
+		return "memory store"
+	}
+	return s.path
+}
+
+// loadOffsets reads every index entry into memory for WithMemoryIndex.
+func (s *Store) loadOffsets() error {
+	index := make([]byte, s.indexPos(s.lineCount))
+	_, err := s.indexFile.ReadAt(index, 0)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to load index: %v", err)
+	}
+	entrySize := int(s.format.indexEntrySize())
+	s.offsets = make([]uint64, s.lineCount-s.baseLine)
+	for line := range s.offsets {
+		indexEntry := index[line*entrySize : (line+1)*entrySize]
+		if !s.format.entryIntact(indexEntry) {
+			return fmt.Errorf("index entry for line %d: %w", s.baseLine+uint64(line), ErrIndexCorrupt)
+		}
+		s.offsets[line] = s.format.entryOffset(indexEntry)
+	}
+	return nil
+}
+
+// loadFormat reads the data file header. A new, empty data file gets a header with
+// the current format; a file that doesn't start with the magic bytes predates headers.
+func (s *Store) loadFormat() error {
+	size, err := s.file.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat data file: %v", err)
+	}
+	if size == 0 {
+		if !s.opts.compression.valid() {
+			return fmt.Errorf("unsupported compression codec %d", byte(s.opts.compression))
+		}
+		s.format = newFormat(s.opts)
+		if s.format.flags&flagLargeValues != 0 && s.format.codec != CompressionNone {
+			return fmt.Errorf("WithLargeValues can't be combined with compression")
+		}
+		if s.format.flags&flagSparse != 0 {
+			err = s.checkSparse()
+			if err != nil {
+				return err
+			}
+		}
+		err = s.loadCipher()
+		if err != nil {
+			return err
+		}
+		if s.readOnly {
+			return nil
+		}
+		_, err = s.file.WriteAt(s.header(), 0)
+		if err != nil {
+			return fmt.Errorf("failed to write header: %v", err)
+		}
+		err = s.sync(s.file)
+		if err != nil {
+			return fmt.Errorf("failed to sync data file: %v", err)
+		}
+		return nil
+	}
+
+	header := make([]byte, headerSize)
+	n, err := s.file.ReadAt(header, 0)
+	if n < len(headerMagic) || string(header[:len(headerMagic)]) != headerMagic {
+		if n >= 1 && validRecordType(header[0]) {
+			// Headerless file written before the header was introduced
+			if s.opts.maxRecords > 0 {
+				return fmt.Errorf("WithMaxRecords is not supported by this store's format")
+			}
+			if s.opts.dedup {
+				return fmt.Errorf("WithDedup is not supported by this store's format")
+			}
+			s.format = format{}
+			return s.loadCipher()
+		}
+		return ErrBadMagic
+	}
+	if n < headerSize {
+		return fmt.Errorf("truncated header (%d bytes): %w", n, ErrBadMagic)
+	}
+	s.format, err = decodeHeader(header)
+	if err != nil {
+		return err
+	}
+	if s.format.flags&flagBaseLine != 0 {
+		s.baseLine = binary.LittleEndian.Uint64(header[12:20])
+		s.evictLine = binary.LittleEndian.Uint64(header[20:28])
+	}
+	if s.opts.dedup && s.format.flags&flagShared == 0 && !s.readOnly {
+		// From now on lines may share records, which changes how the file is read
+		s.format.flags |= flagShared
+		_, err = s.file.WriteAt(s.header(), 0)
+		if err != nil {
+			return fmt.Errorf("failed to update header: %v", err)
+		}
+	}
+	return s.loadCipher()
+}
+
+// header builds the data file header for the store's format and line numbering.
+func (s *Store) header() []byte {
+	return s.format.encodeLineHeader(s.baseLine, s.evictLine)
+}
+
+// indexPos returns the position of line's entry in the index file.
+func (s *Store) indexPos(line uint64) int64 {
+	every := s.format.indexEvery()
+	return int64((line-s.baseLine+every-1)/every) * s.format.indexEntrySize()
+}
+
+// loadCipher sets up encryption, checking the key against the header's encryption flag.
+func (s *Store) loadCipher() error {
+	if !s.format.encrypted() {
+		if s.opts.encryptionKey != nil {
+			return fmt.Errorf("store was not created with encryption")
+		}
+		return nil
+	}
+	if s.opts.encryptionKey == nil {
+		return fmt.Errorf("store is encrypted and no key was given: %w", ErrDecrypt)
+	}
+	aead, err := newAEAD(s.opts.encryptionKey)
+	if err != nil {
+		return err
+	}
+	s.aead = aead
+	return nil
+}
+
+// countLines determines the total number of records in the file and validates the index.
+// With WithRecovery, an interrupted trailing write is truncated away instead.
+func (s *Store) countLines() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ranges, err := s.dataRanges()
+	if err != nil {
+		return fmt.Errorf("failed to stat data file: %v", err)
+	}
+	indexSize, err := s.indexFile.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat index file: %v", err)
+	}
+	dataEnd := ranges[len(ranges)-1][1]
+	entrySize := s.format.indexEntrySize()
+	indexEntries := uint64(indexSize / entrySize)
+
+	lineNum := uint64(0)
+	var orphans []int64 // Offsets of line records without an index entry
+	var updates map[uint64]uint64
+	if s.format.flags&flagSparse != 0 {
+		updates = make(map[uint64]uint64) // Last update record of each line
+	}
+	offset := ranges[0][0]
+	prefix := make([]byte, maxPrefixLen)
+scan:
+	for _, r := range ranges {
+		for offset = r[0]; offset < r[1]; {
+			n, err := s.file.ReadAt(prefix, offset)
+			if n < 1 {
+				return fmt.Errorf("failed to read type byte: %v", err)
+			}
+			typeByte := prefix[0]
+			if !validRecordType(typeByte) {
+				return fmt.Errorf("invalid record type %d at line %d: %w", typeByte, lineNum, ErrInvalidRecord)
+			}
+			prefixLen := s.format.prefixLen(typeByte)
+			if int64(n) < prefixLen {
+				break scan
+			}
+			valLen := s.format.valueLen(prefix)
+			next := offset + prefixLen + valLen + s.format.trailerLen()
+			if valLen < 0 || next > r[1] {
+				break scan
+			}
+			// Update records replace an existing line and don't add a new one
+			if typeByte&recordUpdate == 0 {
+				if lineNum >= indexEntries {
+					orphans = append(orphans, offset)
+				}
+				lineNum++
+			} else if updates != nil {
+				updates[binary.LittleEndian.Uint64(prefix[1:9])] = uint64(offset)
+			}
+			offset = next
+		}
+	}
+
+	if updates != nil {
+		return s.loadSparse(ranges, offset, lineNum, updates)
+	}
+	if s.opts.singleFile {
+		return s.loadSingleFile(ranges, offset, lineNum)
+	}
+	if s.format.flags&flagShared != 0 {
+		// Lines may share records, so only the index tells how many there are. A record
+		// whose index entry was never written stays behind as dead space.
+		lineNum = indexEntries
+		orphans = nil
+	}
+
+	expectedSize := int64(lineNum) * entrySize
+	if offset == dataEnd && indexSize == expectedSize {
+		s.lineCount = s.baseLine + lineNum
+		return nil
+	}
+	if s.opts.autoReindex && !s.readOnly && offset == dataEnd && s.format.flags&flagShared == 0 {
+		// The records are intact, so the index can be rebuilt from them
+		return s.rebuildIndex(ranges, lineNum)
+	}
+	if !s.opts.recovery || s.readOnly {
+		if offset < dataEnd {
+			return fmt.Errorf("incomplete record at offset %d", offset)
+		}
+		return fmt.Errorf("index file size %d does not match expected %d", indexSize, expectedSize)
+	}
+
+	// Drop the incomplete record, then either drop the records the index never
+	// acknowledged or index them
+	if len(orphans) > 0 && s.opts.orphanPolicy == OrphanDiscard {
+		offset = orphans[0]
+		lineNum = indexEntries
+		orphans = nil
+	}
+	expectedSize = int64(lineNum) * entrySize
+	keptIndex := expectedSize - int64(len(orphans))*entrySize
+	err = s.file.Truncate(offset)
+	if err != nil {
+		return fmt.Errorf("failed to truncate data file: %v", err)
+	}
+	err = s.indexFile.Truncate(keptIndex)
+	if err != nil {
+		return fmt.Errorf("failed to truncate index file: %v", err)
+	}
+	var index []byte
+	for i, orphan := range orphans {
+		index = append(index, s.format.encodeIndexEntry(s.baseLine+indexEntries+uint64(i), uint64(orphan))...)
+	}
+	_, err = s.indexFile.WriteAt(index, keptIndex)
+	if err != nil {
+		return fmt.Errorf("failed to write recovered index entries: %v", err)
+	}
+	err = s.sync(s.file)
+	if err != nil {
+		return fmt.Errorf("failed to sync data file: %v", err)
+	}
+	err = s.sync(s.indexFile)
+	if err != nil {
+		return fmt.Errorf("failed to sync index file: %v", err)
+	}
+	s.opts.logger.Printf("linestore: recovered store=%q discarded_data_bytes=%d discarded_index_bytes=%d reindexed_records=%d",
+		s.name(), dataEnd-offset, max(indexSize-keptIndex, 0), len(orphans))
+
+	s.lineCount = s.baseLine + lineNum
+	return nil
+}
+
+// Set appends a value to the store and updates the index file.
+//
+// The record is written and synced before its index entry, and the index entry is
+// synced before Set returns, so a value is durable once Set returns without error
+// (unless the store uses SyncNone). If Set fails, the files are truncated back to
+// their previous size, and a write that fails because the disk is full returns an
+// error wrapping ErrDiskFull. A crash during Set can leave a partial record or a
+// record without an index entry; such a store only opens with WithRecovery, which
+// discards the unfinished write or, with OrphanReindex, keeps a complete record.
+func (s *Store) Set(value []byte) (uint64, error) {
+	span := s.startSpan(context.Background(), "linestore.Set")
+	line, err := s.set(value)
+	s.observe(opSet, 1, err)
+	if err == nil {
+		span.SetAttribute("linestore.line", int64(line))
+	}
+	span.SetAttribute("linestore.bytes", int64(len(value)))
+	endSpan(span, err)
+	return line, err
+}
+
+// set implements Set.
+func (s *Store) set(value []byte) (uint64, error) {
+	if s.opts.commitInterval > 0 && s.opts.syncMode == SyncAlways {
+		return s.setGroupCommit(value)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendValue(value, 0, true)
+}
+
+// NoLine is the last line of an empty store, for use with AppendIf.
+const NoLine = ^uint64(0)
+
+// AppendIf appends value like Set, but only if the store's last line is still
+// expectedLastLine, which is NoLine for an empty store. Otherwise it returns ErrConflict
+// and writes nothing. The check and the append happen under the write lock, so of
+// several writers that read the same last line only one succeeds. AppendIf does not
+// share fsyncs with WithCommitInterval.
+func (s *Store) AppendIf(expectedLastLine uint64, value []byte) (uint64, error) {
+	line, err := s.appendIf(expectedLastLine, value)
+	s.observe(opSet, 1, err)
+	return line, err
+}
+
+// appendIf implements AppendIf.
+func (s *Store) appendIf(expectedLastLine uint64, value []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+	if s.lineCount-1 != expectedLastLine {
+		return 0, fmt.Errorf("expected last line %d, store has %d lines: %w", expectedLastLine, s.lineCount, ErrConflict)
+	}
+	return s.appendValue(value, 0, true)
+}
+
+// appendValue writes value as a new record with the given user flags and index entry.
+// The caller must hold the write lock. With durable set, both files are synced before
+// it returns.
+func (s *Store) appendValue(value []byte, userFlags byte, durable bool) (uint64, error) {
+	err := s.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.checkValueSize(value)
+	if err != nil {
+		return 0, err
+	}
+
+	// Records with user flags are never shared, since lines sharing a record share its flags
+	dedup := s.hashes != nil && userFlags == 0
+	var sum string
+	if dedup {
+		sum = s.hashValue(value)
+		if shared, ok := s.sharedRecord(sum, value); ok {
+			// Point the new line at the existing copy of the value
+			dataEnd, err := s.file.Size()
+			if err != nil {
+				return 0, fmt.Errorf("failed to stat data file: %v", err)
+			}
+			return s.commitRecord(int64(shared), dataEnd, durable)
+		}
+	}
+
+	// Write to data file
+	payload, err := s.encodeValue(value)
+	if err != nil {
+		return 0, err
+	}
+	record := s.format.encodeRecord(recordActive, 0, userFlags, time.Now().UnixNano(), payload)
+	err = s.checkQuota(int64(len(record)))
+	if err != nil {
+		return 0, err
+	}
+
+	dataOffset, err := s.appendOffset()
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.file.WriteAt(record, dataOffset)
+	if err != nil {
+		s.rollback(dataOffset, s.indexPos(s.lineCount))
+		return 0, writeError("failed to write record", err)
+	}
+	s.countWritten(len(record))
+	if dedup {
+		// Registered before committing, since an eviction may compact and remap the hashes
+		s.hashes[sum] = uint64(dataOffset)
+	}
+	line, err := s.commitRecord(dataOffset, dataOffset, durable)
+	if err != nil && dedup {
+		delete(s.hashes, sum)
+	}
+	if err == nil && s.opts.writeVerify {
+		err = s.verifyWrite(line, value)
+	}
+	return line, err
+}
+
+// verifyWrite reads line back through the index for WithWriteVerify and checks that it
+// holds value. The caller must hold the write lock.
+func (s *Store) verifyWrite(line uint64, value []byte) error {
+	dataOffset, err := s.readIndexOffset(line)
+	if err != nil {
+		return fmt.Errorf("failed to verify line %d: %v: %w", line, err, ErrWriteVerify)
+	}
+	_, stored, err := s.readRecord(int64(dataOffset), line, false)
+	if err != nil {
+		return fmt.Errorf("failed to verify line %d: %v: %w", line, err, ErrWriteVerify)
+	}
+	if !bytes.Equal(stored, value) {
+		return fmt.Errorf("line %d: %w", line, ErrWriteVerify)
+	}
+	return nil
+}
+
+// commitRecord adds the index entry for the record at dataOffset as the next line,
+// syncing both files first if durable is set. On failure both files are truncated
+// back to their previous size, with dataEnd being the data file's size before the
+// record was written.
+func (s *Store) commitRecord(dataOffset, dataEnd int64, durable bool) (uint64, error) {
+	lineNum := s.lineCount
+	indexStart := s.indexPos(lineNum)
+	if durable {
+		err := s.sync(s.file)
+		if err != nil {
+			s.rollback(dataEnd, indexStart)
+			return 0, writeError("failed to sync data file", err)
+		}
+	}
+
+	// Write to index file, unless a sparse index skips the line
+	indexEntry := s.indexEntry(lineNum, uint64(dataOffset))
+	if indexEntry != nil {
+		_, err := s.indexFile.WriteAt(indexEntry, indexStart)
+		if err != nil {
+			s.rollback(dataEnd, indexStart)
+			return 0, writeError("failed to write index entry", err)
+		}
+		if durable {
+			err = s.sync(s.indexFile)
+			if err != nil {
+				s.rollback(dataEnd, indexStart)
+				return 0, writeError("failed to sync index file", err)
+			}
+		}
+	}
+
+	if s.offsets != nil {
+		s.offsets = append(s.offsets, uint64(dataOffset))
+	}
+	s.lineCount++
+	s.notify(EventSet, lineNum)
+	err := s.evict(durable)
+	s.updateGauges()
+	return lineNum, err
+}
+
+// evict drops the oldest lines once a store opened with WithMaxRecords holds more than
+// its limit, recording the first kept line in the header. When the evicted lines make
+// up as many index entries as the kept ones, the store is compacted inline so the
+// files stay bounded. The caller must hold the write lock.
+func (s *Store) evict(durable bool) error {
+	limit := s.opts.maxRecords
+	if limit == 0 || s.lineCount-s.evictLine <= limit {
+		return nil
+	}
+
+	s.evictLine = s.lineCount - limit
+	_, err := s.file.WriteAt(s.header(), 0)
+	if err != nil {
+		return fmt.Errorf("failed to update header: %v", err)
+	}
+	if durable {
+		err = s.sync(s.file)
+		if err != nil {
+			return fmt.Errorf("failed to sync data file: %v", err)
+		}
+	}
+
+	if s.evictLine-s.baseLine < limit {
+		return nil
+	}
+	err = s.polishLocked(context.Background(), PolishOptions{SkipBackup: true})
+	if err != nil {
+		return fmt.Errorf("failed to compact evicted lines: %v", err)
+	}
+	return nil
+}
+
+// SetReader appends a value of exactly size bytes read from r and returns its line number.
+// On a plain store the value is streamed into the data file in fixed-size chunks, so large
+// values never need to fit in memory. Compressed and encrypted stores must encode the value
+// as a whole, and WithDedup must hash it before deciding whether to write it, so there it
+// is read into memory first. If r returns fewer than size bytes, the partial record is
+// truncated away and an error is returned.
+func (s *Store) SetReader(r io.Reader, size uint32) (uint64, error) {
+	line, err := s.setReader(r, size)
+	s.observe(opSet, 1, err)
+	return line, err
+}
+
+// setReader implements SetReader.
+func (s *Store) setReader(r io.Reader, size uint32) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+	if uint64(size) > s.valueLimit() {
+		return 0, fmt.Errorf("value of %d bytes exceeds limit of %d: %w", size, s.valueLimit(), ErrValueTooLarge)
+	}
+
+	if s.format.codec != CompressionNone || s.aead != nil || s.hashes != nil {
+		value := make([]byte, size)
+		_, err := io.ReadFull(r, value)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read value: %v", err)
+		}
+		return s.appendValue(value, 0, true)
+	}
+	err = s.checkQuota(s.format.prefixLen(recordActive) + int64(size) + s.format.trailerLen())
+	if err != nil {
+		return 0, err
+	}
+
+	dataOffset, err := s.appendOffset()
+	if err != nil {
+		return 0, err
+	}
+	indexStart := s.indexPos(s.lineCount)
+
+	w := io.NewOffsetWriter(s.file, dataOffset)
+	prefix := make([]byte, s.format.prefixLen(recordActive))
+	s.format.putPrefix(prefix, recordActive, 0, 0, time.Now().UnixNano(), uint64(size))
+	_, err = w.Write(prefix)
+	if err != nil {
+		s.rollback(dataOffset, indexStart)
+		return 0, writeError("failed to write record", err)
+	}
+
+	checksum := crc32.NewIEEE()
+	buf := make([]byte, streamChunkSize)
+	n, err := io.CopyBuffer(io.MultiWriter(w, checksum), io.LimitReader(r, int64(size)), buf)
+	if err == nil && n < int64(size) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		s.rollback(dataOffset, indexStart)
+		return 0, writeError(fmt.Sprintf("failed to stream value (%d/%d bytes)", n, size), err)
+	}
+
+	if s.format.checksums() {
+		_, err = w.Write(binary.LittleEndian.AppendUint32(nil, checksum.Sum32()))
+		if err != nil {
+			s.rollback(dataOffset, indexStart)
+			return 0, writeError("failed to write checksum", err)
+		}
+	}
+	s.countWritten(len(prefix) + int(size) + int(s.format.trailerLen()))
+	return s.commitRecord(dataOffset, dataOffset, true)
+}
+
+// SetBatch appends all values to the store and returns their line numbers in order.
+// Records and index entries are written first and each file is synced once at the end,
+// which makes bulk ingestion much cheaper than calling Set in a loop. If a write fails
+// partway, both files are truncated back to their previous size.
+func (s *Store) SetBatch(values [][]byte) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return nil, err
+	}
+	lines, err := s.setBatch(values)
+	s.observe(opSet, len(values), err)
+	return lines, err
+}
+
+// ImportAll appends all values for seeding a store and returns their line numbers in
+// order. Every value is checked before anything is written, records go out in chunks of
+// about 1 MiB with a single sync of each file at the end, and if any write or sync fails
+// both files are truncated back to their state before the import, leaving no line
+// behind. Subscribers are only notified once the whole import is durable. With
+// WithMaxRecords, evicting old lines can still fail after that; the error is then
+// returned along with the imported lines, which stay in place.
+func (s *Store) ImportAll(values [][]byte) ([]uint64, error) {
+	return s.SetBatch(values)
+}
+
+// setBatch implements SetBatch. The caller must hold the write lock.
+func (s *Store) setBatch(values [][]byte) ([]uint64, error) {
+	if len(values) == 0 {
+		return []uint64{}, nil
+	}
+	for _, value := range values {
+		err := s.checkValueSize(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	quota, err := s.quotaLeft()
+	if err != nil {
+		return nil, err
+	}
+	dataStart, err := s.appendOffset()
+	if err != nil {
+		return nil, err
+	}
+	indexStart := s.indexPos(s.lineCount)
+
+	var data, index []byte
+	lines := make([]uint64, len(values))
+	dataOffset := dataStart
+	written := time.Now().UnixNano()
+	added := make(map[string]uint64) // Hashes of values written by this batch
+	addedValues := make(map[string][]byte)
+	for i, value := range values {
+		lines[i] = s.lineCount + uint64(i)
+		if s.hashes != nil {
+			sum := s.hashValue(value)
+			shared, ok := s.sharedRecord(sum, value)
+			if !ok {
+				shared, ok = added[sum]
+				ok = ok && bytes.Equal(addedValues[sum], value)
+			}
+			if ok {
+				index = append(index, s.format.encodeIndexEntry(lines[i], shared)...)
+				continue
+			}
+			if _, taken := added[sum]; !taken {
+				added[sum] = uint64(dataOffset)
+				addedValues[sum] = value
+			}
+		}
+		payload, err := s.encodeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		record := s.format.encodeRecord(recordActive, 0, 0, written, payload)
+		if grown := dataOffset - dataStart + int64(len(record)); grown > quota {
+			// Earlier chunks of the batch may already be written
+			s.rollback(dataStart, indexStart)
+			return nil, quotaError(grown, quota)
+		}
+		data = append(data, record...)
+		index = append(index, s.indexEntry(lines[i], uint64(dataOffset))...)
+		dataOffset += int64(len(record))
+
+		if len(data) >= batchChunkSize {
+			_, err = s.file.WriteAt(data, dataOffset-int64(len(data)))
+			if err != nil {
+				s.rollback(dataStart, indexStart)
+				return nil, writeError("failed to write records", err)
+			}
+			data = data[:0]
+		}
+	}
+
+	_, err = s.file.WriteAt(data, dataOffset-int64(len(data)))
+	if err != nil {
+		s.rollback(dataStart, indexStart)
+		return nil, writeError("failed to write records", err)
+	}
+	_, err = s.indexFile.WriteAt(index, indexStart)
+	if err != nil {
+		s.rollback(dataStart, indexStart)
+		return nil, writeError("failed to write index entries", err)
+	}
+	err = s.sync(s.file)
+	if err != nil {
+		s.rollback(dataStart, indexStart)
+		return nil, writeError("failed to sync data file", err)
+	}
+	err = s.sync(s.indexFile)
+	if err != nil {
+		s.rollback(dataStart, indexStart)
+		return nil, writeError("failed to sync index file", err)
+	}
+
+	if s.offsets != nil {
+		entrySize := int(s.format.indexEntrySize())
+		for i := range lines {
+			s.offsets = append(s.offsets, s.format.entryOffset(index[i*entrySize:(i+1)*entrySize]))
+		}
+	}
+	s.lineCount += uint64(len(values))
+	for sum, offset := range added {
+		s.hashes[sum] = offset
+	}
+	for _, line := range lines {
+		s.notify(EventSet, line)
+	}
+	s.countWritten(int(dataOffset - dataStart))
+	err = s.evict(true)
+	s.updateGauges()
+	return lines, err
+}
+
+// mergeBatchSize is the number of records Merge appends per sync.
+const mergeBatchSize = 1024
+
+// Merge appends every live record of other to the store and returns the line numbers
+// they were assigned, in other's line order. Line numbers are reassigned, not preserved,
+// and deleted records in other are skipped. Records are appended in batches that are
+// synced once each; if a batch fails, the records of earlier batches stay appended.
+// other is read-locked and s write-locked for the whole merge, so two stores must not
+// be merged into each other concurrently.
+func (s *Store) Merge(other *Store) ([]uint64, error) {
+	if other == s {
+		return nil, fmt.Errorf("cannot merge a store into itself")
+	}
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]uint64, 0, other.lineCount-other.evictLine)
+	batch := make([][]byte, 0, mergeBatchSize)
+	flush := func() error {
+		batchLines, err := s.setBatch(batch)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, batchLines...)
+		batch = batch[:0]
+		return nil
+	}
+	for line := other.evictLine; line < other.lineCount; line++ {
+		typeByte, value, err := other.readLine(line)
+		if err != nil {
+			return lines, err
+		}
+		if typeByte&recordDeleted != 0 {
+			continue
+		}
+		batch = append(batch, value)
+		if len(batch) == mergeBatchSize {
+			err = flush()
+			if err != nil {
+				return lines, err
+			}
+		}
+	}
+	err = flush()
+	if err != nil {
+		return lines, err
+	}
+	return lines, nil
+}
+
+// checkValueSize rejects values larger than the configured maximum value size.
+func (s *Store) checkValueSize(value []byte) error {
+	if uint64(len(value)) > s.valueLimit() {
+		return fmt.Errorf("value of %d bytes exceeds limit of %d: %w", len(value), s.valueLimit(), ErrValueTooLarge)
+	}
+	return nil
+}
+
+// valueLimit returns the largest value the store accepts: the WithMaxValueSize limit,
+// unless the store has large values and none was given.
+func (s *Store) valueLimit() uint64 {
+	if s.format.flags&flagLargeValues != 0 && !s.opts.maxValueSet {
+		return maxLargeValueSize
+	}
+	return uint64(s.opts.maxValueSize)
+}
+
+// encodeValue converts a value to the bytes stored on disk.
+func (s *Store) encodeValue(value []byte) ([]byte, error) {
+	payload, err := s.format.codec.compress(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress value: %v", err)
+	}
+	if s.aead != nil {
+		payload, err = encrypt(s.aead, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// decodeValue converts the bytes stored on disk back to the value.
+func (s *Store) decodeValue(payload []byte, line uint64) ([]byte, error) {
+	if s.aead != nil {
+		var err error
+		payload, err = decrypt(s.aead, payload)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	value, err := s.format.codec.decompress(payload, s.opts.maxValueSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress value at line %d: %w", line, err)
+	}
+	return value, nil
+}
+
+// payloadLimit returns the largest stored value length accepted when reading,
+// allowing for codec and encryption overhead on top of the maximum value size.
+func (s *Store) payloadLimit() uint64 {
+	limit := s.valueLimit() + uint64(s.format.codec.maxOverhead(s.opts.maxValueSize))
+	if s.format.encrypted() {
+		limit += encryptionOverhead
+	}
+	return limit
+}
+
+// sync fsyncs f unless the store was opened with SyncNone.
+func (s *Store) sync(f backend) error {
+	if s.opts.syncMode == SyncNone {
+		return nil
+	}
+	return s.syncFile(f)
+}
+
+// syncFile fsyncs f regardless of the sync mode, logging a failure and reporting it
+// as WithSyncErrorHandler and WithReadOnlyOnSyncError ask.
+func (s *Store) syncFile(f backend) error {
+	err := f.Sync()
+	if err != nil {
+		s.opts.logger.Printf("linestore: fsync failed store=%q err=%q", s.name(), err)
+		if s.opts.syncErrorStop {
+			// Set atomically, since background syncs only hold the read lock
+			s.syncFailure.CompareAndSwap(nil, &err)
+		}
+		if s.opts.onSyncError != nil {
+			s.opts.onSyncError(err)
+		}
+	}
+	return err
+}
+
+// Flush fsyncs the data and index files. With SyncNone, writes are only durable once
+// a later Flush (or Close) returns, so callers should flush at points where losing the
+// preceding writes in a crash would be unacceptable. With SyncAlways it is a no-op in
+// practice, since every write is already synced.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if s.readOnly {
+		return nil
+	}
+	err := s.syncFile(s.file)
+	if err != nil {
+		return fmt.Errorf("failed to sync data file: %v", err)
+	}
+	err = s.syncFile(s.indexFile)
+	if err != nil {
+		return fmt.Errorf("failed to sync index file: %v", err)
+	}
+	return nil
+}
+
+// rollback truncates the data and index files back to the given sizes after a failed write.
+func (s *Store) rollback(dataSize, indexSize int64) {
+	s.file.Truncate(dataSize)
+	s.indexFile.Truncate(indexSize)
+}
+
+// checkWritable returns ErrClosed once the store is closed and ErrReadOnly for a
+// store opened with OpenReadOnly. The caller must hold the lock.
+func (s *Store) checkWritable() error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if failed := s.syncFailure.Load(); failed != nil {
+		return fmt.Errorf("%w after a failed fsync: %w", ErrReadOnly, *failed)
+	}
+	return nil
+}
+
+// writeError describes a failed write or sync as msg. When the disk ran out of space,
+// the error wraps ErrDiskFull as well as err.
+func writeError(msg string, err error) error {
+	if isDiskFull(err) {
+		return fmt.Errorf("%s: %w: %w", msg, ErrDiskFull, err)
+	}
+	return fmt.Errorf("%s: %v", msg, err)
+}
+
+// Get retrieves the value at the specified line number using the index file.
+// On a store opened with WithMmap the value may be a slice of the mapped data file,
+// valid only until the next write or Close.
+func (s *Store) Get(line uint64) ([]byte, error) {
+	return s.GetContext(context.Background(), line)
+}
+
+// GetContext is like Get but returns ctx's error if ctx is done before the read starts.
+func (s *Store) GetContext(ctx context.Context, line uint64) ([]byte, error) {
+	span := s.startSpan(ctx, "linestore.Get")
+	span.SetAttribute("linestore.line", int64(line))
+	value, err := s.get(ctx, line)
+	span.SetAttribute("linestore.bytes", int64(len(value)))
+	endSpan(span, err)
+	return value, err
+}
+
+// get implements GetContext.
+func (s *Store) get(ctx context.Context, line uint64) ([]byte, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	value, err := s.getLine(line, s.lineCount)
+	s.observe(opGet, 1, err)
+	return value, err
+}
+
+// getLine returns the live value at line, treating lines at or past lineCount as out of range.
+func (s *Store) getLine(line, lineCount uint64) ([]byte, error) {
+	if line >= lineCount {
+		return nil, fmt.Errorf("line %d exceeds total lines %d: %w", line, lineCount, ErrOutOfRange)
+	}
+
+	typeByte, value, err := s.borrowLine(line)
+	if err != nil {
+		return nil, err
+	}
+	if typeByte&recordDeleted != 0 {
+		return nil, deletedError(typeByte, line)
+	}
+	return value, nil
+}
+
+// GetMany retrieves the values of several lines at once, in the order requested.
+// The data offsets are read first and sorted so the values are read in file order.
+// Lines that are out of range, evicted, or deleted yield a nil value; the error is only set
+// when a record cannot be read.
+func (s *Store) GetMany(lines []uint64) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type request struct {
+		pos    int    // Position in lines
+		offset uint64 // Data offset of the record
+	}
+	requests := make([]request, 0, len(lines))
+	for i, line := range lines {
+		if line >= s.lineCount || line < s.evictLine {
+			continue
+		}
+		dataOffset, err := s.readIndexOffset(line)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request{pos: i, offset: dataOffset})
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].offset < requests[j].offset
+	})
+
+	values := make([][]byte, len(lines))
+	for _, req := range requests {
+		typeByte, value, err := s.readRecord(int64(req.offset), lines[req.pos], false)
+		if err != nil {
+			return nil, err
+		}
+		if typeByte&recordDeleted == 0 {
+			values[req.pos] = value
+		}
+	}
+	return values, nil
+}
+
+// Update replaces the value stored at line and returns the same line number.
+// The new value is appended to the end of the data file and the line's index
+// entry is repointed at it, so line numbers stay stable and the line count does
+// not change. The old record becomes dead space that Polish reclaims.
+func (s *Store) Update(line uint64, value []byte) (uint64, error) {
+	line, err := s.update(line, value)
+	s.observe(opUpdate, 1, err)
+	return line, err
+}
+
+// update implements Update.
+func (s *Store) update(line uint64, value []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+
+	if line >= s.lineCount {
+		return 0, fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
+	}
+	err = s.checkValueSize(value)
+	if err != nil {
+		return 0, err
+	}
+
+	dataOffset, typeByte, err := s.readLineType(line)
+	if err != nil {
+		return 0, err
+	}
+	if typeByte&recordDeleted != 0 {
+		return 0, deletedError(typeByte, line)
+	}
+	oldSize, err := s.recordSize(int64(dataOffset), line)
+	if err != nil {
+		return 0, err
+	}
+	userFlags, err := s.readFlags(int64(dataOffset), line)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err := s.encodeValue(value)
+	if err != nil {
+		return 0, err
+	}
+	record := s.format.encodeRecord(recordUpdate, line, userFlags, time.Now().UnixNano(), payload)
+	err = s.checkQuota(int64(len(record)))
+	if err != nil {
+		return 0, err
+	}
+	err = s.replaceRecord(line, record)
+	if err != nil {
+		return 0, err
+	}
+	s.addDead(oldSize)
+
+	s.notify(EventUpdate, line)
+	return line, nil
+}
+
+// replaceRecord appends record, an update record for line, to the data file and
+// repoints the line's index entry at it. The caller must hold the write lock.
+func (s *Store) replaceRecord(line uint64, record []byte) error {
+	newOffset, err := s.appendOffset()
+	if err != nil {
+		return err
+	}
+	_, err = s.file.WriteAt(record, newOffset)
+	if err != nil {
+		s.file.Truncate(newOffset)
+		return writeError("failed to write record", err)
+	}
+	s.countWritten(len(record))
+	err = s.sync(s.file)
+	if err != nil {
+		s.file.Truncate(newOffset)
+		return writeError("failed to sync data file", err)
+	}
+
+	if s.sparseUpdates != nil {
+		// A sparse index keeps pointing at the line's own record; updates are found in memory
+		s.sparseUpdates[line] = uint64(newOffset)
+		s.updateGauges()
+		return nil
+	}
+
+	// Repoint the index entry at the new record
+	_, err = s.indexFile.WriteAt(s.format.encodeIndexEntry(line, uint64(newOffset)), s.indexPos(line))
+	if err != nil {
+		return fmt.Errorf("failed to update index entry: %v", err)
+	}
+	err = s.sync(s.indexFile)
+	if err != nil {
+		return fmt.Errorf("failed to sync index file: %v", err)
+	}
+	if s.offsets != nil {
+		s.offsets[line-s.baseLine] = uint64(newOffset)
+	}
+	s.updateGauges()
+	return nil
+}
+
+// Exists reports whether line refers to a live record. Lines past the end of the
+// store, evicted lines, and deleted lines report false. Only the index entry and the record's type
+// byte are read, so it is much cheaper than Get.
+func (s *Store) Exists(line uint64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if line >= s.lineCount || line < s.evictLine {
+		return false, nil
+	}
+	_, typeByte, err := s.readLineType(line)
+	if err != nil {
+		return false, err
+	}
+	return typeByte&recordDeleted == 0, nil
+}
+
+// Delete marks the record at the specified line as deleted. The record and its
+// index entry stay in place, so the line numbers of other records are unaffected.
+// Deleting an already deleted line is a no-op. Deleting a line reserved by Reserve
+// cancels the reservation, so WriteReserved can no longer fill it.
+func (s *Store) Delete(line uint64) error {
+	err := s.deleteLine(line)
+	s.observe(opDelete, 1, err)
+	return err
+}
+
+// deleteLine implements Delete.
+func (s *Store) deleteLine(line uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return err
+	}
+
+	if line >= s.lineCount {
+		return fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
+	}
+
+	dataOffset, typeByte, err := s.readLineType(line)
+	if err != nil {
+		return err
+	}
+	if typeByte&recordDeleted != 0 && typeByte&recordPending == 0 {
+		return nil
+	}
+	size, err := s.recordSize(int64(dataOffset), line)
+	if err != nil {
+		return err
+	}
+
+	if s.format.flags&flagShared != 0 {
+		// Other lines may share the record, so this line alone is repointed at a tombstone
+		err = s.replaceRecord(line, s.format.encodeRecord(recordUpdate|recordDeleted, line, 0, time.Now().UnixNano(), nil))
+		if err != nil {
+			return err
+		}
+		s.addDead(size)
+		s.notify(EventDelete, line)
+		return nil
+	}
+
+	_, err = s.file.WriteAt([]byte{(typeByte | recordDeleted) &^ recordPending}, int64(dataOffset))
+	if err != nil {
+		return fmt.Errorf("failed to mark line %d as deleted: %v", line, err)
+	}
+	err = s.sync(s.file)
+	if err != nil {
+		return fmt.Errorf("failed to sync data file: %v", err)
+	}
+	s.addDead(size)
+	s.notify(EventDelete, line)
+	return nil
+}
+
+// DeleteRange deletes every live line in [start, end) like Delete, with a single fsync
+// for the whole range instead of one per line. An end past the last line is clamped to
+// it, and evicted and already deleted lines are skipped. If it fails partway, the lines
+// deleted so far stay deleted.
+func (s *Store) DeleteRange(start, end uint64) error {
+	n, err := s.deleteRange(start, end)
+	s.observe(opDelete, n, err)
+	return err
+}
+
+// deleteRange implements DeleteRange and returns the number of lines it deleted.
+func (s *Store) deleteRange(start, end uint64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+	start, end = max(start, s.evictLine), min(end, s.lineCount)
+	shared := s.format.flags&flagShared != 0
+
+	var lines []uint64
+	dead := int64(0)
+	for line := start; line < end; line++ {
+		dataOffset, typeByte, err := s.readLineType(line)
+		if err != nil {
+			return 0, err
+		}
+		if typeByte&recordDeleted != 0 && typeByte&recordPending == 0 {
+			continue
+		}
+		size, err := s.recordSize(int64(dataOffset), line)
+		if err != nil {
+			return 0, err
+		}
+		if !shared {
+			_, err = s.file.WriteAt([]byte{(typeByte | recordDeleted) &^ recordPending}, int64(dataOffset))
+			if err != nil {
+				return 0, fmt.Errorf("failed to mark line %d as deleted: %v", line, err)
+			}
+		}
+		lines = append(lines, line)
+		dead += size
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	if shared {
+		// Other lines may share the records, so the lines are repointed at tombstones
+		err = s.tombstoneLines(lines)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		err = s.sync(s.file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to sync data file: %v", err)
+		}
+	}
+	s.addDead(dead)
+	for _, line := range lines {
+		s.notify(EventDelete, line)
+	}
+	return len(lines), nil
+}
+
+// tombstoneLines appends a tombstone for each of lines and repoints their index entries
+// at them, syncing each file once, for stores whose lines may share records. The
+// caller must hold the write lock.
+func (s *Store) tombstoneLines(lines []uint64) error {
+	dataStart, err := s.appendOffset()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	newOffsets := make([]uint64, len(lines))
+	written := time.Now().UnixNano()
+	for i, line := range lines {
+		newOffsets[i] = uint64(dataStart) + uint64(len(data))
+		data = append(data, s.format.encodeRecord(recordUpdate|recordDeleted, line, 0, written, nil)...)
+	}
+	_, err = s.file.WriteAt(data, dataStart)
+	if err != nil {
+		s.file.Truncate(dataStart)
+		return writeError("failed to write tombstones", err)
+	}
+	s.countWritten(len(data))
+	err = s.sync(s.file)
+	if err != nil {
+		s.file.Truncate(dataStart)
+		return writeError("failed to sync data file", err)
+	}
+
+	// Repoint each line's index entry at its tombstone
+	for i, line := range lines {
+		_, err = s.indexFile.WriteAt(s.format.encodeIndexEntry(line, newOffsets[i]), s.indexPos(line))
+		if err != nil {
+			return fmt.Errorf("failed to update index entry: %v", err)
+		}
+		if s.offsets != nil {
+			s.offsets[line-s.baseLine] = newOffsets[i]
+		}
+	}
+	err = s.sync(s.indexFile)
+	if err != nil {
+		return fmt.Errorf("failed to sync index file: %v", err)
+	}
+	s.updateGauges()
+	return nil
+}
+
+// readIndexOffset returns the data file offset recorded in the index for line.
+// Lines evicted by WithMaxRecords return ErrEvicted. The entry is expected at the
+// line's position; if the entry found there belongs to another line, the index is
+// binary searched instead, so lookups keep working if entries ever stop being contiguous.
+func (s *Store) readIndexOffset(line uint64) (uint64, error) {
+	return s.readIndexOffsetAt(s.indexFile, line)
+}
+
+// readIndexOffsetAt is like readIndexOffset, reading the entry through index, which
+// serves reads of the index file.
+func (s *Store) readIndexOffsetAt(index io.ReaderAt, line uint64) (uint64, error) {
+	if s.closed {
+		return 0, ErrClosed
+	}
+	if line < s.evictLine {
+		return 0, fmt.Errorf("line %d: %w", line, ErrEvicted)
+	}
+	if s.offsets != nil {
+		return s.offsets[line-s.baseLine], nil
+	}
+	if s.sparseUpdates != nil {
+		return s.sparseOffset(index, line)
+	}
+
+	indexEntry := make([]byte, s.format.indexEntrySize())
+	n, err := index.ReadAt(indexEntry, s.indexPos(line))
+	if n == len(indexEntry) && !s.format.entryIntact(indexEntry) {
+		return 0, fmt.Errorf("index entry for line %d: %w", line, ErrIndexCorrupt)
+	}
+	if n == len(indexEntry) && s.format.entryHolds(indexEntry, line) {
+		return s.format.entryOffset(indexEntry), nil
+	}
+	if s.format.flags&flagCompact != 0 {
+		// Compact entries have no line numbers to search by
+		return 0, fmt.Errorf("failed to read index entry for line %d: %v", line, err)
+	}
+	return s.searchIndex(index, line)
+}
+
+// searchIndex binary searches the index file, whose entries are sorted by line, for
+// line's entry and returns its data offset, reading the entries through index.
+// Compact indexes can't be searched.
+func (s *Store) searchIndex(index io.ReaderAt, line uint64) (uint64, error) {
+	indexSize, err := s.indexFile.Size()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat index file: %v", err)
+	}
+	entrySize := s.format.indexEntrySize()
+	entries := int(indexSize / entrySize)
+	indexEntry := make([]byte, entrySize)
+	var readErr error
+	i := sort.Search(entries, func(i int) bool {
+		n, err := index.ReadAt(indexEntry, int64(i)*entrySize)
+		if n != len(indexEntry) {
+			readErr = err
+			return true
+		}
+		return binary.LittleEndian.Uint64(indexEntry[0:8]) >= line
+	})
+	if readErr != nil {
+		return 0, fmt.Errorf("failed to read index entry for line %d: %v", line, readErr)
+	}
+	if i == entries {
+		return 0, fmt.Errorf("no index entry for line %d: %w", line, ErrNotFound)
+	}
+	n, err := index.ReadAt(indexEntry, int64(i)*entrySize)
+	if n != len(indexEntry) {
+		return 0, fmt.Errorf("failed to read index entry for line %d: %v", line, err)
+	}
+	if binary.LittleEndian.Uint64(indexEntry[0:8]) != line {
+		return 0, fmt.Errorf("no index entry for line %d: %w", line, ErrNotFound)
+	}
+	if !s.format.entryIntact(indexEntry) {
+		return 0, fmt.Errorf("index entry for line %d: %w", line, ErrIndexCorrupt)
+	}
+	return s.format.entryOffset(indexEntry), nil
+}
+
+// List returns all live line/value pairs in line order (line 0 is first record).
+// Deleted records are skipped; each pair keeps its original line number. The files are
+// read ahead in large blocks, so the scan takes few system calls however many records
+// it reads.
+func (s *Store) List() ([][2]interface{}, error) {
+	return s.ListContext(context.Background())
+}
+
+// ListContext is like List but checks ctx before reading each record and aborts with
+// ctx's error once it is done.
+func (s *Store) ListContext(ctx context.Context) ([][2]interface{}, error) {
+	span := s.startSpan(ctx, "linestore.List")
+	result, err := s.list(ctx, false)
+	span.SetAttribute("linestore.lines", int64(len(result)))
+	endSpan(span, err)
+	return result, err
+}
+
+// ListBorrow is like List, but on a store opened with WithMmap the values are slices of
+// the memory-mapped data file instead of copies, which saves copying every value on a
+// read-only pass over a large store. The caller must not modify the values or retain
+// them past the next write to the store or Close. Without WithMmap it returns copies,
+// like List.
+func (s *Store) ListBorrow() ([][2]interface{}, error) {
+	return s.list(context.Background(), true)
+}
+
+// list implements ListContext and ListBorrow. With borrow set, values may be slices of
+// the data file's memory mapping.
+func (s *Store) list(ctx context.Context, borrow bool) ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, index := s.sequentialReaders()
+	result := make([][2]interface{}, 0, s.lineCount-s.evictLine)
+	for lineNum := s.evictLine; lineNum < s.lineCount; lineNum++ {
+		err := ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+		typeByte, value, err := s.readLineAt(data, index, lineNum, borrow)
+		if err != nil {
+			return nil, err
+		}
+		if typeByte&recordDeleted != 0 {
+			continue
+		}
+		result = append(result, [2]interface{}{lineNum, value})
+	}
+
+	return result, nil
+}
+
+// Range returns the live line/value pairs for lines in [start, end), in line order.
+// end is clamped to the number of lines, and an empty slice is returned when start >= end.
+func (s *Store) Range(start, end uint64) ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if end > s.lineCount {
+		end = s.lineCount
+	}
+	if start >= end {
+		return [][2]interface{}{}, nil
+	}
+	return s.collect(start, end, end-start)
+}
+
+// ListPage returns up to limit live line/value pairs starting at line offset, in line order.
+// The first record is located through the index, so earlier pages are never read.
+// Deleted lines are skipped, so the next page starts one past the last returned line.
+// An offset past the end returns an empty slice.
+func (s *Store) ListPage(offset, limit uint64) ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if offset >= s.lineCount || limit == 0 {
+		return [][2]interface{}{}, nil
+	}
+	return s.collect(offset, s.lineCount, limit)
+}
+
+// Since returns the live line/value pairs from line onwards, in line order. A follower
+// that has consumed everything up to last can poll Since(last+1) for new records.
+// A line past the end returns an empty slice.
+func (s *Store) Since(line uint64) ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if line >= s.lineCount {
+		return [][2]interface{}{}, nil
+	}
+	return s.collect(line, s.lineCount, s.lineCount-line)
+}
+
+// Filter returns the live line/value pairs whose value pred accepts, in line order.
+// Values are read and tested one at a time, so only matches are kept in memory. Deleted
+// records are skipped before pred runs. pred is called with the read lock held, so it
+// must not write to the store; the value it is given may be kept.
+func (s *Store) Filter(pred func(value []byte) bool) ([][2]interface{}, error) {
+	return s.FilterLimit(pred, ^uint64(0))
+}
+
+// FilterLimit is like Filter but stops after limit matches, without reading further.
+// A limit of 0 returns an empty slice.
+func (s *Store) FilterLimit(pred func(value []byte) bool, limit uint64) ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+	data, index := s.sequentialReaders()
+	result := [][2]interface{}{}
+	for lineNum := s.evictLine; lineNum < s.lineCount && uint64(len(result)) < limit; lineNum++ {
+		typeByte, value, err := s.readLineAt(data, index, lineNum, false)
+		if err != nil {
+			return nil, err
+		}
+		if typeByte&recordDeleted != 0 || !pred(value) {
+			continue
+		}
+		result = append(result, [2]interface{}{lineNum, value})
+	}
+	return result, nil
+}
+
+// collect reads up to limit live records from lines in [start, end), skipping evicted lines.
+func (s *Store) collect(start, end, limit uint64) ([][2]interface{}, error) {
+	start = max(start, s.evictLine)
+	if start >= end {
+		return [][2]interface{}{}, nil
+	}
+	data, index := s.sequentialReaders()
+	result := make([][2]interface{}, 0, min(end-start, limit))
+	for lineNum := start; lineNum < end && uint64(len(result)) < limit; lineNum++ {
+		typeByte, value, err := s.readLineAt(data, index, lineNum, false)
+		if err != nil {
+			return nil, err
+		}
+		if typeByte&recordDeleted != 0 {
+			continue
+		}
+		result = append(result, [2]interface{}{lineNum, value})
+	}
+	return result, nil
+}
+
+// Head returns the first n live line/value pairs, in line order. Reading stops after n
+// records, and fewer are returned when the store has fewer live lines.
+func (s *Store) Head(n uint64) ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if n == 0 {
+		return [][2]interface{}{}, nil
+	}
+	return s.collect(0, s.lineCount, n)
+}
+
+// Tail returns the last n live line/value pairs, oldest first. Lines are read backwards
+// through the index from the end, so only the records returned (and any deleted lines
+// among them) are read. Fewer are returned when the store has fewer live lines.
+func (s *Store) Tail(n uint64) ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([][2]interface{}, 0, min(n, s.lineCount-s.evictLine))
+	for lineNum := s.lineCount; lineNum > s.evictLine && uint64(len(result)) < n; lineNum-- {
+		typeByte, value, err := s.readLine(lineNum - 1)
+		if err != nil {
+			return nil, err
+		}
+		if typeByte&recordDeleted != 0 {
+			continue
+		}
+		result = append(result, [2]interface{}{lineNum - 1, value})
+	}
+	slices.Reverse(result)
+	return result, nil
+}
+
+// First returns the line number and value of the oldest live line, skipping deleted
+// and evicted lines, or ErrEmpty if there is none.
+func (s *Store) First() (uint64, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for line := s.evictLine; line < s.lineCount; line++ {
+		value, ok, err := s.liveValue(line)
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok {
+			return line, value, nil
+		}
+	}
+	return 0, nil, ErrEmpty
+}
+
+// Last returns the line number and value of the newest live line, skipping deleted
+// lines, or ErrEmpty if there is none.
+func (s *Store) Last() (uint64, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for line := s.lineCount; line > s.evictLine; line-- {
+		value, ok, err := s.liveValue(line - 1)
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok {
+			return line - 1, value, nil
+		}
+	}
+	return 0, nil, ErrEmpty
+}
+
+// liveValue returns the value of line, or false if the line is deleted. Only the type
+// byte is read for deleted lines.
+func (s *Store) liveValue(line uint64) ([]byte, bool, error) {
+	_, typeByte, err := s.readLineType(line)
+	if err != nil || typeByte&recordDeleted != 0 {
+		return nil, false, err
+	}
+	_, value, err := s.readLine(line)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// ListIncludingDeleted returns every record in line order, including tombstones.
+// Each entry holds the line number, the stored value, and a bool that is true for deleted records.
+func (s *Store) ListIncludingDeleted() ([][3]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([][3]interface{}, 0, s.lineCount-s.evictLine)
+	for lineNum := s.evictLine; lineNum < s.lineCount; lineNum++ {
+		typeByte, value, err := s.readLine(lineNum)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, [3]interface{}{lineNum, value, typeByte&recordDeleted != 0})
+	}
+
+	return result, nil
+}
+
+// ListAllReverse returns all live line/value pairs, starting from the last line, with original line numbers.
+// Deleted records are skipped.
+func (s *Store) ListAllReverse() ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([][2]interface{}, 0, s.lineCount-s.evictLine)
+	if s.lineCount == s.evictLine {
+		return result, nil
+	}
+
+	for lineNum := s.lineCount - 1; ; lineNum-- {
+		typeByte, value, err := s.readLine(lineNum)
+		if err != nil {
+			return nil, err
+		}
+		if typeByte&recordDeleted == 0 {
+			// Use the original lineNum as the ID
+			result = append(result, [2]interface{}{lineNum, value})
+		}
+
+		if lineNum == s.evictLine {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// readLineType returns the data offset and type byte of the record mapped to line
+// without reading its value.
+func (s *Store) readLineType(line uint64) (uint64, byte, error) {
+	dataOffset, err := s.readIndexOffset(line)
+	if err != nil {
+		return 0, 0, err
+	}
+	typeByte := make([]byte, 1)
+	_, err = s.file.ReadAt(typeByte, int64(dataOffset))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read type byte at line %d: %v", line, err)
+	}
+	if !validRecordType(typeByte[0]) {
+		return 0, 0, fmt.Errorf("invalid record type %d at line %d: %w", typeByte[0], line, ErrInvalidRecord)
+	}
+	return dataOffset, typeByte[0], nil
+}
+
+// readLine reads the record the index currently maps to line.
+func (s *Store) readLine(line uint64) (byte, []byte, error) {
+	dataOffset, err := s.readIndexOffset(line)
+	if err != nil {
+		return 0, nil, err
+	}
+	return s.readRecord(int64(dataOffset), line, false)
+}
+
+// readLineAt is like readLine, or borrowLine with borrow set, reading the files through
+// the readers returned by sequentialReaders.
+func (s *Store) readLineAt(data, index io.ReaderAt, line uint64, borrow bool) (byte, []byte, error) {
+	dataOffset, err := s.readIndexOffsetAt(index, line)
+	if err != nil {
+		return 0, nil, err
+	}
+	return s.readRecordAt(data, int64(dataOffset), line, borrow)
+}
+
+// borrowLine is like readLine, but with WithMmap the value may be a slice of the
+// mapping that is only valid until the next write.
+func (s *Store) borrowLine(line uint64) (byte, []byte, error) {
+	dataOffset, err := s.readIndexOffset(line)
+	if err != nil {
+		return 0, nil, err
+	}
+	return s.readRecord(int64(dataOffset), line, true)
+}
+
+// readRecord reads the record starting at offset in the data file and returns its
+// type byte and decoded value. line is only used in error messages. With borrow set,
+// the value may be borrowed from the data file's memory mapping.
+func (s *Store) readRecord(offset int64, line uint64, borrow bool) (byte, []byte, error) {
+	return s.readRecordAt(s.file, offset, line, borrow)
+}
 
-	indexPath := path + ".idx"
-	indexFile, err := os.OpenFile(indexPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+// readRecordAt is like readRecord, reading the record through data, which serves
+// reads of the data file.
+func (s *Store) readRecordAt(data io.ReaderAt, offset int64, line uint64, borrow bool) (byte, []byte, error) {
+	typeByte, payload, err := s.readPayloadAt(data, offset, line, borrow)
 	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to open index file: %v", err)
+		return 0, nil, err
 	}
-
-	store := &Store{
-		file:      file,
-		indexFile: indexFile,
-		lineCount: 0,
+	if typeByte&recordDeleted != 0 && len(payload) == 0 {
+		// Tombstone left by Polish on a store that keeps its line numbers
+		return typeByte, nil, nil
 	}
-
-	err = store.countLines()
+	value, err := s.decodeValue(payload, line)
 	if err != nil {
-		file.Close()
-		indexFile.Close()
-		return nil, fmt.Errorf("failed to count lines: %v", err)
+		return 0, nil, err
 	}
-
-	return store, nil
+	return typeByte, value, nil
 }
 
-// countLines determines the total number of records in the file and validates the index.
-func (s *Store) countLines() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// readPayload reads the record starting at offset in the data file and returns its
+// type byte and value as stored, after checking its checksum. It only uses ReadAt,
+// so concurrent readers don't interfere with each other. With borrow set, the value
+// is a slice of the data file's memory mapping if there is one.
+func (s *Store) readPayload(offset int64, line uint64, borrow bool) (byte, []byte, error) {
+	return s.readPayloadAt(s.file, offset, line, borrow)
+}
 
-	_, err := s.file.Seek(0, io.SeekStart)
+// readPayloadAt is like readPayload, reading the record through data.
+func (s *Store) readPayloadAt(data io.ReaderAt, offset int64, line uint64, borrow bool) (byte, []byte, error) {
+	typeByte, prefixLen, valLen, err := s.readPrefixAt(data, offset, line)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
-	lineNum := uint64(0)
-	for {
-		var typeByte byte
-		err = binary.Read(s.file, binary.LittleEndian, &typeByte)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("failed to read type byte: %v", err)
-		}
-		if typeByte != 0 {
-			return fmt.Errorf("invalid record type %d at line %d", typeByte, lineNum)
-		}
-
-		var valLen uint32
-		err = binary.Read(s.file, binary.LittleEndian, &valLen)
-		if err != nil {
-			return fmt.Errorf("failed to read value length: %v", err)
-		}
-		_, err = s.file.Seek(int64(valLen), io.SeekCurrent)
-		if err != nil {
-			return fmt.Errorf("failed to skip value: %v", err)
+	size := int64(valLen) + s.format.trailerLen()
+	var body []byte
+	if m, ok := s.file.(*mmapBackend); ok && borrow {
+		body, _ = m.borrow(offset+prefixLen, size)
+	}
+	if body == nil {
+		body = make([]byte, size)
+		n, err := data.ReadAt(body, offset+prefixLen)
+		if n < len(body) {
+			return 0, nil, fmt.Errorf("failed to read value at line %d (read %d/%d bytes): %v", line, n, len(body), err)
 		}
-		lineNum++
 	}
-	s.lineCount = lineNum
+	s.countRead(int(prefixLen) + len(body))
+	value := body[:valLen:valLen]
 
-	// Validate index file length
-	indexStat, err := s.indexFile.Stat()
+	err = s.checkChecksum(value, body[valLen:], line)
 	if err != nil {
-		return fmt.Errorf("failed to stat index file: %v", err)
-	}
-	expectedSize := int64(s.lineCount * 16) // 8 bytes lineNum + 8 bytes offset
-	if indexStat.Size() != expectedSize {
-		return fmt.Errorf("index file size %d does not match expected %d", indexStat.Size(), expectedSize)
+		return 0, nil, err
 	}
-
-	return nil
+	return typeByte, value, nil
 }
 
-// Set appends a value to the store and updates the index file.
-func (s *Store) Set(value []byte) (uint64, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Write to data file
-	record := make([]byte, 1+4+len(value))
-	record[0] = 0 // Active record
-	binary.LittleEndian.PutUint32(record[1:5], uint32(len(value)))
-	copy(record[5:], value)
+// readPrefix reads the fields before the value of the record starting at offset and
+// returns its type byte, the length of those fields, and the stored value length.
+func (s *Store) readPrefix(offset int64, line uint64) (byte, int64, int64, error) {
+	return s.readPrefixAt(s.file, offset, line)
+}
 
-	dataOffset, err := s.file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return 0, fmt.Errorf("failed to seek to end of data file: %v", err)
+// readPrefixAt is like readPrefix, reading the fields through data.
+func (s *Store) readPrefixAt(data io.ReaderAt, offset int64, line uint64) (byte, int64, int64, error) {
+	prefix := make([]byte, maxPrefixLen)
+	n, err := data.ReadAt(prefix, offset)
+	if n < 1 {
+		return 0, 0, 0, fmt.Errorf("failed to read type byte at line %d: %v", line, err)
 	}
-	_, err = s.file.Write(record)
-	if err != nil {
-		return 0, fmt.Errorf("failed to write record: %v", err)
+	typeByte := prefix[0]
+	if !validRecordType(typeByte) {
+		return 0, 0, 0, fmt.Errorf("invalid record type %d at line %d: %w", typeByte, line, ErrInvalidRecord)
 	}
-	err = s.file.Sync()
-	if err != nil {
-		return 0, fmt.Errorf("failed to sync data file: %v", err)
+	prefixLen := s.format.prefixLen(typeByte)
+	if int64(n) < prefixLen {
+		return 0, 0, 0, fmt.Errorf("failed to read value length at line %d: %v", line, err)
 	}
 
-	// Write to index file
-	lineNum := s.lineCount
-	indexEntry := make([]byte, 16)
-	binary.LittleEndian.PutUint64(indexEntry[0:8], lineNum)
-	binary.LittleEndian.PutUint64(indexEntry[8:16], uint64(dataOffset))
-	_, err = s.indexFile.Write(indexEntry)
-	if err != nil {
-		return 0, fmt.Errorf("failed to write index entry: %v", err)
-	}
-	err = s.indexFile.Sync()
-	if err != nil {
-		return 0, fmt.Errorf("failed to sync index file: %v", err)
+	valLen := s.format.valueLen(prefix)
+	if valLen < 0 || uint64(valLen) > s.payloadLimit() {
+		return 0, 0, 0, fmt.Errorf("invalid value length %d at line %d: %w: %w", valLen, line, ErrInvalidRecord, ErrValueTooLarge)
 	}
+	return typeByte, prefixLen, valLen, nil
+}
 
-	s.lineCount++
-	return lineNum, nil
+// checkChecksum compares value against the record trailer when the format has checksums.
+func (s *Store) checkChecksum(value, trailer []byte, line uint64) error {
+	if !s.format.checksums() {
+		return nil
+	}
+	if crc32.ChecksumIEEE(value) != binary.LittleEndian.Uint32(trailer) {
+		return fmt.Errorf("line %d: %w", line, ErrChecksumMismatch)
+	}
+	return nil
 }
 
-// Get retrieves the value at the specified line number using the index file.
-func (s *Store) Get(line uint64) ([]byte, error) {
+// GetInto reads the value at line into buf and returns its length, so a hot read loop
+// can reuse pooled buffers instead of allocating one per Get. If the value doesn't fit,
+// GetInto returns the length it needs and an error wrapping ErrBufferTooSmall. On a plain
+// store the value is read straight into buf; compressed and encrypted values are decoded
+// first and copied.
+func (s *Store) GetInto(line uint64, buf []byte) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if line >= s.lineCount {
-		return nil, fmt.Errorf("line %d exceeds total lines %d", line, s.lineCount)
-	}
-
-	// Seek to the index entry for the line
-	indexOffset := int64(line * 16) // 16 bytes per entry
-	_, err := s.indexFile.Seek(indexOffset, io.SeekStart)
-	if err != nil {
-		return nil, fmt.Errorf("failed to seek to index offset %d: %v", indexOffset, err)
+		return 0, fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
 	}
 
-	indexEntry := make([]byte, 16)
-	n, err := io.ReadFull(s.indexFile, indexEntry)
-	if err != nil || n != 16 {
-		return nil, fmt.Errorf("failed to read index entry for line %d: %v", line, err)
+	if s.format.codec != CompressionNone || s.aead != nil {
+		value, err := s.getLine(line, s.lineCount)
+		if err != nil {
+			return 0, err
+		}
+		if len(value) > len(buf) {
+			return len(value), fmt.Errorf("value at line %d needs %d bytes: %w", line, len(value), ErrBufferTooSmall)
+		}
+		return copy(buf, value), nil
 	}
 
-	dataOffset := binary.LittleEndian.Uint64(indexEntry[8:16])
-	_, err = s.file.Seek(int64(dataOffset), io.SeekStart)
+	dataOffset, err := s.readIndexOffset(line)
 	if err != nil {
-		return nil, fmt.Errorf("failed to seek to data offset %d: %v", dataOffset, err)
+		return 0, err
 	}
-
-	var typeByte byte
-	err = binary.Read(s.file, binary.LittleEndian, &typeByte)
+	typeByte, prefixLen, valLen, err := s.readPrefix(int64(dataOffset), line)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read type byte at line %d: %v", line, err)
+		return 0, err
 	}
-	if typeByte != 0 {
-		return nil, fmt.Errorf("invalid record type %d at line %d", typeByte, line)
+	if typeByte&recordDeleted != 0 {
+		return 0, deletedError(typeByte, line)
+	}
+	if int(valLen) > len(buf) {
+		return int(valLen), fmt.Errorf("value at line %d needs %d bytes: %w", line, valLen, ErrBufferTooSmall)
 	}
 
-	var valLen uint32
-	err = binary.Read(s.file, binary.LittleEndian, &valLen)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read value length at line %d: %v", line, err)
+	valueOffset := int64(dataOffset) + prefixLen
+	n, err := s.file.ReadAt(buf[:valLen], valueOffset)
+	if n < int(valLen) {
+		return 0, fmt.Errorf("failed to read value at line %d (read %d/%d bytes): %v", line, n, valLen, err)
 	}
-	if valLen > 1<<20 {
-		return nil, fmt.Errorf("invalid value length %d at line %d", valLen, line)
+	s.countRead(int(prefixLen) + n)
+	trailer := make([]byte, s.format.trailerLen())
+	_, err = s.file.ReadAt(trailer, valueOffset+int64(valLen))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read checksum at line %d: %v", line, err)
 	}
-
-	value := make([]byte, valLen)
-	n, err = io.ReadFull(s.file, value)
+	err = s.checkChecksum(buf[:valLen], trailer, line)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read value at line %d (read %d/%d bytes): %v", line, n, valLen, err)
+		return 0, err
 	}
-
-	return value, nil
+	return int(valLen), nil
 }
 
-// List returns all line/value pairs, starting from the beginning of the file (line 0 is first record).
-func (s *Store) List() ([][2]interface{}, error) {
+// ValueLen returns the length of the value at line, for sizing the buffer passed to
+// GetInto. On a plain store only the index entry and the record's prefix are read, not
+// the value; compressed and encrypted values have to be decoded to learn their length.
+// Deleted lines return ErrDeleted, like Get.
+func (s *Store) ValueLen(line uint64) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	result := make([][2]interface{}, 0, s.lineCount)
-	_, err := s.file.Seek(0, io.SeekStart) // Always start at the beginning
-	if err != nil {
-		return nil, fmt.Errorf("failed to seek to start: %v", err)
+	if line >= s.lineCount {
+		return 0, fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
 	}
-
-	for lineNum := uint64(0); lineNum < s.lineCount; lineNum++ {
-		var typeByte byte
-		err = binary.Read(s.file, binary.LittleEndian, &typeByte)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read type byte at line %d: %v", lineNum, err)
-		}
-		if typeByte != 0 {
-			return nil, fmt.Errorf("invalid record type %d at line %d", typeByte, lineNum)
-		}
-
-		var valLen uint32
-		err = binary.Read(s.file, binary.LittleEndian, &valLen)
+	if s.format.codec != CompressionNone || s.aead != nil {
+		value, err := s.getLine(line, s.lineCount)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read value length at line %d: %v", lineNum, err)
+			return 0, err
 		}
-		if valLen > 1<<20 {
-			return nil, fmt.Errorf("invalid value length %d at line %d", valLen, lineNum)
-		}
-
-		value := make([]byte, valLen)
-		n, err := io.ReadFull(s.file, value)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read value at line %d (read %d/%d bytes): %v", lineNum, n, valLen, err)
-		}
-		result = append(result, [2]interface{}{lineNum, value})
+		return len(value), nil
 	}
 
-	return result, nil
+	dataOffset, err := s.readIndexOffset(line)
+	if err != nil {
+		return 0, err
+	}
+	typeByte, _, valLen, err := s.readPrefix(int64(dataOffset), line)
+	if err != nil {
+		return 0, err
+	}
+	if typeByte&recordDeleted != 0 {
+		return 0, deletedError(typeByte, line)
+	}
+	return int(valLen), nil
 }
 
-// ListAllReverse returns all line/value pairs, starting from the end of the file, with original line numbers.
-func (s *Store) ListAllReverse() ([][2]interface{}, error) {
+// GetLastLine returns the line number of the last item in the store.
+func (s *Store) GetLastLine() (uint64, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	result := make([][2]interface{}, 0, s.lineCount)
 	if s.lineCount == 0 {
-		return result, nil
+		return 0, ErrEmpty
 	}
+	return s.lineCount - 1, nil
+}
 
-	for lineNum := s.lineCount - 1; ; lineNum-- {
-		// Seek to the index entry for the current line
-		indexOffset := int64(lineNum * 16) // 16 bytes per entry
-		_, err := s.indexFile.Seek(indexOffset, io.SeekStart)
-		if err != nil {
-			return nil, fmt.Errorf("failed to seek to index offset %d: %v", indexOffset, err)
-		}
-
-		indexEntry := make([]byte, 16)
-		n, err := io.ReadFull(s.indexFile, indexEntry)
-		if err != nil || n != 16 {
-			return nil, fmt.Errorf("failed to read index entry for line %d: %v", lineNum, err)
-		}
+// Count returns the total number of lines in the store, including deleted ones.
+func (s *Store) Count() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lineCount
+}
 
-		dataOffset := binary.LittleEndian.Uint64(indexEntry[8:16])
-		_, err = s.file.Seek(int64(dataOffset), io.SeekStart)
-		if err != nil {
-			return nil, fmt.Errorf("failed to seek to data offset %d: %v", dataOffset, err)
-		}
+// IsEmpty reports whether the store has no lines at all, which is when GetLastLine
+// returns ErrEmpty. Like Count, it counts deleted lines, so a store whose lines were all
+// deleted is not empty.
+func (s *Store) IsEmpty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lineCount == 0
+}
 
-		var typeByte byte
-		err = binary.Read(s.file, binary.LittleEndian, &typeByte)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read type byte at line %d: %v", lineNum, err)
-		}
-		if typeByte != 0 {
-			return nil, fmt.Errorf("invalid record type %d at line %d", typeByte, lineNum)
-		}
+// LiveCount returns the number of lines that have not been deleted.
+// It checks the type byte of every record, so it costs one read per line.
+func (s *Store) LiveCount() (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-		var valLen uint32
-		err = binary.Read(s.file, binary.LittleEndian, &valLen)
+	live := uint64(0)
+	for line := s.evictLine; line < s.lineCount; line++ {
+		_, typeByte, err := s.readLineType(line)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read value length at line %d: %v", lineNum, err)
-		}
-		if valLen > 1<<20 {
-			return nil, fmt.Errorf("invalid value length %d at line %d", valLen, lineNum)
+			return 0, err
 		}
-
-		value := make([]byte, valLen)
-		n, err = io.ReadFull(s.file, value)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read value at line %d (read %d/%d bytes): %v", lineNum, n, valLen, err)
+		if typeByte&recordDeleted == 0 {
+			live++
 		}
+	}
+	return live, nil
+}
 
-		// Use the original lineNum as the ID
-		result = append(result, [2]interface{}{lineNum, value})
+// Polish compacts the database by rewriting all live values in line order and updating the index.
+// Deleted records and values superseded by Update are dropped, so the remaining records are renumbered from 0.
+// A store opened with WithMaxRecords keeps its line numbers instead: evicted lines are dropped
+// and deleted lines shrink to empty tombstones.
+// Records are read a little ahead of the ones being written, so reading the old files
+// overlaps writing the new ones while holding only a bounded number of values in memory.
+// Readers wait for the new files to be swapped in, so they never see a half-replaced store.
+func (s *Store) Polish() error {
+	return s.PolishContext(context.Background())
+}
 
-		if lineNum == 0 {
-			break
-		}
-	}
+// PolishContext is like Polish but checks ctx before copying each record. If ctx is done,
+// the temporary files are discarded and the live store is left untouched.
+func (s *Store) PolishContext(ctx context.Context) error {
+	return s.polish(ctx, PolishOptions{})
+}
 
-	return result, nil
+// PolishOptions adjusts how PolishWithOptions compacts the store.
+type PolishOptions struct {
+	// SkipBackup skips the full copy of the store that Polish writes to path.backup
+	// first. The compacted files are still built aside and renamed into place, so an
+	// interrupted polish leaves the original intact, but a bug or disk fault during
+	// the rename is no longer recoverable from the backup.
+	SkipBackup bool
+	// Progress, if set, is called after each line is processed with the number of
+	// lines done and the total.
+	Progress func(done, total uint64)
+	// KeepLineNumbers keeps every line at its number instead of renumbering from 0.
+	// Deleted lines stay behind as empty tombstones that still return ErrDeleted.
+	KeepLineNumbers bool
 }
 
-// GetLastLine returns the line number of the last item in the store.
-func (s *Store) GetLastLine() (uint64, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// PolishWithOptions is like Polish, with the backup, progress reporting, and line
+// numbering controlled by opts.
+func (s *Store) PolishWithOptions(opts PolishOptions) error {
+	return s.polish(context.Background(), opts)
+}
 
-	if s.lineCount == 0 {
-		return 0, fmt.Errorf("store is empty")
-	}
-	return s.lineCount - 1, nil
+// Compact reclaims the space of deleted records and values superseded by Update, like
+// Polish, but keeps every line number, so line numbers held outside the store stay
+// valid. Deleted lines become permanent holes: their values are dropped, but a small
+// tombstone keeps their place and Get keeps returning ErrDeleted for them.
+func (s *Store) Compact() error {
+	return s.polish(context.Background(), PolishOptions{KeepLineNumbers: true})
 }
 
-// Polish compacts the database by rewriting all values and updating the index.
-func (s *Store) Polish() error {
+// polish implements Polish, PolishContext, PolishWithOptions, and Compact.
+func (s *Store) polish(ctx context.Context, opts PolishOptions) (err error) {
+	span := s.startSpan(ctx, "linestore.Polish")
+	defer func() { endSpan(span, err) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	origPath := s.file.Name()
-	backupPath := origPath + ".backup"
-	err := s.backupTo(backupPath, false)
-	if err != nil {
-		return fmt.Errorf("failed to create backup before polish: %v", err)
-	}
-
-	tempPath := origPath + ".tmp"
-	tempFile, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	err = s.checkWritable()
 	if err != nil {
-		return fmt.Errorf("failed to create temp data file: %v", err)
+		return err
 	}
-	defer tempFile.Close()
-
-	tempIndexPath := origPath + ".idx.tmp"
-	tempIndexFile, err := os.OpenFile(tempIndexPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return fmt.Errorf("failed to create temp index file: %v", err)
+	err = s.polishLocked(ctx, opts)
+	s.observe(opPolish, 1, err)
+	if err == nil {
+		span.SetAttribute("linestore.lines", int64(s.lineCount-s.evictLine))
 	}
-	defer tempIndexFile.Close()
+	return err
+}
 
-	_, err = s.file.Seek(0, io.SeekStart)
+// polishLocked compacts the store. The caller must hold the write lock.
+func (s *Store) polishLocked(ctx context.Context, opts PolishOptions) error {
+	sizeBefore, err := s.dataSize()
 	if err != nil {
-		return fmt.Errorf("failed to seek to start: %v", err)
+		return fmt.Errorf("failed to stat data file: %v", err)
 	}
+	s.opts.logger.Printf("linestore: compaction started store=%q lines=%d data_bytes=%d", s.name(), s.lineCount-s.evictLine, sizeBefore)
+
+	// Memory stores are compacted into fresh buffers that simply replace the old ones
+	var tempData, tempIndex backend = &memBackend{}, &memBackend{}
+	tempPath, tempIndexPath := s.path+".tmp", indexPathFor(s.path, s.opts)+".tmp"
+	if s.path != "" {
+		if !opts.SkipBackup {
+			err := s.backupTo(s.path+".backup", false)
+			if err != nil {
+				return fmt.Errorf("failed to create backup before polish: %v", err)
+			}
+		}
 
-	newLine := uint64(0)
-	for i := uint64(0); i < s.lineCount; i++ {
-		var typeByte byte
-		err = binary.Read(s.file, binary.LittleEndian, &typeByte)
+		tempFile, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.opts.fileMode)
 		if err != nil {
-			return fmt.Errorf("failed to read type byte at line %d: %v", i, err)
+			return fmt.Errorf("failed to create temp data file: %v", err)
 		}
-		if typeByte != 0 {
-			return fmt.Errorf("invalid record type %d at line %d", typeByte, i)
+		defer os.Remove(tempPath) // No-op once renamed into place
+		defer tempFile.Close()
+
+		tempData = &fileBackend{File: tempFile, retry: s.retry}
+		if !s.opts.singleFile {
+			tempIndexFile, err := os.OpenFile(tempIndexPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.opts.fileMode)
+			if err != nil {
+				return fmt.Errorf("failed to create temp index file: %v", err)
+			}
+			defer os.Remove(tempIndexPath)
+			defer tempIndexFile.Close()
+			tempIndex = &fileBackend{File: tempIndexFile, retry: s.retry}
 		}
+	}
+
+	// A ring buffer always keeps its line numbers, so the polished files start at the
+	// first kept line; otherwise evictLine is 0
+	keepLines := opts.KeepLineNumbers || s.opts.maxRecords > 0 || s.evictLine > 0
 
-		var valLen uint32
-		err = binary.Read(s.file, binary.LittleEndian, &valLen)
+	// The polished file holds every record in one file, so it is no longer segmented
+	polishedFormat := s.format
+	polishedFormat.flags &^= flagSegmented
+	dataOffset := s.format.headerLen()
+	if dataOffset > 0 {
+		_, err := tempData.WriteAt(polishedFormat.encodeLineHeader(s.evictLine, s.evictLine), 0)
 		if err != nil {
-			return fmt.Errorf("failed to read value length at line %d: %v", i, err)
-		}
-		if valLen > 1<<20 {
-			return fmt.Errorf("invalid value length %d at line %d", valLen, i)
+			return fmt.Errorf("failed to write polished header: %v", err)
 		}
+	}
+
+	w := &polishWriter{
+		format:    s.format,
+		data:      tempData,
+		index:     tempIndex,
+		every:     s.format.indexEvery(),
+		keepLines: keepLines,
+		dataEnd:   dataOffset,
+	}
+	if s.offsets != nil {
+		w.offsets = make([]uint64, 0, s.lineCount-s.evictLine)
+	}
+	if s.format.flags&flagShared != 0 {
+		w.copied = make(map[uint64]uint64)
+	}
+	err = s.copyLines(ctx, w, opts.Progress)
+	if err != nil {
+		return err
+	}
+
+	err = tempData.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync temp data file: %v", err)
+	}
+	err = tempIndex.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync temp index file: %v", err)
+	}
+
+	err = s.copies.close(s.file)
+	if err != nil {
+		return fmt.Errorf("failed to close original data file: %v", err)
+	}
+	err = s.indexFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close original index file: %v", err)
+	}
 
-		value := make([]byte, valLen)
-		n, err := io.ReadFull(s.file, value)
+	if s.path == "" {
+		s.file, s.indexFile = tempData, tempIndex
+	} else {
+		err = s.replaceFiles(tempPath, tempIndexPath, tempIndex)
 		if err != nil {
-			return fmt.Errorf("failed to read value at line %d (read %d/%d bytes): %v", i, n, valLen, err)
+			return err
 		}
+	}
+	s.format = polishedFormat
+	if !keepLines {
+		s.lineCount = w.newLine
+	}
+	s.baseLine = s.evictLine
+	s.offsets = w.offsets
+	if s.hashes != nil {
+		s.hashes.remap(w.copied)
+	}
+	if s.sparseUpdates != nil {
+		clear(s.sparseUpdates) // Every line's value is in its own record now
+	}
+	s.sparseHint.Store(nil)
+	s.deadBytes = 0
+	s.updateGauges()
 
-		record := make([]byte, 1+4+len(value))
-		record[0] = 0
-		binary.LittleEndian.PutUint32(record[1:5], valLen)
-		copy(record[5:], value)
+	s.opts.logger.Printf("linestore: compaction finished store=%q lines=%d data_bytes=%d reclaimed_bytes=%d",
+		s.name(), s.lineCount-s.evictLine, w.dataEnd, sizeBefore-w.dataEnd)
+	return nil
+}
 
-		dataOffset, err := tempFile.Seek(0, io.SeekCurrent)
+// replaceFiles renames the polished temp files over the store's files and reopens them.
+// With WithSingleFile there is no index file, and tempIndex, held in memory, becomes the
+// index instead. The caller must hold the write lock and have closed the old files, so
+// no reader can be reading them while they are replaced. The reopened files are checked
+// against the sizes of the temp files before they are used. If the files can't be
+// replaced or reopened, the store has no usable files left and is marked closed; it has
+// to be opened again, with the polished files or the originals, whichever are in place.
+func (s *Store) replaceFiles(tempPath, tempIndexPath string, tempIndex backend) (err error) {
+	s.rewrites++
+	defer func() {
 		if err != nil {
-			return fmt.Errorf("failed to get temp data offset: %v", err)
+			s.closed = true
 		}
-		_, err = tempFile.Write(record)
+	}()
+
+	dataInfo, err := os.Stat(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat polished data file: %v", err)
+	}
+	var indexInfo os.FileInfo
+	if !s.opts.singleFile {
+		indexInfo, err = os.Stat(tempIndexPath)
 		if err != nil {
-			return fmt.Errorf("failed to write polished record: %v", err)
+			return fmt.Errorf("failed to stat polished index file: %v", err)
 		}
-
-		indexEntry := make([]byte, 16)
-		binary.LittleEndian.PutUint64(indexEntry[0:8], newLine)
-		binary.LittleEndian.PutUint64(indexEntry[8:16], uint64(dataOffset))
-		_, err = tempIndexFile.Write(indexEntry)
+	}
+	err = os.Rename(tempPath, s.path)
+	if err != nil {
+		return fmt.Errorf("failed to replace original data file: %v", err)
+	}
+	if !s.opts.singleFile {
+		err = os.Rename(tempIndexPath, indexPathFor(s.path, s.opts))
 		if err != nil {
-			return fmt.Errorf("failed to write polished index entry: %v", err)
+			return fmt.Errorf("failed to replace original index file: %v", err)
 		}
-		newLine++
 	}
 
-	err = tempFile.Sync()
+	file, err := os.OpenFile(s.path, os.O_RDWR, s.opts.fileMode)
 	if err != nil {
-		return fmt.Errorf("failed to sync temp data file: %v", err)
+		return fmt.Errorf("failed to reopen polished data file: %v", err)
 	}
-	err = tempIndexFile.Sync()
+	err = lockFile(file, true)
 	if err != nil {
-		return fmt.Errorf("failed to sync temp index file: %v", err)
+		file.Close()
+		return fmt.Errorf("failed to lock polished data file: %w", err)
+	}
+	data := &fileBackend{File: file, locked: true, retry: s.retry}
+	index := tempIndex
+	if !s.opts.singleFile {
+		indexFile, err := os.OpenFile(indexPathFor(s.path, s.opts), os.O_RDWR, s.opts.fileMode)
+		if err != nil {
+			data.Close()
+			return fmt.Errorf("failed to reopen polished index file: %v", err)
+		}
+		index = &fileBackend{File: indexFile, retry: s.retry}
 	}
 
-	err = s.file.Close()
+	// Another process could have replaced the files between the rename and the reopen
+	dataSize, err := data.Size()
+	if err == nil && dataSize != dataInfo.Size() {
+		err = fmt.Errorf("reopened data file is %d bytes, polished %d", dataSize, dataInfo.Size())
+	}
+	if err == nil && indexInfo != nil {
+		var indexSize int64
+		indexSize, err = index.Size()
+		if err == nil && indexSize != indexInfo.Size() {
+			err = fmt.Errorf("reopened index file is %d bytes, polished %d", indexSize, indexInfo.Size())
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("failed to close original data file: %v", err)
+		data.Close()
+		index.Close()
+		return fmt.Errorf("failed to check polished files: %v", err)
 	}
-	err = s.indexFile.Close()
+	s.file, s.indexFile = data, index
+
+	// Segments of the old file are stale now; the polished header no longer refers to them
+	for id := 1; ; id++ {
+		err = os.Remove(segmentPath(s.path, id))
+		if err != nil {
+			break
+		}
+	}
+	if s.opts.segmentSize > 0 {
+		s.file, err = openSegments(s.file, s.path, os.O_RDWR, s.opts.fileMode, false)
+		if err != nil {
+			return err
+		}
+	}
+	s.mapData()
+	return nil
+}
+
+// Clear removes every record, leaving an empty store that keeps its open files.
+// The data file is truncated back to its header and the index to zero, so line
+// numbers start again from 0.
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
 	if err != nil {
-		return fmt.Errorf("failed to close original index file: %v", err)
+		return err
 	}
 
-	err = os.Rename(tempPath, origPath)
+	s.rewrites++
+	err = s.file.Truncate(s.format.headerLen())
 	if err != nil {
-		return fmt.Errorf("failed to replace original data file: %v", err)
+		return fmt.Errorf("failed to truncate data file: %v", err)
+	}
+	if s.baseLine > 0 || s.evictLine > 0 {
+		s.baseLine, s.evictLine = 0, 0
+		_, err = s.file.WriteAt(s.header(), 0)
+		if err != nil {
+			return fmt.Errorf("failed to update header: %v", err)
+		}
 	}
-	err = os.Rename(tempIndexPath, origPath+".idx")
+	err = s.indexFile.Truncate(0)
 	if err != nil {
-		return fmt.Errorf("failed to replace original index file: %v", err)
+		return fmt.Errorf("failed to truncate index file: %v", err)
 	}
-
-	s.file, err = os.OpenFile(origPath, os.O_RDWR|os.O_APPEND, 0666)
+	err = s.sync(s.file)
 	if err != nil {
-		return fmt.Errorf("failed to reopen polished data file: %v", err)
+		return fmt.Errorf("failed to sync data file: %v", err)
 	}
-	s.indexFile, err = os.OpenFile(origPath+".idx", os.O_RDWR|os.O_APPEND, 0666)
+	err = s.sync(s.indexFile)
 	if err != nil {
-		s.file.Close()
-		return fmt.Errorf("failed to reopen polished index file: %v", err)
+		return fmt.Errorf("failed to sync index file: %v", err)
 	}
-	s.lineCount = newLine
 
+	s.lineCount = 0
+	if s.offsets != nil {
+		s.offsets = s.offsets[:0]
+	}
+	if s.hashes != nil {
+		clear(s.hashes)
+	}
+	if s.sparseUpdates != nil {
+		clear(s.sparseUpdates)
+	}
+	s.sparseHint.Store(nil)
+	s.deadBytes = 0
+	s.updateGauges()
 	return nil
 }
 
@@ -439,41 +2512,35 @@ func (s *Store) Backup(path string, polished bool) error {
 	return s.backupTo(path, polished)
 }
 
-// backupTo is a helper function to create a backup.
+// backupTo is a helper function to create a backup. Each segment of a segmented store
+// is copied to the matching segment file of the backup.
 func (s *Store) backupTo(path string, polished bool) error {
-	backupFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	ranges, err := s.dataRanges()
 	if err != nil {
-		return fmt.Errorf("failed to create backup file: %v", err)
-	}
-	defer backupFile.Close()
-
-	_, err = s.file.Seek(0, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("failed to seek to start: %v", err)
-	}
-	_, err = io.Copy(backupFile, s.file)
-	if err != nil {
-		return fmt.Errorf("failed to copy data file: %v", err)
+		return fmt.Errorf("failed to stat data file: %v", err)
 	}
-
-	err = backupFile.Sync()
-	if err != nil {
-		return fmt.Errorf("failed to sync backup file: %v", err)
+	for id, r := range ranges {
+		err = s.backupSegment(s.file, segmentPath(path, id), segmentOffset(id, 0), r[1], nil)
+		if err != nil {
+			return err
+		}
 	}
+	return s.backupIndex(path + ".idx")
+}
 
-	// Backup index file
-	backupIndexPath := path + ".idx"
-	backupIndexFile, err := os.OpenFile(backupIndexPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+// backupIndex copies the index file to a new file at path.
+func (s *Store) backupIndex(path string) error {
+	backupIndexFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.opts.fileMode)
 	if err != nil {
 		return fmt.Errorf("failed to create backup index file: %v", err)
 	}
 	defer backupIndexFile.Close()
 
-	_, err = s.indexFile.Seek(0, io.SeekStart)
+	indexSize, err := s.indexFile.Size()
 	if err != nil {
-		return fmt.Errorf("failed to seek to start of index file: %v", err)
+		return fmt.Errorf("failed to stat index file: %v", err)
 	}
-	_, err = io.Copy(backupIndexFile, s.indexFile)
+	_, err = io.Copy(backupIndexFile, io.NewSectionReader(s.indexFile, 0, indexSize))
 	if err != nil {
 		return fmt.Errorf("failed to copy index file: %v", err)
 	}
@@ -486,11 +2553,55 @@ func (s *Store) backupTo(path string, polished bool) error {
 	return nil
 }
 
-// Close closes the store and releases resources.
+// backupSegment copies the bytes of data in [start, end) to a new file at path. A
+// non-nil header is written over the first bytes of the copy.
+func (s *Store) backupSegment(data io.ReaderAt, path string, start, end int64, header []byte) error {
+	backupFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.opts.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %v", err)
+	}
+	defer backupFile.Close()
+
+	_, err = io.Copy(backupFile, io.NewSectionReader(data, start, end-start))
+	if err != nil {
+		return fmt.Errorf("failed to copy data file: %v", err)
+	}
+	if header != nil {
+		_, err = backupFile.WriteAt(header, 0)
+		if err != nil {
+			return fmt.Errorf("failed to write backup header: %v", err)
+		}
+	}
+
+	err = backupFile.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync backup file: %v", err)
+	}
+	return nil
+}
+
+// Close closes the store and releases resources. A store opened with SyncNone is flushed
+// first, the background fsync of WithPeriodicSync is stopped, and WithAutoBackup takes
+// its final backup. Closing a closed store does nothing and returns nil. After Close,
+// Get, Set, and the other methods that read or write lines fail with ErrClosed.
 func (s *Store) Close() error {
+	s.stopAutoBackup()
+	s.stopPeriodicSync()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if (s.opts.syncMode == SyncNone || s.opts.commitInterval > 0) && !s.readOnly {
+		// Writes were not synced as they happened, or may still wait for a group commit
+		s.file.Sync()
+		s.indexFile.Sync()
+	}
+
 	err := s.file.Close()
 	if err != nil {
 		s.indexFile.Close() // Try to close index file even if data file fails
@@ -1,7 +1,14 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -175,3 +182,1517 @@ func TestBackup(t *testing.T) {
 		t.Errorf("expected 'value2' in full backup, got '%s'", value)
 	}
 }
+
+func TestDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	line1, err := store.Set([]byte("value1"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	line2, err := store.Set([]byte("value2"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	err = store.Delete(line1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = store.Get(line1)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted, got %v", err)
+	}
+
+	// Deleting twice is a no-op
+	err = store.Delete(line1)
+	if err != nil {
+		t.Errorf("second delete failed: %v", err)
+	}
+
+	err = store.Delete(999)
+	if err == nil {
+		t.Error("expected error on delete for non-existent line, got nil")
+	}
+	store.Close()
+
+	// Tombstones must survive a reopen
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.Get(line1)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted after reopen, got %v", err)
+	}
+	value, err := store.Get(line2)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "value2" {
+		t.Errorf("expected 'value2', got '%s'", value)
+	}
+}
+
+func TestListSkipsDeleted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for _, v := range []string{"value0", "value1", "value2"} {
+		_, err = store.Set([]byte(v))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	// Delete the middle and the final record
+	for _, line := range []uint64{1, 2} {
+		err = store.Delete(line)
+		if err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+	}
+
+	pairs, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(pairs) != 1 || pairs[0][0].(uint64) != 0 {
+		t.Errorf("expected only line 0, got %v", pairs)
+	}
+
+	reversePairs, err := store.ListAllReverse()
+	if err != nil {
+		t.Fatalf("list reverse failed: %v", err)
+	}
+	if len(reversePairs) != 1 || reversePairs[0][0].(uint64) != 0 {
+		t.Errorf("expected only line 0 in reverse, got %v", reversePairs)
+	}
+
+	all, err := store.ListIncludingDeleted()
+	if err != nil {
+		t.Fatalf("list including deleted failed: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(all))
+	}
+	for i, entry := range all {
+		deleted := entry[2].(bool)
+		if deleted != (i != 0) {
+			t.Errorf("line %d: expected deleted=%v, got %v", i, i != 0, deleted)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	line1, err := store.Set([]byte("value1"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	line2, err := store.Set([]byte("value2"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	updated, err := store.Update(line1, []byte("updated1"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if updated != line1 {
+		t.Errorf("expected update to keep line %d, got %d", line1, updated)
+	}
+	_, err = store.Update(999, []byte("nope"))
+	if err == nil {
+		t.Error("expected error on update for non-existent line, got nil")
+	}
+	store.Close()
+
+	// Line numbers and count must survive a reopen
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	lastLine, err := store.GetLastLine()
+	if err != nil {
+		t.Fatalf("get last line failed: %v", err)
+	}
+	if lastLine != line2 {
+		t.Errorf("expected last line %d, got %d", line2, lastLine)
+	}
+	value, err := store.Get(line1)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "updated1" {
+		t.Errorf("expected 'updated1', got '%s'", value)
+	}
+
+	pairs, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(pairs) != 2 || string(pairs[0][1].([]byte)) != "updated1" {
+		t.Errorf("unexpected list result: %v", pairs)
+	}
+
+	err = store.Polish()
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	value, err = store.Get(line1)
+	if err != nil {
+		t.Fatalf("get after polish failed: %v", err)
+	}
+	if string(value) != "updated1" {
+		t.Errorf("expected 'updated1' after polish, got '%s'", value)
+	}
+}
+
+func TestSetBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	_, err = store.Set([]byte("first"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	lines, err := store.SetBatch([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	if len(lines) != 3 || lines[0] != 1 || lines[2] != 3 {
+		t.Errorf("unexpected batch lines: %v", lines)
+	}
+	store.Close()
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	for i, want := range []string{"a", "b", "c"} {
+		value, err := store.Get(lines[i])
+		if err != nil {
+			t.Fatalf("get line %d failed: %v", lines[i], err)
+		}
+		if string(value) != want {
+			t.Errorf("expected '%s', got '%s'", want, value)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if store.Count() != 0 {
+		t.Errorf("expected empty store to have count 0, got %d", store.Count())
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	if store.Count() != 3 {
+		t.Errorf("expected count 3, got %d", store.Count())
+	}
+	live, err := store.LiveCount()
+	if err != nil {
+		t.Fatalf("live count failed: %v", err)
+	}
+	if live != 2 {
+		t.Errorf("expected live count 2, got %d", live)
+	}
+}
+
+func TestExists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	for line, want := range map[uint64]bool{0: true, 1: false, 2: false} {
+		exists, err := store.Exists(line)
+		if err != nil {
+			t.Fatalf("exists failed for line %d: %v", line, err)
+		}
+		if exists != want {
+			t.Errorf("line %d: expected exists=%v, got %v", line, want, exists)
+		}
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	line, err := store.Set([]byte("value1"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	store.Close()
+
+	store, err = OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("failed to open read-only store: %v", err)
+	}
+	defer store.Close()
+
+	value, err := store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "value1" {
+		t.Errorf("expected 'value1', got '%s'", value)
+	}
+	if store.Count() != 1 {
+		t.Errorf("expected count 1, got %d", store.Count())
+	}
+
+	_, err = store.Set([]byte("value2"))
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from set, got %v", err)
+	}
+	err = store.Delete(line)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from delete, got %v", err)
+	}
+	err = store.Polish()
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from polish, got %v", err)
+	}
+
+	_, err = OpenReadOnly(filepath.Join(dir, "missing.db"))
+	if err == nil {
+		t.Error("expected error opening a missing store read-only, got nil")
+	}
+}
+
+func TestConcurrentGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	values := make([][]byte, 100)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("value%d", i))
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := range values {
+				line := uint64((i + g*13) % len(values))
+				value, err := store.Get(line)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if string(value) != string(values[line]) {
+					errs <- fmt.Errorf("line %d: expected '%s', got '%s'", line, values[line], value)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(0)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = store.GetContext(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from get, got %v", err)
+	}
+	_, err = store.ListContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from list, got %v", err)
+	}
+	err = store.PolishContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from polish, got %v", err)
+	}
+
+	// The cancelled polish must leave the store as it was
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected temp file to be removed, got %v", err)
+	}
+	value, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("get after cancelled polish failed: %v", err)
+	}
+	if string(value) != "value1" {
+		t.Errorf("expected 'value1', got '%s'", value)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	// Move line 0 after the others in the data file
+	_, err = store.Update(0, []byte("updated0"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	values, err := store.GetMany([]uint64{2, 0, 1, 999, 2})
+	if err != nil {
+		t.Fatalf("get many failed: %v", err)
+	}
+	want := []string{"value2", "updated0", "", "", "value2"}
+	for i, value := range values {
+		if string(value) != want[i] {
+			t.Errorf("position %d: expected '%s', got '%s'", i, want[i], value)
+		}
+	}
+	if values[2] != nil || values[3] != nil {
+		t.Errorf("expected nil for deleted and missing lines, got %q and %q", values[2], values[3])
+	}
+}
+
+func TestRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	pairs, err := store.Range(1, 100)
+	if err != nil {
+		t.Fatalf("range failed: %v", err)
+	}
+	if len(pairs) != 2 || pairs[0][0].(uint64) != 1 || pairs[1][0].(uint64) != 3 {
+		t.Errorf("expected lines 1 and 3, got %v", pairs)
+	}
+
+	pairs, err = store.Range(3, 1)
+	if err != nil {
+		t.Fatalf("range failed: %v", err)
+	}
+	if len(pairs) != 0 {
+		t.Errorf("expected empty range, got %v", pairs)
+	}
+}
+
+func TestListPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	values := make([][]byte, 10)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("value%d", i))
+	}
+	_, err = store.SetBatch(values)
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(5)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	page, err := store.ListPage(4, 3)
+	if err != nil {
+		t.Fatalf("list page failed: %v", err)
+	}
+	if len(page) != 3 || page[0][0].(uint64) != 4 || page[1][0].(uint64) != 6 || page[2][0].(uint64) != 7 {
+		t.Errorf("expected lines 4, 6, 7, got %v", page)
+	}
+
+	page, err = store.ListPage(8, 5)
+	if err != nil {
+		t.Fatalf("list page failed: %v", err)
+	}
+	if len(page) != 2 {
+		t.Errorf("expected a short final page of 2, got %d", len(page))
+	}
+
+	page, err = store.ListPage(50, 5)
+	if err != nil {
+		t.Fatalf("list page past the end failed: %v", err)
+	}
+	if len(page) != 0 {
+		t.Errorf("expected empty page, got %v", page)
+	}
+}
+
+func TestClear(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Clear()
+	if err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	if store.Count() != 0 {
+		t.Errorf("expected count 0 after clear, got %d", store.Count())
+	}
+
+	line, err := store.Set([]byte("fresh"))
+	if err != nil {
+		t.Fatalf("set after clear failed: %v", err)
+	}
+	if line != 0 {
+		t.Errorf("expected line 0 after clear, got %d", line)
+	}
+	store.Close()
+
+	// The cleared store reopens with only the new record
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	items, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(items) != 1 || string(items[0][1].([]byte)) != "fresh" {
+		t.Errorf("expected only the new record, got %v", items)
+	}
+}
+
+func TestSince(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	last := store.Count() - 1
+
+	_, err = store.SetBatch([][]byte{[]byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	pairs, err := store.Since(last + 1)
+	if err != nil {
+		t.Fatalf("since failed: %v", err)
+	}
+	if len(pairs) != 2 || pairs[0][0].(uint64) != 2 || string(pairs[1][1].([]byte)) != "value3" {
+		t.Errorf("expected lines 2 and 3, got %v", pairs)
+	}
+
+	pairs, err = store.Since(10)
+	if err != nil {
+		t.Fatalf("since past the end failed: %v", err)
+	}
+	if len(pairs) != 0 {
+		t.Errorf("expected no pairs past the end, got %v", pairs)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("apple"), []byte("banana"), []byte("avocado"), []byte("apricot")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	// The predicate never sees deleted values
+	var seen []string
+	pairs, err := store.Filter(func(value []byte) bool {
+		seen = append(seen, string(value))
+		return value[0] == 'a'
+	})
+	if err != nil {
+		t.Fatalf("filter failed: %v", err)
+	}
+	if len(pairs) != 2 || pairs[0][0].(uint64) != 0 || pairs[1][0].(uint64) != 3 {
+		t.Errorf("expected lines 0 and 3, got %v", pairs)
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected the predicate to see 3 values, got %v", seen)
+	}
+
+	seen = nil
+	pairs, err = store.FilterLimit(func(value []byte) bool {
+		seen = append(seen, string(value))
+		return value[0] == 'a'
+	}, 1)
+	if err != nil {
+		t.Fatalf("filter limit failed: %v", err)
+	}
+	if len(pairs) != 1 || string(pairs[0][1].([]byte)) != "apple" || len(seen) != 1 {
+		t.Errorf("expected to stop after apple, got %v after seeing %v", pairs, seen)
+	}
+}
+
+func TestListReadahead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	defer func(size int) { readaheadSize = size }(readaheadSize)
+	readaheadSize = 64 // Records straddle blocks, and long values bypass them
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	var want []string
+	for i := 0; i < 50; i++ {
+		value := strings.Repeat(string(rune('a'+i%26)), i*3)
+		want = append(want, value)
+		_, err = store.Set([]byte(value))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	_, err = store.Update(10, []byte("updated"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	want[10] = "updated"
+
+	pairs, err := store.List()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(pairs) != len(want) {
+		t.Fatalf("expected %d pairs, got %d", len(want), len(pairs))
+	}
+	for i, pair := range pairs {
+		if pair[0].(uint64) != uint64(i) || string(pair[1].([]byte)) != want[i] {
+			t.Errorf("expected %q at line %d, got %v", want[i], i, pair)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	store.Close()
+
+	// Simulate a crash after a complete record but before its index entry,
+	// followed by a partially written record
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read data file failed: %v", err)
+	}
+	record := data[headerSize : headerSize+24]
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("open data file failed: %v", err)
+	}
+	f.Write(record)
+	f.Write(record[:7])
+	f.Close()
+
+	_, err = NewStore(path)
+	if err == nil {
+		t.Fatal("expected error opening a store with a partial record")
+	}
+
+	store, err = NewStore(path, WithRecovery())
+	if err != nil {
+		t.Fatalf("failed to recover store: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != 2 {
+		t.Errorf("expected 2 lines after recovery, got %d", store.Count())
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if stat.Size() != int64(len(data)) {
+		t.Errorf("expected data file truncated to %d bytes, got %d", len(data), stat.Size())
+	}
+
+	line, err := store.Set([]byte("value2"))
+	if err != nil {
+		t.Fatalf("set after recovery failed: %v", err)
+	}
+	value, err := store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if line != 2 || string(value) != "value2" {
+		t.Errorf("expected value2 at line 2, got %s at line %d", value, line)
+	}
+}
+
+func TestRecoveryReindex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	store.Close()
+
+	// Simulate a crash after the record of a third Set and half of its index entry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read data file failed: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("open data file failed: %v", err)
+	}
+	f.Write(data[headerSize : headerSize+24])
+	f.Close()
+	f, err = os.OpenFile(path+".idx", os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("open index file failed: %v", err)
+	}
+	f.Write(make([]byte, 8))
+	f.Close()
+
+	store, err = NewStore(path, WithOrphanPolicy(OrphanReindex))
+	if err != nil {
+		t.Fatalf("failed to recover store: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != 3 {
+		t.Fatalf("expected 3 lines after reindexing, got %d", store.Count())
+	}
+	value, err := store.Get(2)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "value0" {
+		t.Errorf("expected recovered value0, got %s", value)
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("expected a healthy store after recovery, got %+v", report)
+	}
+}
+
+func TestSetReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	value := bytes.Repeat([]byte("0123456789"), 10000)
+	line, err := store.SetReader(bytes.NewReader(value), uint32(len(value)))
+	if err != nil {
+		t.Fatalf("set reader failed: %v", err)
+	}
+	got, err := store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("streamed value does not match, got %d bytes", len(got))
+	}
+
+	// A short reader leaves the store unchanged
+	_, err = store.SetReader(bytes.NewReader(value[:10]), 20)
+	if err == nil {
+		t.Fatal("expected error for a short reader")
+	}
+	next, err := store.Set([]byte("after"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if next != line+1 {
+		t.Errorf("expected line %d after failed stream, got %d", line+1, next)
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("expected a healthy store, got %+v", report)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	line, err := store.Set([]byte("value1"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	size, err := store.ValueLen(line)
+	if err != nil {
+		t.Fatalf("value len failed: %v", err)
+	}
+	if size != 6 {
+		t.Errorf("expected length 6, got %d", size)
+	}
+
+	small := make([]byte, 3)
+	n, err := store.GetInto(line, small)
+	if !errors.Is(err, ErrBufferTooSmall) || n != 6 {
+		t.Errorf("expected ErrBufferTooSmall needing 6 bytes, got %d, %v", n, err)
+	}
+
+	buf := make([]byte, 16)
+	n, err = store.GetInto(line, buf)
+	if err != nil {
+		t.Fatalf("get into failed: %v", err)
+	}
+	if string(buf[:n]) != "value1" {
+		t.Errorf("expected value1, got %s", buf[:n])
+	}
+
+	err = store.Delete(line)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = store.GetInto(line, buf)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted, got %v", err)
+	}
+	_, err = store.ValueLen(line)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted from ValueLen, got %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	otherPath := filepath.Join(dir, "other.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	other, err := NewStore(otherPath, WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to create other store: %v", err)
+	}
+	defer other.Close()
+
+	_, err = store.Set([]byte("mine"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	_, err = other.SetBatch([][]byte{[]byte("theirs0"), []byte("theirs1"), []byte("theirs2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = other.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	lines, err := store.Merge(other)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if len(lines) != 2 || lines[0] != 1 || lines[1] != 2 {
+		t.Fatalf("expected lines [1 2], got %v", lines)
+	}
+	value, err := store.Get(lines[1])
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "theirs2" {
+		t.Errorf("expected theirs2, got %s", value)
+	}
+
+	_, err = store.Merge(store)
+	if err == nil {
+		t.Error("expected error merging a store into itself")
+	}
+}
+
+func TestHeadTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	head, err := store.Head(2)
+	if err != nil {
+		t.Fatalf("head failed: %v", err)
+	}
+	if len(head) != 2 || head[0][0].(uint64) != 0 || head[1][0].(uint64) != 1 {
+		t.Errorf("expected lines 0 and 1, got %v", head)
+	}
+
+	tail, err := store.Tail(2)
+	if err != nil {
+		t.Fatalf("tail failed: %v", err)
+	}
+	if len(tail) != 2 || tail[0][0].(uint64) != 1 || tail[1][0].(uint64) != 3 {
+		t.Errorf("expected lines 1 and 3, got %v", tail)
+	}
+
+	tail, err = store.Tail(10)
+	if err != nil {
+		t.Fatalf("tail failed: %v", err)
+	}
+	if len(tail) != 3 {
+		t.Errorf("expected all 3 live lines, got %v", tail)
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = NewStore(path)
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked opening a locked store, got %v", err)
+	}
+	_, err = OpenReadOnly(path, WithReadLock())
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked taking a read lock on a locked store, got %v", err)
+	}
+	reader, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("unlocked read-only open failed: %v", err)
+	}
+	reader.Close()
+	store.Close()
+
+	readers := make([]*Store, 2)
+	for i := range readers {
+		readers[i], err = OpenReadOnly(path, WithReadLock())
+		if err != nil {
+			t.Fatalf("read lock %d failed: %v", i, err)
+		}
+	}
+	_, err = NewStore(path)
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked while readers hold the store, got %v", err)
+	}
+	for _, reader := range readers {
+		reader.Close()
+	}
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("open after readers closed failed: %v", err)
+	}
+	store.Close()
+}
+
+func TestPolishWithOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	var calls, lastDone, lastTotal uint64
+	err = store.PolishWithOptions(PolishOptions{
+		SkipBackup: true,
+		Progress: func(done, total uint64) {
+			calls++
+			lastDone, lastTotal = done, total
+		},
+	})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	if calls != 3 || lastDone != 3 || lastTotal != 3 {
+		t.Errorf("expected progress up to 3/3 in 3 calls, got %d/%d in %d calls", lastDone, lastTotal, calls)
+	}
+	if _, err := os.Stat(path + ".backup"); !os.IsNotExist(err) {
+		t.Error("expected no backup file with SkipBackup")
+	}
+	if store.Count() != 2 {
+		t.Errorf("expected 2 lines after polish, got %d", store.Count())
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	// Index entries for lines 0, 2, and 4, as if lines 1 and 3 had been removed
+	index := append(store.format.encodeIndexEntry(0, 100), store.format.encodeIndexEntry(2, 200)...)
+	index = append(index, store.format.encodeIndexEntry(4, 400)...)
+	_, err = store.indexFile.WriteAt(index, 0)
+	if err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	for line, want := range map[uint64]uint64{0: 100, 2: 200, 4: 400} {
+		offset, err := store.readIndexOffset(line)
+		if err != nil {
+			t.Errorf("lookup of line %d failed: %v", line, err)
+		} else if offset != want {
+			t.Errorf("expected offset %d for line %d, got %d", want, line, offset)
+		}
+	}
+	for _, line := range []uint64{1, 3, 5} {
+		_, err = store.readIndexOffset(line)
+		if err == nil {
+			t.Errorf("expected no index entry for line %d", line)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(2, []byte("updated2"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	err = store.Compact()
+	if err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if store.Count() != 3 {
+		t.Errorf("expected 3 lines after compact, got %d", store.Count())
+	}
+	_, err = store.Get(1)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected ErrDeleted for line 1, got %v", err)
+	}
+	value, err := store.Get(2)
+	if err != nil || string(value) != "updated2" {
+		t.Errorf("expected updated2 at line 2, got %s, %v", value, err)
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.Records != 3 || stats.LiveLines != 2 {
+		t.Errorf("expected 3 records with 2 live after compact, got %+v", stats)
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("expected a clean report, got %+v", report)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Errorf("expected a second close to return nil, got %v", err)
+	}
+
+	_, err = store.Get(0)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Get, got %v", err)
+	}
+	_, err = store.Set([]byte("value1"))
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Set, got %v", err)
+	}
+	_, err = store.List()
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from List, got %v", err)
+	}
+	err = store.Delete(0)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Delete, got %v", err)
+	}
+	_, err = store.Stats()
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Stats, got %v", err)
+	}
+	_, err = store.Verify()
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Verify, got %v", err)
+	}
+	_, err = store.ContentHash()
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from ContentHash, got %v", err)
+	}
+}
+
+func TestAppendIf(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	line, err := store.AppendIf(NoLine, []byte("value0"))
+	if err != nil || line != 0 {
+		t.Fatalf("expected value0 at line 0, got %d, %v", line, err)
+	}
+	_, err = store.AppendIf(NoLine, []byte("stale"))
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a non-empty store, got %v", err)
+	}
+	line, err = store.AppendIf(0, []byte("value1"))
+	if err != nil || line != 1 {
+		t.Fatalf("expected value1 at line 1, got %d, %v", line, err)
+	}
+	_, err = store.AppendIf(0, []byte("stale"))
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a stale last line, got %v", err)
+	}
+	if store.Count() != 2 {
+		t.Errorf("expected 2 lines, got %d", store.Count())
+	}
+}
+
+func TestFirstLast(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	_, _, err = store.First()
+	if !errors.Is(err, ErrEmpty) {
+		t.Errorf("expected ErrEmpty for an empty store, got %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(0)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	err = store.Delete(3)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	line, value, err := store.First()
+	if err != nil || line != 1 || string(value) != "value1" {
+		t.Errorf("expected value1 at line 1, got %s at %d, %v", value, line, err)
+	}
+	line, value, err = store.Last()
+	if err != nil || line != 2 || string(value) != "value2" {
+		t.Errorf("expected value2 at line 2, got %s at %d, %v", value, line, err)
+	}
+
+	store.Delete(1)
+	store.Delete(2)
+	_, _, err = store.Last()
+	if !errors.Is(err, ErrEmpty) {
+		t.Errorf("expected ErrEmpty with every line deleted, got %v", err)
+	}
+}
+
+func TestAutoReindex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(1, []byte("updated"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	store.Close()
+
+	// Simulate a crash before the index entries of the last two lines were synced
+	err = os.Truncate(path+".idx", 16)
+	if err != nil {
+		t.Fatalf("truncate index failed: %v", err)
+	}
+	_, err = NewStore(path)
+	if err == nil {
+		t.Fatal("expected error opening a store with a short index")
+	}
+
+	logger := &recordingLogger{}
+	store, err = NewStore(path, WithAutoReindex(), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("failed to reindex store: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != 3 {
+		t.Fatalf("expected 3 lines after reindexing, got %d", store.Count())
+	}
+	for line, expected := range []string{"value0", "updated", "value2"} {
+		value, err := store.Get(uint64(line))
+		if err != nil || string(value) != expected {
+			t.Errorf("line %d: expected %s, got %q, %v", line, expected, value, err)
+		}
+	}
+	if len(logger.messages) != 1 || !strings.Contains(logger.messages[0], "rebuilt index") {
+		t.Errorf("expected one rebuild message, got %q", logger.messages)
+	}
+	size, err := store.IndexSize()
+	if err != nil || size != 3*16 {
+		t.Errorf("expected index size 48, got %d, %v", size, err)
+	}
+}
+
+func TestStringHelpers(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	line, err := store.SetString("value0")
+	if err != nil {
+		t.Fatalf("set string failed: %v", err)
+	}
+	value, err := store.GetString(line)
+	if err != nil || value != "value0" {
+		t.Errorf("expected value0, got %q, %v", value, err)
+	}
+	if string(store.MustGet(line)) != "value0" {
+		t.Errorf("expected MustGet to return value0")
+	}
+	_, err = store.GetString(1)
+	if err == nil {
+		t.Error("expected error for a missing line")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustGet to panic for a missing line")
+		}
+	}()
+	store.MustGet(1)
+}
+
+func TestDeleteRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	for _, dedup := range []bool{false, true} {
+		os.Remove(path)
+		os.Remove(path + ".idx")
+		var opts []Option
+		if dedup {
+			opts = append(opts, WithDedup())
+		}
+		store, err := NewStore(path, opts...)
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		// With dedup, line 4 shares its record with line 0, which must stay live
+		_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3"), []byte("value0")})
+		if err != nil {
+			t.Fatalf("set batch failed: %v", err)
+		}
+		err = store.Delete(2)
+		if err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+
+		err = store.DeleteRange(1, 4)
+		if err != nil {
+			t.Fatalf("delete range failed: %v", err)
+		}
+		err = store.DeleteRange(3, 100)
+		if err != nil {
+			t.Fatalf("delete range past the end failed: %v", err)
+		}
+		for line, live := range []bool{true, false, false, false, false} {
+			_, err = store.Get(uint64(line))
+			if live && err != nil {
+				t.Errorf("dedup %v: expected line %d to be live, got %v", dedup, line, err)
+			}
+			if !live && !errors.Is(err, ErrDeleted) {
+				t.Errorf("dedup %v: expected line %d to be deleted, got %v", dedup, line, err)
+			}
+		}
+		store.Close()
+
+		// The tombstones are durable
+		store, err = NewStore(path, opts...)
+		if err != nil {
+			t.Fatalf("failed to reopen store: %v", err)
+		}
+		live, err := store.LiveCount()
+		if err != nil || live != 1 {
+			t.Errorf("dedup %v: expected 1 live line after reopening, got %d, %v", dedup, live, err)
+		}
+		store.Close()
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.GetLastLine()
+	if !errors.Is(err, ErrEmpty) {
+		t.Errorf("expected ErrEmpty, got %v", err)
+	}
+	line, err := store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	_, err = store.Get(line + 1)
+	if !errors.Is(err, ErrOutOfRange) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrOutOfRange and ErrNotFound, got %v", err)
+	}
+	err = store.Delete(line + 1)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("expected ErrOutOfRange from Delete, got %v", err)
+	}
+
+	// Corrupt the record's type byte
+	dataOffset, err := store.readIndexOffset(line)
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+	_, err = store.file.WriteAt([]byte{0xff}, int64(dataOffset))
+	if err != nil {
+		t.Fatalf("failed to corrupt record: %v", err)
+	}
+	_, err = store.Get(line)
+	if !errors.Is(err, ErrInvalidRecord) {
+		t.Errorf("expected ErrInvalidRecord, got %v", err)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+
+	if !store.IsEmpty() {
+		t.Error("expected a new store to be empty")
+	}
+	line, err := store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	err = store.Delete(line)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if store.IsEmpty() {
+		t.Error("expected a store with a deleted line not to be empty")
+	}
+	err = store.Clear()
+	if err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	if !store.IsEmpty() {
+		t.Error("expected a cleared store to be empty")
+	}
+}
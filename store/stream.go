@@ -0,0 +1,174 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A record stream, written by WriteTo and read by ReadFrom and NewStoreFrom, holds the
+// live lines of a store independently of its on-disk layout, codec, and encryption. It
+// starts with an 8-byte header:
+//
+//	[0:4] magic "LNSR"
+//	[4]   stream version
+//	[5:8] reserved, zero
+//
+// followed by one frame per live line, in increasing line order:
+//
+//	line (8) | value length (8) | value
+//
+// with both numbers little endian and the value as the caller stored it. The stream
+// ends with a trailer frame whose line is 0xFFFFFFFFFFFFFFFF and whose length field
+// holds the number of line frames before it, so a truncated stream is detected. User
+// flags, write times, and deleted lines are not carried.
+
+const (
+	streamMagic      = "LNSR"
+	streamHeaderSize = 8
+	streamVersion    = 1
+	streamTrailer    = ^uint64(0) // Line of the trailer frame
+)
+
+// ErrBadStream is returned by ReadFrom and NewStoreFrom when the input is not a valid
+// record stream.
+var ErrBadStream = errors.New("invalid record stream")
+
+// Stores stream their lines with the standard interfaces.
+var (
+	_ io.WriterTo   = (*Store)(nil)
+	_ io.ReaderFrom = (*Store)(nil)
+)
+
+// WriteTo writes every live record to w as a record stream and returns the number of
+// bytes written. w can be wrapped in a gzip writer, a tee, or any other io.Writer. Like
+// ForEach, records are read one at a time without holding the read lock in between.
+func (s *Store) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	header := make([]byte, streamHeaderSize)
+	copy(header, streamMagic)
+	header[4] = streamVersion
+	bw.Write(header)
+
+	frame := make([]byte, 16)
+	var frames uint64
+	err := s.ForEach(func(line uint64, value []byte) error {
+		binary.LittleEndian.PutUint64(frame[0:8], line)
+		binary.LittleEndian.PutUint64(frame[8:16], uint64(len(value)))
+		bw.Write(frame)
+		_, err := bw.Write(value)
+		if err != nil {
+			return fmt.Errorf("failed to write line %d: %v", line, err)
+		}
+		frames++
+		return nil
+	})
+	if err != nil {
+		return cw.n, err
+	}
+	binary.LittleEndian.PutUint64(frame[0:8], streamTrailer)
+	binary.LittleEndian.PutUint64(frame[8:16], frames)
+	bw.Write(frame)
+	err = bw.Flush()
+	if err != nil {
+		return cw.n, fmt.Errorf("failed to write stream: %v", err)
+	}
+	return cw.n, nil
+}
+
+// ReadFrom appends the lines of a record stream read from r and returns the number of
+// bytes of the stream read. Line numbers are kept: lines missing from the stream, such
+// as those deleted before it was written, are filled with deleted records, so the
+// stream's first line must not be below Count. Lines appended before an error are left
+// in place. r is read through a buffer, which may consume bytes past the stream's end.
+func (s *Store) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: bufio.NewReader(r)}
+	header := make([]byte, streamHeaderSize)
+	_, err := io.ReadFull(cr, header)
+	if err != nil {
+		return cr.n, fmt.Errorf("failed to read stream header: %v: %w", err, ErrBadStream)
+	}
+	if string(header[0:4]) != streamMagic || header[4] != streamVersion {
+		return cr.n, fmt.Errorf("unknown stream header %x: %w", header, ErrBadStream)
+	}
+
+	frame := make([]byte, 16)
+	var frames uint64
+	err = s.importRecords(func() (uint64, []byte, error) {
+		_, err := io.ReadFull(cr, frame)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%v: %w", err, ErrBadStream)
+		}
+		line := binary.LittleEndian.Uint64(frame[0:8])
+		size := binary.LittleEndian.Uint64(frame[8:16])
+		if line == streamTrailer {
+			if size != frames {
+				return 0, nil, fmt.Errorf("trailer counts %d lines, stream has %d: %w", size, frames, ErrBadStream)
+			}
+			return 0, nil, io.EOF
+		}
+		if size > s.valueLimit() {
+			return 0, nil, fmt.Errorf("line %d: value of %d bytes: %w", line, size, ErrValueTooLarge)
+		}
+		value := make([]byte, size)
+		_, err = io.ReadFull(cr, value)
+		if err != nil {
+			return 0, nil, fmt.Errorf("line %d: %v: %w", line, err, ErrBadStream)
+		}
+		frames++
+		return line, value, nil
+	})
+	return cr.n, err
+}
+
+// NewStoreFrom creates a store at path from a record stream written by WriteTo and
+// returns it open. It refuses to overwrite an existing store, and removes the new files
+// again if the stream cannot be read. opts are passed to NewStore, so the new store can
+// use a different codec, encryption, or index layout than the one the stream came from.
+func NewStoreFrom(r io.Reader, path string, opts ...Option) (*Store, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil, fmt.Errorf("failed to import into %s: %w", path, os.ErrExist)
+	}
+	store, err := NewStore(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = store.ReadFrom(r)
+	if err != nil {
+		store.Close()
+		os.Remove(path)
+		os.Remove(indexPathFor(path, store.opts))
+		return nil, err
+	}
+	return store, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	importPath := filepath.Join(dir, "test_import.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), {0xff, '\n', 0x00}, {}})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(1)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	// The stream composes with other writers
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	n, err := store.WriteTo(zw)
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if want := int64(streamHeaderSize + 3*16 + 6 + 3 + 16); n != want {
+		t.Errorf("expected %d bytes written, got %d", want, n)
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatalf("gzip close failed: %v", err)
+	}
+	compressed := buf.Bytes()
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("gzip open failed: %v", err)
+	}
+	imported, err := NewStoreFrom(zr, importPath, WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	defer imported.Close()
+	if imported.Count() != 4 {
+		t.Errorf("expected 4 lines, got %d", imported.Count())
+	}
+	for line, want := range map[uint64]string{0: "value0", 2: "\xff\n\x00", 3: ""} {
+		value, err := imported.Get(line)
+		if err != nil || string(value) != want {
+			t.Errorf("line %d: expected %q, got %q, %v", line, want, value, err)
+		}
+	}
+	_, err = imported.Get(1)
+	if !errors.Is(err, ErrDeleted) {
+		t.Errorf("expected line 1 to be deleted, got %v", err)
+	}
+
+	// Streaming into the same lines again is refused
+	var plain bytes.Buffer
+	_, err = store.WriteTo(&plain)
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	_, err = imported.ReadFrom(bytes.NewReader(plain.Bytes()))
+	if err == nil {
+		t.Error("expected error reading lines below the line count")
+	}
+
+	// A truncated stream is rejected, and no store is left behind
+	imported.Close()
+	os.Remove(importPath)
+	os.Remove(importPath + ".idx")
+	_, err = NewStoreFrom(bytes.NewReader(plain.Bytes()[:plain.Len()-1]), importPath)
+	if !errors.Is(err, ErrBadStream) {
+		t.Errorf("expected ErrBadStream, got %v", err)
+	}
+	_, statErr := os.Stat(importPath)
+	if !os.IsNotExist(statErr) {
+		t.Errorf("expected no store after a failed import, got %v", statErr)
+	}
+}
+
+func TestStreamRejectsDataFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	store.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read data file: %v", err)
+	}
+	data[4] = 1 // A version 1 header, whose version byte matches the stream's
+	_, err = NewStoreFrom(bytes.NewReader(data), filepath.Join(dir, "import.db"))
+	if !errors.Is(err, ErrBadStream) {
+		t.Errorf("expected ErrBadStream for a data file, got %v", err)
+	}
+
+	store, err = NewMemoryStore()
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.ReadFrom(bytes.NewReader(data))
+	if !errors.Is(err, ErrBadStream) {
+		t.Errorf("expected ErrBadStream for a data file, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import "sync"
+
+// subscriberBuffer is the channel capacity given to each subscriber.
+const subscriberBuffer = 64
+
+// EventKind identifies the write that produced a LineEvent.
+type EventKind byte
+
+const (
+	EventSet    EventKind = iota // A value was appended by Set or SetBatch
+	EventUpdate                  // A line's value was replaced by Update
+	EventDelete                  // A line was deleted
+)
+
+// LineEvent describes a successful write to a line.
+type LineEvent struct {
+	Line    uint64
+	Kind    EventKind
+	Dropped uint64 // Events dropped for this subscriber since the previous delivered event
+}
+
+// subscriber is one channel returned by Subscribe.
+type subscriber struct {
+	ch      chan LineEvent
+	dropped uint64
+}
+
+// subscribers tracks the channels returned by Subscribe.
+type subscribers struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+// Subscribe returns a channel that receives a LineEvent for every successful Set,
+// SetBatch, Update, and Delete, and a cancel func that unsubscribes and closes the
+// channel. Events are sent without blocking so a slow subscriber can't stall writers:
+// when the channel's buffer is full the event is dropped, and the next delivered
+// event reports how many were dropped in its Dropped field. A subscriber that sees
+// Dropped > 0 can catch up with Since.
+func (s *Store) Subscribe() (<-chan LineEvent, func()) {
+	sub := &subscriber{ch: make(chan LineEvent, subscriberBuffer)}
+
+	s.subscribers.mu.Lock()
+	if s.subscribers.subs == nil {
+		s.subscribers.subs = make(map[*subscriber]struct{})
+	}
+	s.subscribers.subs[sub] = struct{}{}
+	s.subscribers.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.subscribers.mu.Lock()
+			delete(s.subscribers.subs, sub)
+			close(sub.ch)
+			s.subscribers.mu.Unlock()
+		})
+	}
+	return sub.ch, cancel
+}
+
+// notify sends an event for line to every subscriber without blocking.
+func (s *Store) notify(kind EventKind, line uint64) {
+	s.subscribers.mu.Lock()
+	defer s.subscribers.mu.Unlock()
+
+	for sub := range s.subscribers.subs {
+		select {
+		case sub.ch <- LineEvent{Line: line, Kind: kind, Dropped: sub.dropped}:
+			sub.dropped = 0
+		default:
+			sub.dropped++
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithSyncMode(SyncNone))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	events, cancel := store.Subscribe()
+
+	line, err := store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	_, err = store.Update(line, []byte("updated0"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(line)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	for _, kind := range []EventKind{EventSet, EventUpdate, EventDelete} {
+		event := <-events
+		if event.Line != line || event.Kind != kind || event.Dropped != 0 {
+			t.Errorf("expected kind %d for line %d, got %+v", kind, line, event)
+		}
+	}
+
+	// Writes never block on a full subscriber
+	for i := 0; i < subscriberBuffer+5; i++ {
+		_, err = store.Set([]byte("value"))
+		if err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	for i := 0; i < subscriberBuffer; i++ {
+		<-events
+	}
+	_, err = store.Set([]byte("value"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	event := <-events
+	if event.Dropped != 5 {
+		t.Errorf("expected 5 dropped events, got %d", event.Dropped)
+	}
+
+	cancel()
+	cancel()
+	_, ok := <-events
+	if ok {
+		t.Error("expected channel to be closed after cancel")
+	}
+}
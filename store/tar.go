@@ -0,0 +1,213 @@
+package store
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Entry names in an archive written by BackupArchive, in the order they are written.
+const (
+	tarManifest = "manifest.json"
+	tarData     = "data"
+	tarIndex    = "index"
+)
+
+// archiveManifest describes the store in an archive written by BackupArchive.
+type archiveManifest struct {
+	Lines       uint64 `json:"lines"`          // Count at the time of the backup
+	ContentHash string `json:"content_hash"`   // Hex ContentHash at the time of the backup
+	Hash        string `json:"hash,omitempty"` // HashID of the store, empty for SHA-256 archives written before it was recorded
+}
+
+// BackupArchive writes a backup of the store to a single tar file at path, holding the
+// data file, the index file, and a manifest with the line count and ContentHash that
+// RestoreArchive checks. A path ending in .gz or .tgz is gzip-compressed. The archive is
+// written to a temporary file and renamed into place, so path never holds a partial
+// archive. Writers are blocked while it is written. Segmented stores are not supported.
+func (s *Store) BackupArchive(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.file.(*segmentedBackend); ok {
+		return fmt.Errorf("BackupArchive does not support segmented stores; use Backup")
+	}
+	dataSize, err := s.file.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat data file: %v", err)
+	}
+	indexSize, err := s.indexFile.Size()
+	if err != nil {
+		return fmt.Errorf("failed to stat index file: %v", err)
+	}
+	hash, err := s.contentHash()
+	if err != nil {
+		return fmt.Errorf("failed to hash content: %v", err)
+	}
+	manifest, err := json.Marshal(archiveManifest{Lines: s.lineCount, ContentHash: hex.EncodeToString(hash), Hash: s.HashID()})
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+
+	tempPath := path + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.opts.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer os.Remove(tempPath) // No-op once renamed into place
+	defer file.Close()
+
+	var w io.Writer = file
+	var zw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz") {
+		zw = gzip.NewWriter(file)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	entries := []struct {
+		name string
+		r    io.Reader
+		size int64
+	}{
+		{tarManifest, bytes.NewReader(manifest), int64(len(manifest))},
+		{tarData, io.NewSectionReader(s.file, 0, dataSize), dataSize},
+		{tarIndex, io.NewSectionReader(s.indexFile, 0, indexSize), indexSize},
+	}
+	now := time.Now()
+	for _, e := range entries {
+		err = tw.WriteHeader(&tar.Header{Name: e.name, Mode: int64(s.opts.fileMode.Perm()), Size: e.size, ModTime: now})
+		if err != nil {
+			return fmt.Errorf("failed to write %s header: %v", e.name, err)
+		}
+		_, err = io.Copy(tw, e.r)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", e.name, err)
+		}
+	}
+	err = tw.Close()
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finish archive: %v", err)
+	}
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		return fmt.Errorf("failed to rename archive: %v", err)
+	}
+	return nil
+}
+
+// RestoreArchive unpacks an archive written by BackupArchive into a new store at
+// destPath and returns it open, after checking that its line count and content match
+// the manifest. Compressed archives are detected automatically. It refuses to overwrite
+// an existing store or index file, and removes the unpacked files again if the archive
+// is invalid. opts are passed to NewStore, so an encrypted store needs its key here as well.
+func RestoreArchive(archivePath, destPath string, opts ...Option) (*Store, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	indexPath := indexPathFor(destPath, o)
+	for _, p := range []string{destPath, indexPath} {
+		_, err := os.Stat(p)
+		if err == nil {
+			return nil, fmt.Errorf("failed to restore into %s: %w", p, os.ErrExist)
+		}
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	var r io.Reader = br
+	magic, _ := br.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read compressed archive: %v", err)
+		}
+		r = zr
+	}
+
+	// On failure unpackArchive has already removed the files it created
+	manifest, err := unpackArchive(tar.NewReader(r), destPath, indexPath, o.fileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewStore(destPath, opts...)
+	if err != nil {
+		os.Remove(destPath)
+		os.Remove(indexPath)
+		return nil, err
+	}
+	if manifest.Hash == "" {
+		manifest.Hash = "sha256"
+	}
+	hash, err := store.ContentHash()
+	if err == nil && manifest.Hash != store.HashID() {
+		err = fmt.Errorf("archive was hashed with %s but the store uses %s: %w", manifest.Hash, store.HashID(), ErrBadArchive)
+	} else if err == nil && (store.Count() != manifest.Lines || hex.EncodeToString(hash) != manifest.ContentHash) {
+		err = fmt.Errorf("restored store does not match the manifest: %w", ErrBadArchive)
+	}
+	if err != nil {
+		store.Close()
+		os.Remove(destPath)
+		os.Remove(indexPath)
+		return nil, err
+	}
+	return store, nil
+}
+
+// unpackArchive reads the manifest, data, and index entries of an archive, writing the
+// files of the store at path with its index at indexPath, and returns the manifest. On
+// failure no files are left.
+func unpackArchive(tr *tar.Reader, path, indexPath string, mode os.FileMode) (archiveManifest, error) {
+	var manifest archiveManifest
+	for _, name := range []string{tarManifest, tarData, tarIndex} {
+		hdr, err := tr.Next()
+		if err == nil && hdr.Name != name {
+			err = fmt.Errorf("found %s", hdr.Name)
+		}
+		if err != nil {
+			if name == tarIndex {
+				os.Remove(path)
+			}
+			return manifest, fmt.Errorf("failed to read %s entry: %v: %w", name, err, ErrBadArchive)
+		}
+
+		switch name {
+		case tarManifest:
+			err = json.NewDecoder(tr).Decode(&manifest)
+			if err != nil {
+				return manifest, fmt.Errorf("failed to decode manifest: %v: %w", err, ErrBadArchive)
+			}
+		case tarData:
+			err = restoreFile(tr, path, hdr.Size, mode)
+			if err != nil {
+				return manifest, err
+			}
+		case tarIndex:
+			err = restoreFile(tr, indexPath, hdr.Size, mode)
+			if err != nil {
+				os.Remove(path)
+				return manifest, err
+			}
+		}
+	}
+	return manifest, nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// GetWithTime retrieves the value at line along with the time it was written by Set or,
+// for an updated line, by the last Update. Stores created before records carried their
+// write time return the zero time.
+func (s *Store) GetWithTime(line uint64) ([]byte, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if line >= s.lineCount {
+		return nil, time.Time{}, fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
+	}
+	dataOffset, err := s.readIndexOffset(line)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	typeByte, value, err := s.readRecord(int64(dataOffset), line, false)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if typeByte&recordDeleted != 0 {
+		return nil, time.Time{}, deletedError(typeByte, line)
+	}
+	written, err := s.readWriteTime(int64(dataOffset), line)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return value, unixTime(written), nil
+}
+
+// RangeByTime returns the live line/value pairs written in [from, to), in line order.
+// Write times are not indexed, so the write time of every line is read; values are only
+// read for the lines that match. Lines without a write time never match.
+func (s *Store) RangeByTime(from, to time.Time) ([][2]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := [][2]interface{}{}
+	if !s.format.timestamps() {
+		return result, nil
+	}
+	for line := s.evictLine; line < s.lineCount; line++ {
+		dataOffset, err := s.readIndexOffset(line)
+		if err != nil {
+			return nil, err
+		}
+		written, err := s.readWriteTime(int64(dataOffset), line)
+		if err != nil {
+			return nil, err
+		}
+		t := unixTime(written)
+		if written == 0 || t.Before(from) || !t.Before(to) {
+			continue
+		}
+		typeByte, value, err := s.readRecord(int64(dataOffset), line, false)
+		if err != nil {
+			return nil, err
+		}
+		if typeByte&recordDeleted != 0 {
+			continue
+		}
+		result = append(result, [2]interface{}{line, value})
+	}
+	return result, nil
+}
+
+// readWriteTime returns the write time, in Unix nanoseconds, of the record starting at
+// offset, or 0 when the format has no timestamps.
+func (s *Store) readWriteTime(offset int64, line uint64) (int64, error) {
+	if !s.format.timestamps() {
+		return 0, nil
+	}
+	prefix := make([]byte, maxPrefixLen)
+	n, err := s.file.ReadAt(prefix, offset)
+	if n < 1 || int64(n) < s.format.prefixLen(prefix[0]) {
+		return 0, fmt.Errorf("failed to read write time at line %d: %v", line, err)
+	}
+	return s.format.writeTime(prefix), nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimestamps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	before := time.Now()
+	_, err = store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	middle := time.Now()
+	_, err = store.SetBatch([][]byte{[]byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	value, written, err := store.GetWithTime(0)
+	if err != nil {
+		t.Fatalf("get with time failed: %v", err)
+	}
+	if string(value) != "value0" || written.Before(before) || written.After(middle) {
+		t.Errorf("expected value0 written between %v and %v, got %s at %v", before, middle, value, written)
+	}
+
+	records, err := store.RangeByTime(middle, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("range by time failed: %v", err)
+	}
+	if len(records) != 1 || records[0][0].(uint64) != 1 {
+		t.Errorf("expected only line 1, got %v", records)
+	}
+
+	// Polish keeps the write times
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	_, polished, err := store.GetWithTime(0)
+	if err != nil {
+		t.Fatalf("get with time failed: %v", err)
+	}
+	if !polished.Equal(written) {
+		t.Errorf("expected write time %v after polish, got %v", written, polished)
+	}
+}
+
+func TestTimestampsOldFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Write a version 1 file, whose records have no write time
+	old := format{version: 1, flags: flagChecksum}
+	data := append(old.encodeHeader(), old.encodeRecord(recordActive, 0, 0, 0, []byte("old"))...)
+	err := os.WriteFile(path, data, 0666)
+	if err != nil {
+		t.Fatalf("failed to write data file: %v", err)
+	}
+	err = os.WriteFile(path+".idx", old.encodeIndexEntry(0, headerSize), 0666)
+	if err != nil {
+		t.Fatalf("failed to write index file: %v", err)
+	}
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	value, written, err := store.GetWithTime(0)
+	if err != nil {
+		t.Fatalf("get with time failed: %v", err)
+	}
+	if string(value) != "old" || !written.IsZero() {
+		t.Errorf("expected old with a zero time, got %s at %v", value, written)
+	}
+	records, err := store.RangeByTime(time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("range by time failed: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records, got %v", records)
+	}
+}
@@ -0,0 +1,45 @@
+package store
+
+import "context"
+
+// Span is a traced operation. The store sets integer attributes on it, records the
+// error the operation returned, if any, and ends it.
+type Span interface {
+	SetAttribute(key string, value int64)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts the spans the store records, so the store doesn't depend on a
+// particular tracing library. For OpenTelemetry, an adapter whose Start calls
+// trace.Tracer.Start and wraps the returned trace.Span is enough: SetAttribute maps to
+// SetAttributes(attribute.Int64(key, value)), and RecordError to RecordError followed
+// by SetStatus(codes.Error, err.Error()).
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// nopSpan is the span used when no tracer is set.
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, int64) {}
+func (nopSpan) RecordError(error)          {}
+func (nopSpan) End()                       {}
+
+// startSpan starts a span named name with ctx as its parent, or returns a no-op span
+// when the store has no tracer.
+func (s *Store) startSpan(ctx context.Context, name string) Span {
+	if s.opts.tracer == nil {
+		return nopSpan{}
+	}
+	_, span := s.opts.tracer.Start(ctx, name)
+	return span
+}
+
+// endSpan records err on span, if set, and ends it.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
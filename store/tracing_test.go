@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+type parentKey struct{}
+
+// recordedSpan keeps what the store set on it.
+type recordedSpan struct {
+	name   string
+	parent any
+	attrs  map[string]int64
+	err    error
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value int64) { s.attrs[key] = value }
+func (s *recordedSpan) RecordError(err error)                { s.err = err }
+func (s *recordedSpan) End()                                 { s.ended = true }
+
+// recordingTracer keeps every span it starts.
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordedSpan{name: name, parent: ctx.Value(parentKey{}), attrs: map[string]int64{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTracer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	tracer := &recordingTracer{}
+	store, err := NewStore(path, WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.Set([]byte("value0"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), parentKey{}, "request")
+	_, err = store.GetContext(ctx, 0)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	_, err = store.Get(5)
+	if err == nil {
+		t.Fatal("expected error for a line out of range")
+	}
+	_, err = store.ListContext(ctx)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+
+	want := []string{"linestore.Set", "linestore.Get", "linestore.Get", "linestore.List", "linestore.Polish"}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("expected %d spans, got %d", len(want), len(tracer.spans))
+	}
+	for i, span := range tracer.spans {
+		if span.name != want[i] || !span.ended {
+			t.Errorf("expected ended span %s, got %+v", want[i], span)
+		}
+	}
+	if set := tracer.spans[0]; set.attrs["linestore.line"] != 0 || set.attrs["linestore.bytes"] != 6 || set.err != nil {
+		t.Errorf("unexpected set span %+v", set)
+	}
+	if get := tracer.spans[1]; get.parent != "request" || get.attrs["linestore.bytes"] != 6 {
+		t.Errorf("expected get span under the request with 6 bytes, got %+v", get)
+	}
+	if get := tracer.spans[2]; get.parent != nil || get.attrs["linestore.line"] != 5 || get.err == nil {
+		t.Errorf("expected root get span with an error for line 5, got %+v", get)
+	}
+	if list := tracer.spans[3]; list.parent != "request" || list.attrs["linestore.lines"] != 1 {
+		t.Errorf("expected list span under the request with 1 line, got %+v", list)
+	}
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// TruncateTo discards every line after line, so line becomes the last one, undoing
+// the writes appended since a checkpoint. Usually both files are simply truncated: the
+// index after line's entry and the data file at the first record of a discarded line,
+// and both are synced. If a kept line was updated after that record was written, or
+// the store uses WithDedup, the kept lines can't be cut off the discarded ones, and the
+// store is compacted like Compact instead, without a backup.
+//
+// line must be below Count and not evicted; use Clear to discard every line. Updates and
+// deletes of kept lines are not undone. A crash while truncating leaves records without
+// index entries behind, which WithRecovery discards when the store is next opened.
+func (s *Store) TruncateTo(line uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkWritable()
+	if err != nil {
+		return err
+	}
+	if line >= s.lineCount {
+		return fmt.Errorf("line %d exceeds total lines %d: %w", line, s.lineCount, ErrOutOfRange)
+	}
+	if line < s.evictLine {
+		return fmt.Errorf("line %d: %w", line, ErrEvicted)
+	}
+	if line == s.lineCount-1 {
+		return nil
+	}
+
+	cut, ok, err := s.truncateOffset(line)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Copy the kept lines into fresh files
+		lineCount := s.lineCount
+		s.lineCount = line + 1
+		err = s.polishLocked(context.Background(), PolishOptions{KeepLineNumbers: true, SkipBackup: true})
+		if err != nil && !s.closed {
+			s.lineCount = lineCount
+		}
+		return err
+	}
+
+	s.rewrites++
+	// The index goes first, so a crash in between leaves unindexed records for recovery
+	err = s.indexFile.Truncate(s.indexPos(line + 1))
+	if err != nil {
+		return fmt.Errorf("failed to truncate index file: %v", err)
+	}
+	err = s.sync(s.indexFile)
+	if err != nil {
+		return fmt.Errorf("failed to sync index file: %v", err)
+	}
+	s.lineCount = line + 1
+	if s.offsets != nil {
+		s.offsets = s.offsets[:line+1-s.baseLine]
+	}
+	for updated := range s.sparseUpdates {
+		if updated > line {
+			delete(s.sparseUpdates, updated)
+		}
+	}
+	s.sparseHint.Store(nil)
+	err = s.file.Truncate(cut)
+	if err != nil {
+		return fmt.Errorf("failed to truncate data file: %v", err)
+	}
+	err = s.sync(s.file)
+	if err != nil {
+		return fmt.Errorf("failed to sync data file: %v", err)
+	}
+	s.updateGauges()
+	return nil
+}
+
+// truncateOffset returns the offset of the record written for line+1, where the data
+// file can be cut to drop every line after line. ok is false when the cut would also
+// drop records of kept lines: when line+1's record was replaced by Update, so its
+// original position is unknown, when a record after it updates a kept line, or when
+// lines may share records. The caller must hold the lock.
+func (s *Store) truncateOffset(line uint64) (int64, bool, error) {
+	if s.format.flags&flagShared != 0 {
+		return 0, false, nil
+	}
+	dataOffset, typeByte, err := s.readLineType(line + 1)
+	if err != nil {
+		return 0, false, err
+	}
+	if typeByte&recordUpdate != 0 {
+		return 0, false, nil
+	}
+
+	// Records are appended in line order, so everything from line+1's record on was
+	// written after it
+	ranges, err := s.dataRanges()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to stat data file: %v", err)
+	}
+	cut := int64(dataOffset)
+	for _, r := range ranges {
+		if r[1] <= cut {
+			continue
+		}
+		for offset := max(r[0], cut); offset < r[1]; {
+			info, next, ok := s.scanRecord(offset, r[1])
+			if !ok {
+				return 0, false, fmt.Errorf("unreadable record at offset %d: %w", offset, ErrInvalidRecord)
+			}
+			if info.typeByte&recordUpdate != 0 && info.target <= line {
+				return 0, false, nil
+			}
+			offset = next
+		}
+	}
+	return cut, true, nil
+}
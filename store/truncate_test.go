@@ -0,0 +1,92 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTruncateTo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	for _, tc := range []struct {
+		name   string
+		opts   []Option
+		update bool // Update a kept line after the checkpoint
+	}{
+		{name: "append only"},
+		{name: "updated", update: true},
+		{name: "dedup", opts: []Option{WithDedup()}},
+		{name: "memory index", opts: []Option{WithMemoryIndex()}},
+	} {
+		os.Remove(path)
+		os.Remove(path + ".idx")
+		store, err := NewStore(path, tc.opts...)
+		if err != nil {
+			t.Fatalf("%s: failed to create store: %v", tc.name, err)
+		}
+		for i := 0; i < 3; i++ {
+			_, err = store.Set([]byte(fmt.Sprintf("value%d", i)))
+			if err != nil {
+				t.Fatalf("%s: set failed: %v", tc.name, err)
+			}
+		}
+		checkpoint, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("%s: failed to stat data file: %v", tc.name, err)
+		}
+
+		_, err = store.SetBatch([][]byte{[]byte("bad3"), []byte("bad4")})
+		if err != nil {
+			t.Fatalf("%s: set batch failed: %v", tc.name, err)
+		}
+		if tc.update {
+			_, err = store.Update(1, []byte("updated"))
+			if err != nil {
+				t.Fatalf("%s: update failed: %v", tc.name, err)
+			}
+		}
+		err = store.TruncateTo(2)
+		if err != nil {
+			t.Fatalf("%s: truncate failed: %v", tc.name, err)
+		}
+		if store.Count() != 3 {
+			t.Errorf("%s: expected 3 lines, got %d", tc.name, store.Count())
+		}
+		if !tc.update && tc.opts == nil {
+			info, err := os.Stat(path)
+			if err != nil || info.Size() != checkpoint.Size() {
+				t.Errorf("%s: expected the data file cut back to %d bytes, got %v, %v", tc.name, checkpoint.Size(), info.Size(), err)
+			}
+		}
+
+		// The store reopens cleanly and keeps appending after the checkpoint
+		store.Close()
+		store, err = NewStore(path, tc.opts...)
+		if err != nil {
+			t.Fatalf("%s: failed to reopen store: %v", tc.name, err)
+		}
+		line, err := store.Set([]byte("value3"))
+		if err != nil || line != 3 {
+			t.Errorf("%s: expected the next set at line 3, got %d, %v", tc.name, line, err)
+		}
+		want := []string{"value0", "value1", "value2", "value3"}
+		if tc.update {
+			want[1] = "updated"
+		}
+		for i, w := range want {
+			value, err := store.Get(uint64(i))
+			if err != nil || string(value) != w {
+				t.Errorf("%s: expected %s at line %d, got %s, %v", tc.name, w, i, value, err)
+			}
+		}
+
+		err = store.TruncateTo(4)
+		if !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("%s: expected ErrOutOfRange, got %v", tc.name, err)
+		}
+		store.Close()
+	}
+}
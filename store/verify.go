@@ -0,0 +1,206 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// maxProblemLines caps how many problem line numbers a VerifyReport lists.
+const maxProblemLines = 100
+
+// VerifyReport summarizes the result of Verify.
+type VerifyReport struct {
+	Records               uint64   // Physical records found in the data file
+	IndexEntries          uint64   // Complete entries found in the index file
+	Mismatched            uint64   // Index entries whose line number or record type disagrees with the data file
+	Unreadable            uint64   // Records that could not be read (invalid type byte or truncated value)
+	Orphaned              uint64   // Index entries pointing outside the data file, between records, or past the last line
+	ChecksumFailures      uint64   // Records whose value does not match its CRC32
+	IndexChecksumFailures uint64   // Index entries that don't match their CRC32, with WithIndexChecksums
+	ProblemLines          []uint64 // The first problem line numbers found, up to 100
+}
+
+// OK reports whether Verify found no problems.
+func (r *VerifyReport) OK() bool {
+	return r.Mismatched == 0 && r.Unreadable == 0 && r.Orphaned == 0 && r.ChecksumFailures == 0 && r.IndexChecksumFailures == 0
+}
+
+// addProblem records a problem with line, keeping only the first few line numbers.
+func (r *VerifyReport) addProblem(line uint64) {
+	if len(r.ProblemLines) < maxProblemLines {
+		r.ProblemLines = append(r.ProblemLines, line)
+	}
+}
+
+// recordInfo describes a record found while scanning the data file.
+type recordInfo struct {
+	typeByte byte
+	target   uint64 // Line replaced by an update record
+	valLen   int64  // Length of the value as stored
+}
+
+// scanRecord reads the fields before the value of the record at offset and returns them
+// with the offset of the next record. ok is false when the type byte is invalid or the
+// record runs past size.
+func (s *Store) scanRecord(offset, size int64) (info recordInfo, next int64, ok bool) {
+	prefix := make([]byte, maxPrefixLen)
+	n, _ := s.file.ReadAt(prefix, offset)
+	if n < 1 || !validRecordType(prefix[0]) {
+		return recordInfo{}, 0, false
+	}
+	info.typeByte = prefix[0]
+	prefixLen := s.format.prefixLen(info.typeByte)
+	if int64(n) < prefixLen {
+		return recordInfo{}, 0, false
+	}
+	if info.typeByte&recordUpdate != 0 {
+		info.target = binary.LittleEndian.Uint64(prefix[1:9])
+	}
+	info.valLen = s.format.valueLen(prefix)
+	next = offset + prefixLen + info.valLen + s.format.trailerLen()
+	if info.valLen < 0 || next > size {
+		return recordInfo{}, 0, false
+	}
+	return info, next, true
+}
+
+// Verify checks that the data and index files are consistent. It walks every record in
+// the data file to confirm that each length field leads to the start of the next record
+// and that each value matches its checksum, then checks that every index entry carries its own line number and points at the start
+// of a readable record for that line. Problems are counted in the report rather than
+// returned as errors; the error is only set when the files cannot be read at all.
+func (s *Store) Verify() (*VerifyReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+	report := &VerifyReport{}
+
+	ranges, err := s.dataRanges()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat data file: %v", err)
+	}
+	indexSize, err := s.indexFile.Size()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat index file: %v", err)
+	}
+
+	// Walk the data file record by record
+	records := make(map[int64]recordInfo)
+	lines := uint64(0)
+scan:
+	for _, r := range ranges {
+		for offset := r[0]; offset < r[1]; {
+			info, next, ok := s.scanRecord(offset, r[1])
+			if !ok {
+				report.Unreadable++
+				report.addProblem(lines)
+				break scan
+			}
+			line := s.baseLine + lines
+			if info.typeByte&recordUpdate != 0 {
+				line = info.target
+			}
+
+			if s.format.checksums() {
+				body := make([]byte, info.valLen+4)
+				_, err = s.file.ReadAt(body, next-info.valLen-4)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read record at offset %d: %v", offset, err)
+				}
+				if crc32.ChecksumIEEE(body[:info.valLen]) != binary.LittleEndian.Uint32(body[info.valLen:]) {
+					report.ChecksumFailures++
+					report.addProblem(line)
+				}
+			}
+
+			records[offset] = info
+			report.Records++
+			if info.typeByte&recordUpdate == 0 {
+				lines++
+			}
+			offset = next
+		}
+	}
+
+	// Check every index entry against the records found. Entries of a sparse index
+	// are interval lines apart and point at the lines' own records.
+	entrySize := s.format.indexEntrySize()
+	report.IndexEntries = uint64(indexSize / entrySize)
+	every := s.format.indexEvery()
+	shared := s.format.flags&flagShared != 0
+	if shared {
+		// Lines may share records, so there can be more lines than line records, and a
+		// line may point at an update record written for another line
+		lines = max(lines, report.IndexEntries)
+	}
+	if indexSize%entrySize != 0 {
+		// A trailing partial entry belongs to no line
+		report.Orphaned++
+		report.addProblem(report.IndexEntries * every)
+	}
+	indexEntry := make([]byte, entrySize)
+	for i := uint64(0); i < report.IndexEntries; i++ {
+		line := s.baseLine + i*every
+		_, err = s.indexFile.ReadAt(indexEntry, int64(i)*entrySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index entry for line %d: %v", line, err)
+		}
+		dataOffset := int64(s.format.entryOffset(indexEntry))
+
+		info, ok := records[dataOffset]
+		switch {
+		case !s.format.entryIntact(indexEntry):
+			report.IndexChecksumFailures++
+			report.addProblem(line)
+		case i*every >= lines || !ok:
+			report.Orphaned++
+			report.addProblem(line)
+		case !s.format.entryHolds(indexEntry, line), info.typeByte&recordUpdate != 0 && (every > 1 || info.target != line && !shared):
+			report.Mismatched++
+			report.addProblem(line)
+		}
+	}
+
+	return report, nil
+}
+
+// ContentHash returns a digest of the store's logical content: the line number and
+// value of every live line, in line order. Dead space, file layout, compression, and
+// encryption don't affect it, so two stores holding the same lines and using the same
+// hash, SHA-256 unless WithHash says otherwise, hash the same.
+// Polish keeps the hash unless it renumbers lines by dropping deleted ones. Values are
+// hashed one at a time, so memory use doesn't grow with the store.
+func (s *Store) ContentHash() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+	return s.contentHash()
+}
+
+// contentHash implements ContentHash. The caller must hold the lock.
+func (s *Store) contentHash() ([]byte, error) {
+	h := s.newHash()
+	prefix := make([]byte, 16)
+	for line := s.evictLine; line < s.lineCount; line++ {
+		typeByte, value, err := s.readLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if typeByte&recordDeleted != 0 {
+			continue
+		}
+		// The length keeps values from running into the next line number
+		binary.LittleEndian.PutUint64(prefix[0:8], line)
+		binary.LittleEndian.PutUint64(prefix[8:16], uint64(len(value)))
+		h.Write(prefix)
+		h.Write(value)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,379 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(1, []byte("updated1"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("expected a healthy store, got %+v", report)
+	}
+	if report.Records != 4 || report.IndexEntries != 3 {
+		t.Errorf("expected 4 records and 3 index entries, got %+v", report)
+	}
+
+	// Point line 2's index entry into the middle of a record
+	offsetField := make([]byte, 8)
+	binary.LittleEndian.PutUint64(offsetField, 3)
+	_, err = store.indexFile.WriteAt(offsetField, 2*16+8)
+	if err != nil {
+		t.Fatalf("failed to corrupt index: %v", err)
+	}
+
+	report, err = store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if report.OK() || report.Orphaned != 1 {
+		t.Errorf("expected one orphaned entry, got %+v", report)
+	}
+	if len(report.ProblemLines) != 1 || report.ProblemLines[0] != 2 {
+		t.Errorf("expected problem line 2, got %v", report.ProblemLines)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	line, err := store.Set([]byte("value1"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	// Flip a byte inside the stored value
+	dataOffset, err := store.readIndexOffset(line)
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+	_, err = store.file.WriteAt([]byte("X"), int64(dataOffset)+14)
+	if err != nil {
+		t.Fatalf("failed to corrupt value: %v", err)
+	}
+
+	_, err = store.Get(line)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if report.ChecksumFailures != 1 || report.OK() {
+		t.Errorf("expected one checksum failure, got %+v", report)
+	}
+}
+
+func TestLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// A headerless file as written before checksums existed
+	record := []byte{recordActive, 6, 0, 0, 0}
+	record = append(record, "value1"...)
+	err := os.WriteFile(path, record, 0666)
+	if err != nil {
+		t.Fatalf("failed to write legacy data file: %v", err)
+	}
+	err = os.WriteFile(path+".idx", format{}.encodeIndexEntry(0, 0), 0666)
+	if err != nil {
+		t.Fatalf("failed to write legacy index file: %v", err)
+	}
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to open legacy store: %v", err)
+	}
+	defer store.Close()
+
+	value, err := store.Get(0)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "value1" {
+		t.Errorf("expected 'value1', got '%s'", value)
+	}
+	line, err := store.Set([]byte("value2"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() || report.Records != 2 {
+		t.Errorf("expected a healthy legacy store with 2 records, got %+v", report)
+	}
+	value, err = store.Get(line)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(value) != "value2" {
+		t.Errorf("expected 'value2', got '%s'", value)
+	}
+}
+
+func TestHeaderValidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Close()
+
+	header, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read data file failed: %v", err)
+	}
+	if len(header) != headerSize || string(header[:4]) != headerMagic {
+		t.Fatalf("expected a %d-byte header starting with %q, got %q", headerSize, headerMagic, header)
+	}
+
+	future := append([]byte(nil), header...)
+	future[4] = formatVersion + 1
+	err = os.WriteFile(path, future, 0666)
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	_, err = NewStore(path)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	err = os.WriteFile(path, header[:10], 0666)
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	_, err = NewStore(path)
+	if !errors.Is(err, ErrBadMagic) {
+		t.Errorf("expected ErrBadMagic for a truncated header, got %v", err)
+	}
+
+	err = os.WriteFile(path, []byte("PK\x03\x04 not a store"), 0666)
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	_, err = NewStore(path)
+	if !errors.Is(err, ErrBadMagic) {
+		t.Errorf("expected ErrBadMagic for a foreign file, got %v", err)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	other, err := NewMemoryStore(WithCompression(CompressionGzip))
+	if err != nil {
+		t.Fatalf("failed to create memory store: %v", err)
+	}
+	defer other.Close()
+
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("old"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(1, []byte("value1"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	_, err = other.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+
+	hash, err := store.ContentHash()
+	if err != nil {
+		t.Fatalf("content hash failed: %v", err)
+	}
+	otherHash, err := other.ContentHash()
+	if err != nil {
+		t.Fatalf("content hash failed: %v", err)
+	}
+	if !bytes.Equal(hash, otherHash) {
+		t.Errorf("expected equal hashes for the same content, got %x and %x", hash, otherHash)
+	}
+
+	// Polish drops the superseded value without changing the content
+	err = store.PolishWithOptions(PolishOptions{SkipBackup: true})
+	if err != nil {
+		t.Fatalf("polish failed: %v", err)
+	}
+	polished, err := store.ContentHash()
+	if err != nil {
+		t.Fatalf("content hash failed: %v", err)
+	}
+	if !bytes.Equal(hash, polished) {
+		t.Errorf("expected polish to keep the hash, got %x and %x", hash, polished)
+	}
+
+	err = store.Delete(2)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	deleted, err := store.ContentHash()
+	if err != nil {
+		t.Fatalf("content hash failed: %v", err)
+	}
+	if bytes.Equal(hash, deleted) {
+		t.Error("expected a delete to change the hash")
+	}
+}
+
+func TestIndexChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewStore(path, WithIndexChecksums())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+	_, err = store.Update(1, []byte("updated1"))
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	size, err := store.IndexSize()
+	if err != nil || size != 3*20 {
+		t.Errorf("expected a 60-byte index, got %d, %v", size, err)
+	}
+	store.Close()
+
+	// Flip a bit of line 1's offset
+	index, err := os.ReadFile(path + ".idx")
+	if err != nil {
+		t.Fatalf("failed to read index file: %v", err)
+	}
+	index[20+8] ^= 1
+	err = os.WriteFile(path+".idx", index, 0666)
+	if err != nil {
+		t.Fatalf("failed to write index file: %v", err)
+	}
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.Get(1)
+	if !errors.Is(err, ErrIndexCorrupt) {
+		t.Errorf("expected ErrIndexCorrupt at line 1, got %v", err)
+	}
+	_, err = store.ListAllReverse()
+	if !errors.Is(err, ErrIndexCorrupt) {
+		t.Errorf("expected ErrIndexCorrupt from ListAllReverse, got %v", err)
+	}
+	value, err := store.Get(2)
+	if err != nil || string(value) != "value2" {
+		t.Errorf("expected value2 at line 2, got %s, %v", value, err)
+	}
+	report, err := store.Verify()
+	if err != nil || report.IndexChecksumFailures != 1 || len(report.ProblemLines) != 1 || report.ProblemLines[0] != 1 {
+		t.Errorf("expected one damaged entry at line 1, got %+v, %v", report, err)
+	}
+
+	err = store.RebuildIndex()
+	if err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	value, err = store.Get(1)
+	if err != nil || string(value) != "updated1" {
+		t.Errorf("expected updated1 at line 1 after rebuilding, got %s, %v", value, err)
+	}
+	report, err = store.Verify()
+	if err != nil || !report.OK() {
+		t.Errorf("expected a healthy store after rebuilding, got %+v, %v", report, err)
+	}
+}
+
+func TestIndexChecksumsSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewStore(path, WithIndexChecksums())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	_, err = store.SetBatch([][]byte{[]byte("value0"), []byte("value1"), []byte("value2"), []byte("value3")})
+	if err != nil {
+		t.Fatalf("set batch failed: %v", err)
+	}
+
+	// Drop line 1's entry, so the later lines are no longer at their positions and
+	// have to be searched for
+	index := make([]byte, 4*20)
+	_, err = store.indexFile.ReadAt(index, 0)
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+	index = append(index[:20:20], index[40:]...)
+	writeIndex := func() {
+		_, err := store.indexFile.WriteAt(index, 0)
+		if err == nil {
+			err = store.indexFile.Truncate(int64(len(index)))
+		}
+		if err != nil {
+			t.Fatalf("failed to write index: %v", err)
+		}
+	}
+	writeIndex()
+	for _, line := range []uint64{2, 3} {
+		value, err := store.Get(line)
+		if err != nil || string(value) != fmt.Sprintf("value%d", line) {
+			t.Errorf("line %d: expected value%d, got %q, %v", line, line, value, err)
+		}
+	}
+	_, err = store.Get(1)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for the dropped line, got %v", err)
+	}
+
+	// A searched entry is still checked against its checksum
+	index[2*20+8] ^= 1
+	writeIndex()
+	_, err = store.Get(3)
+	if !errors.Is(err, ErrIndexCorrupt) {
+		t.Errorf("expected ErrIndexCorrupt, got %v", err)
+	}
+}